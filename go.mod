@@ -7,6 +7,7 @@ require (
 	github.com/oklog/ulid v1.3.1
 	go.etcd.io/bbolt v1.3.8
 	golang.org/x/exp v0.0.0-20240119083558-1b970713d09a
+	golang.org/x/text v0.14.0
 )
 
-require golang.org/x/sys v0.4.0 // indirect
+require golang.org/x/sys v0.5.0 // indirect
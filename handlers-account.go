@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// Responds with the status code matching an account deletion or email change
+// error.
+func sendAccountErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errAccountDeletionNotRequested), errors.Is(err, errEmailChangeNotRequested),
+		errors.Is(err, errEmailInUse):
+		sendErrorResponse(w, err, http.StatusConflict)
+	case errors.Is(err, errAccountDeletionCode), errors.Is(err, errEmailChangeCode):
+		sendErrorResponse(w, err, http.StatusForbidden)
+	case errors.Is(err, errAccountDeletionAttempts), errors.Is(err, errEmailChangeAttempts):
+		sendErrorResponse(w, err, http.StatusTooManyRequests)
+	default:
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+// Replies with everything stored about the authenticated user, as a JSON file.
+func handleExportAccount(w http.ResponseWriter, req *http.Request) {
+	var export *AccountExport = new(AccountExport)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = export.exportAccountTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] exporting account: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with export, as a download.
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="cooperativeparty-%s.json"`, userId))
+	encodeJsonAndRespond(w, export)
+}
+
+// Starts account deletion by emailing a confirmation code to the user.
+func handleRequestAccountDeletion(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		ExpiresTs time.Time `json:"expiresTs"`
+	}
+	var deletion *AccountDeletion = new(AccountDeletion)
+	var user *User = new(User)
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, user.UserId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transactions.
+	if err := user.getEmailTx(userBinId); err != nil {
+		fmt.Printf("[err][api] fetching user email: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err := deletion.requestAccountDeletionTx(userBinId); err != nil {
+		fmt.Printf("[err][api] updating db with account deletion request: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with the expiry of the code.
+	encodeJsonAndRespond(w, ResBody{ExpiresTs: deletion.ExpiresTs})
+
+	// Send email to user in production environment.
+	deletion.sendAccountDeletionEmail(user.Email)
+}
+
+// Deletes the authenticated user's account, given the code sent by
+// handleRequestAccountDeletion.
+func handleConfirmAccountDeletion(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Code int `json:"code"`
+	}
+	var reqBody ReqBody
+	var deletion *AccountDeletion = new(AccountDeletion)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = deletion.confirmAccountDeletionTx(userBinId, reqBody.Code)
+	if err != nil {
+		fmt.Printf("[err][api] deleting account: %v [%s]\n", err, cts())
+		sendAccountErrorResponse(w, err)
+		return
+	}
+	fmt.Printf("[api] deleted user %s [%s]\n", userId, cts())
+
+	// Success. Clear session cookie and respond with 204 No Content.
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Starts a change of email by sending a confirmation code to the new email.
+func handleRequestEmailChange(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Email string `json:"email"`
+	}
+	type ResBody struct {
+		ExpiresTs time.Time `json:"expiresTs"`
+	}
+	var reqBody ReqBody
+	var change *EmailChange = new(EmailChange)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Validate email.
+	change.NewEmail = strings.TrimSpace(reqBody.Email)
+	if err := validateEmail(change.NewEmail); err != nil {
+		sendValidationErrorResponse(w, map[string]string{"email": "Please enter a valid email address."})
+		return
+	}
+
+	// Execute db transaction.
+	err = change.requestEmailChangeTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with email change request: %v [%s]\n", err, cts())
+		sendAccountErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with the expiry of the code.
+	encodeJsonAndRespond(w, ResBody{ExpiresTs: change.ExpiresTs})
+
+	// Send email to the new address in production environment.
+	change.sendEmailChangeCodeEmail()
+}
+
+// Changes the authenticated user's email, given the code sent by
+// handleRequestEmailChange, and notifies the old email.
+func handleConfirmEmailChange(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Code int `json:"code"`
+	}
+	type ResBody struct {
+		Email string `json:"email"`
+	}
+	var reqBody ReqBody
+	var change *EmailChange = new(EmailChange)
+	var userId ulid.ULID
+	var oldEmail string
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = change.confirmEmailChangeTx(userBinId, reqBody.Code, &oldEmail)
+	if err != nil {
+		fmt.Printf("[err][api] changing email: %v [%s]\n", err, cts())
+		sendAccountErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with the new email.
+	encodeJsonAndRespond(w, ResBody{Email: change.NewEmail})
+
+	// Notify the old address in production environment.
+	change.sendEmailChangedEmail(oldEmail)
+}
+
+// Sends the account deletion code to the user's email in production
+// environment.
+func (ad *AccountDeletion) sendAccountDeletionEmail(email string) {
+	if env == nil || *env != "prod" {
+		return
+	}
+	body := fmt.Sprintf("We received a request to delete your Cooperative Party account. To confirm, please enter the following code within the hour: %v\r\nIf you did not request this, you may ignore this email.", ad.Code)
+	err := sendEmail(email, "Confirm deletion of your Cooperative Party account", body)
+	if err != nil {
+		fmt.Printf("[err][api] sending email to user: %v [%s]\n", err, cts())
+	}
+}
+
+// Sends the email change code to the new email in production environment.
+func (ec *EmailChange) sendEmailChangeCodeEmail() {
+	if env == nil || *env != "prod" {
+		return
+	}
+	body := fmt.Sprintf("To confirm this as the new email of your Cooperative Party account, please enter the following code within the hour: %v\r\nIf you did not request this, you may ignore this email.", ec.Code)
+	err := sendEmail(ec.NewEmail, "Confirm your new email for Cooperative Party", body)
+	if err != nil {
+		fmt.Printf("[err][api] sending email to user: %v [%s]\n", err, cts())
+	}
+}
+
+// Notifies the old email that the change has been made, in production
+// environment.
+func (ec *EmailChange) sendEmailChangedEmail(oldEmail string) {
+	if env == nil || *env != "prod" {
+		return
+	}
+	body := fmt.Sprintf("The email of your Cooperative Party account has been changed to %s, and this address can no longer be used to login.\r\nIf you did not make this change, please contact us right away.", ec.NewEmail)
+	err := sendEmail(oldEmail, "Your Cooperative Party email has been changed", body)
+	if err != nil {
+		fmt.Printf("[err][api] sending email to user: %v [%s]\n", err, cts())
+	}
+}
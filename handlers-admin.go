@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -12,8 +13,30 @@ import (
 	bolt "go.etcd.io/bbolt"
 )
 
-// Checks custom admin-auth header for valid token, and call next handler in chain.
+// Checks custom admin-auth header for valid token and, if the administrator
+// has enrolled in TOTP, for a valid elevation token. Call next handler in chain.
 func adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return adminIdentityMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		var adminId ulid.ULID
+
+		// Get/set adminId from context provided by adminIdentityMiddleware.
+		if err := setAdminIdFromContext(w, &adminId, r); err != nil {
+			return
+		}
+		// Require TOTP verification for privileged endpoints.
+		if err := verifyElevation(w, r, adminId); err != nil {
+			return
+		}
+
+		// Call the next handler in the chain.
+		next(w, r)
+	})
+}
+
+// Checks custom admin-auth header for valid token, and call next handler in
+// chain with adminId in context. Does not require TOTP verification, so should
+// only wrap the endpoints used to enroll in and verify TOTP.
+func adminIdentityMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	// Return a closure that captures and calls the "next" handler in the call chain.
 	return func(w http.ResponseWriter, r *http.Request) {
 		var admin *Admin = new(Admin)
@@ -48,8 +71,11 @@ func adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		// Call the next handler in the chain.
-		next(w, r)
+		// Add adminId to the request context.
+		ctx := context.WithValue(r.Context(), adminIdContextKey, admin.AdminId)
+
+		// Call the next handler in the chain with context.
+		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
@@ -157,3 +183,208 @@ func handleGetUserAuthGrp(w http.ResponseWriter, req *http.Request) {
 	// Success. Reply with user authGrp.
 	encodeJsonAndRespond(w, user.AuthGrp)
 }
+
+func handleSetModerator(w http.ResponseWriter, req *http.Request) {
+	var admin *Admin = new(Admin)
+	var userId ulid.ULID
+	fmt.Printf("[api] handling %s to %s [%s]\n", req.Method, req.URL.Path, cts())
+	strId := req.PathValue("ulid")
+
+	// Decode & unmarshal ulid from string into userId.
+	if err := unmarshalUlid(w, &userId, strId); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	binId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction. POST grants the role, DELETE revokes it.
+	err = admin.setModeratorTx(binId, req.Method == http.MethodPost)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with moderator role: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Marks (POST) or unmarks (DELETE) a user as verified, see setVerifiedTx.
+// Marking requires the legal name their identity was confirmed under.
+func handleSetVerified(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		LegalName string `json:"legalName"`
+	}
+	var reqBody ReqBody
+	var admin *Admin = new(Admin)
+	var userId ulid.ULID
+	fmt.Printf("[api] handling %s to %s [%s]\n", req.Method, req.URL.Path, cts())
+
+	if req.Method == http.MethodPost {
+		// Enforce JSON Content-Type.
+		if err := verifyContentType(w, req); err != nil {
+			return
+		}
+		// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+		if err := unmarshalJson(w, &reqBody, req); err != nil {
+			return
+		}
+
+		// Validate fields, responding with every invalid field.
+		reqBody.LegalName = normalizeText(reqBody.LegalName, false)
+		if fieldErrors := validateLegalName(reqBody.LegalName); len(fieldErrors) > 0 {
+			fmt.Printf("[err][api] validating verification: %v [%s]\n", fieldErrors, cts())
+			sendValidationErrorResponse(w, fieldErrors)
+			return
+		}
+	}
+
+	// Decode & unmarshal ulid from string into userId.
+	if err := unmarshalUlid(w, &userId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	binId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction. DELETE sends no legal name, which unmarks.
+	err = admin.setVerifiedTx(binId, reqBody.LegalName)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with verification: %v [%s]\n", err, cts())
+		if errors.Is(err, errUserNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Permanently deletes an exim and its history, e.g. for legal takedowns. The
+// purge is audited, with its reason.
+func handlePurgeExim(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Reason string `json:"reason"`
+	}
+	var reqBody ReqBody
+	var exim *Exim = new(Exim)
+	var adminId ulid.ULID
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set adminId from context provided by adminIdentityMiddleware.
+	if err := setAdminIdFromContext(w, &adminId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into exim.EximId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+
+	// Validate reason.
+	reason := normalizeText(reqBody.Reason, true)
+	if isBlank(reason) {
+		sendValidationErrorResponse(w, map[string]string{"reason": "This field cannot be blank."})
+		return
+	}
+	if !maxChars(reason, maxRemovalReasonChars) {
+		sendValidationErrorResponse(w, map[string]string{"reason": fmt.Sprintf("This field cannot be more than %d characters long.", maxRemovalReasonChars)})
+		return
+	}
+
+	// Execute db transaction.
+	err = exim.purgeEximTx(eximBinId, adminId, reason)
+	if err != nil {
+		fmt.Printf("[err][api] purging exim from db: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusNotFound)
+		return
+	}
+	fmt.Printf("[api] purged exim %s [%s]\n", exim.EximId, cts())
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Replies with a user's pending account deletion request (including its code),
+// to bypass email outside of production environment.
+func handleGetAccountDeletion(w http.ResponseWriter, req *http.Request) {
+	var deletion *AccountDeletion = new(AccountDeletion)
+	var userId ulid.ULID
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into userId.
+	if err := unmarshalUlid(w, &userId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	binId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = deletion.getAccountDeletionTx(binId)
+	if err != nil {
+		fmt.Printf("[err][api] querying db for account deletion: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusNotFound)
+		return
+	}
+
+	// Success. Reply with account deletion request.
+	encodeJsonAndRespond(w, deletion)
+}
+
+// Replies with a user's pending email change (including its code), to bypass
+// email outside of production environment.
+func handleGetEmailChange(w http.ResponseWriter, req *http.Request) {
+	var change *EmailChange = new(EmailChange)
+	var userId ulid.ULID
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into userId.
+	if err := unmarshalUlid(w, &userId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	binId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = change.getEmailChangeTx(binId)
+	if err != nil {
+		fmt.Printf("[err][api] querying db for email change: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusNotFound)
+		return
+	}
+
+	// Success. Reply with email change request.
+	encodeJsonAndRespond(w, change)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func handleGetApprovalRules(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Rules ApprovalRules `json:"rules"`
+	}
+	var resBody ResBody
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Execute db transaction.
+	err := resBody.Rules.getApprovalRulesTx()
+	if err != nil {
+		fmt.Printf("[err][api] fetching approval rules: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Always reply with a list, even if empty.
+	if resBody.Rules == nil {
+		resBody.Rules = ApprovalRules{}
+	}
+
+	// Success. Reply with rules.
+	encodeJsonAndRespond(w, resBody)
+}
+
+func handleCreateApprovalRule(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Name string `json:"name"`
+		// Exactly one of MinSupport and MinPercent.
+		MinSupport int     `json:"minSupport"`
+		MinPercent float64 `json:"minPercent"`
+		// Optional.
+		WindowDays int    `json:"windowDays"`
+		Tag        string `json:"tag"`
+		Chapter    string `json:"chapter"`
+	}
+	var reqBody ReqBody
+	var rule *ApprovalRule = new(ApprovalRule)
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+
+	// Update instance fields.
+	rule.Name = reqBody.Name
+	rule.MinSupport = reqBody.MinSupport
+	rule.MinPercent = reqBody.MinPercent
+	rule.WindowDays = reqBody.WindowDays
+	rule.Tag = reqBody.Tag
+	rule.Chapter = reqBody.Chapter
+
+	// Validate fields, responding with every invalid field.
+	rule.normalize()
+	if fieldErrors := rule.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating approval rule: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err := rule.createApprovalRuleTx()
+	if err != nil {
+		fmt.Printf("[err][api] updating db with new approval rule: %v [%s]\n", err, cts())
+		switch {
+		case errors.Is(err, errTagNotFound):
+			sendValidationErrorResponse(w, map[string]string{"tag": err.Error()})
+		case errors.Is(err, errChapterNotFound):
+			sendValidationErrorResponse(w, map[string]string{"chapter": err.Error()})
+		default:
+			sendErrorResponse(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Success. Reply with rule.
+	encodeJsonAndRespond(w, rule)
+}
+
+func handleDeleteApprovalRule(w http.ResponseWriter, req *http.Request) {
+	var rule *ApprovalRule = new(ApprovalRule)
+
+	fmt.Printf("[api] handling DELETE to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into rule.RuleId.
+	if err := unmarshalUlid(w, &rule.RuleId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	ruleBinId, err := getBinId(w, rule.RuleId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = rule.deleteApprovalRuleTx(ruleBinId)
+	if err != nil {
+		fmt.Printf("[err][api] deleting approval rule from db: %v [%s]\n", err, cts())
+		if errors.Is(err, errApprovalRuleNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Evaluates the approval rules now, rather than waiting for the background
+// evaluator, see runApprovalEvaluator.
+func handleEvaluateApprovalRules(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Approved int `json:"approved"`
+	}
+	var resBody ResBody
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Execute db transaction.
+	approved, err := evaluateApprovalRulesTx()
+	if err != nil {
+		fmt.Printf("[err][api] evaluating approval rules: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	resBody.Approved = approved
+
+	// Success. Reply with the number of exims approved.
+	encodeJsonAndRespond(w, resBody)
+}
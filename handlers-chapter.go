@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/oklog/ulid"
+)
+
+func handleGetChapters(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Chapters Chapters `json:"chapters"`
+	}
+	var resBody ResBody
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Execute db transaction.
+	err := resBody.Chapters.getChaptersTx()
+	if err != nil {
+		fmt.Printf("[err][api] fetching chapters: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Always reply with a list, even if empty.
+	if resBody.Chapters == nil {
+		resBody.Chapters = Chapters{}
+	}
+
+	// Success. Reply with chapters.
+	encodeJsonAndRespond(w, resBody)
+}
+
+func handleGetChapter(w http.ResponseWriter, req *http.Request) {
+	var chapter *Chapter = new(Chapter)
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	chapter.Slug = req.PathValue("slug")
+
+	// Execute db transaction.
+	err := chapter.getChapterTx()
+	if err != nil {
+		fmt.Printf("[err][api] fetching chapter: %v [%s]\n", err, cts())
+		if errors.Is(err, errChapterNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with chapter.
+	encodeJsonAndRespond(w, chapter)
+}
+
+func handleCreateChapter(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Slug        string       `json:"slug"`
+		Name        string       `json:"name"`
+		Description string       `json:"description"`
+		Rules       ChapterRules `json:"rules"`
+	}
+	var reqBody ReqBody
+	var chapter *Chapter = new(Chapter)
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+
+	// Update instance fields.
+	chapter.Slug = reqBody.Slug
+	chapter.Name = reqBody.Name
+	chapter.Description = reqBody.Description
+	chapter.Rules = reqBody.Rules
+
+	// Validate fields, responding with every invalid field.
+	chapter.normalize()
+	if fieldErrors := chapter.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating chapter: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err := chapter.createChapterTx()
+	if err != nil {
+		fmt.Printf("[err][api] updating db with new chapter: %v [%s]\n", err, cts())
+		if errors.Is(err, errChapterExists) {
+			sendErrorResponse(w, err, http.StatusConflict)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with chapter, including the members assigned to it.
+	encodeJsonAndRespond(w, chapter)
+}
+
+// Updates a chapter's name, description and rules; the slug cannot be
+// changed. Members are re-assigned according to the new rules.
+func handleUpdateChapter(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Name        string       `json:"name"`
+		Description string       `json:"description"`
+		Rules       ChapterRules `json:"rules"`
+	}
+	var reqBody ReqBody
+	var chapter *Chapter = new(Chapter)
+
+	fmt.Printf("[api] handling PUT to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+
+	// Update instance fields.
+	chapter.Slug = req.PathValue("slug")
+	chapter.Name = reqBody.Name
+	chapter.Description = reqBody.Description
+	chapter.Rules = reqBody.Rules
+
+	// Validate fields, responding with every invalid field.
+	chapter.normalize()
+	if fieldErrors := chapter.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating chapter: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err := chapter.updateChapterTx()
+	if err != nil {
+		fmt.Printf("[err][api] updating db with edited chapter: %v [%s]\n", err, cts())
+		if errors.Is(err, errChapterNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with updated chapter.
+	encodeJsonAndRespond(w, chapter)
+}
+
+// Deletes a chapter, unscoping every exim scoped to it.
+func handleDeleteChapter(w http.ResponseWriter, req *http.Request) {
+	var chapter *Chapter = new(Chapter)
+
+	fmt.Printf("[api] handling DELETE to %s [%s]\n", req.URL.Path, cts())
+
+	chapter.Slug = req.PathValue("slug")
+
+	// Execute db transaction.
+	err := chapter.deleteChapterTx()
+	if err != nil {
+		fmt.Printf("[err][api] deleting chapter from db: %v [%s]\n", err, cts())
+		if errors.Is(err, errChapterNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Replies with the authenticated user's address and chapter.
+func handleGetAddress(w http.ResponseWriter, req *http.Request) {
+	var location *MemberLocation = new(MemberLocation)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = location.getLocationTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching address: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with address and chapter (either may be null).
+	encodeJsonAndRespond(w, location)
+}
+
+// Sets (PUT) or removes (DELETE) the authenticated user's address, assigning
+// them to the chapter which covers it (if any).
+func handleSetAddress(w http.ResponseWriter, req *http.Request) {
+	var location *MemberLocation = new(MemberLocation)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling %s to %s [%s]\n", req.Method, req.URL.Path, cts())
+
+	if req.Method == http.MethodPut {
+		location.Address = new(Address)
+
+		// Enforce JSON Content-Type.
+		if err := verifyContentType(w, req); err != nil {
+			return
+		}
+		// Decode & unmarshal JSON request body (stream) into Address struct.
+		if err := unmarshalJson(w, location.Address, req); err != nil {
+			return
+		}
+
+		// Validate fields, responding with every invalid field.
+		location.Address.normalize()
+		if fieldErrors := location.Address.validate(); len(fieldErrors) > 0 {
+			fmt.Printf("[err][api] validating address: %v [%s]\n", fieldErrors, cts())
+			sendValidationErrorResponse(w, fieldErrors)
+			return
+		}
+	}
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = location.updateAddressTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with address: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with address and assigned chapter.
+	encodeJsonAndRespond(w, location)
+}
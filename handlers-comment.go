@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Responds with the status code matching a comment error.
+func sendCommentErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errEximNotFound), errors.Is(err, errCommentNotFound):
+		sendErrorResponse(w, err, http.StatusNotFound)
+	case errors.Is(err, errCommentNotPermitted):
+		sendErrorResponse(w, err, http.StatusForbidden)
+	case errors.Is(err, errEximRemoved), errors.Is(err, errEximNotCommentable),
+		errors.Is(err, errCommentEditWindow), errors.Is(err, errCommentDeleted):
+		sendErrorResponse(w, err, http.StatusConflict)
+	case errors.Is(err, errCommentTooDeep):
+		sendValidationErrorResponse(w, map[string]string{"parentId": err.Error()})
+	default:
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+func handleGetEximComments(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Comments Comments `json:"comments"`
+	}
+	var resBody ResBody
+	var exim *Exim = new(Exim)
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into exim.EximId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = resBody.Comments.getEximCommentsTx(eximBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching exim comments: %v [%s]\n", err, cts())
+		sendCommentErrorResponse(w, err)
+		return
+	}
+
+	// Always reply with a list, even if empty.
+	if resBody.Comments == nil {
+		resBody.Comments = Comments{}
+	}
+
+	// Success. Reply with threaded comments.
+	encodeJsonAndRespond(w, resBody)
+}
+
+func handleCreateComment(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Body string `json:"body"`
+		// Optional, the comment being replied to.
+		ParentId string `json:"parentId"`
+	}
+	var reqBody ReqBody
+	var comment *Comment = new(Comment)
+	var exim *Exim = new(Exim)
+	var user *User = new(User)
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into exim.EximId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+
+	// Update instance fields.
+	comment.AuthorId = user.UserId.String()
+	comment.ParentId = reqBody.ParentId
+	comment.Body = reqBody.Body
+
+	// Validate fields, responding with every invalid field.
+	comment.normalize()
+	if fieldErrors := comment.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating comment: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err = comment.createCommentTx(eximBinId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with new comment: %v [%s]\n", err, cts())
+		sendCommentErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with comment.
+	encodeJsonAndRespond(w, comment)
+}
+
+// Replaces a comment's body, see commentEditWindow.
+func handleEditComment(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Body string `json:"body"`
+	}
+	var reqBody ReqBody
+	var comment *Comment = new(Comment)
+	var exim *Exim = new(Exim)
+	var user *User = new(User)
+
+	fmt.Printf("[api] handling PUT to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulids from strings into exim.EximId and comment.CommentId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+	if err := unmarshalUlid(w, &comment.CommentId, req.PathValue("commentId")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+	commentBinId, err := getBinId(w, comment.CommentId)
+	if err != nil {
+		return
+	}
+
+	// Validate fields, responding with every invalid field.
+	comment.Body = reqBody.Body
+	comment.normalize()
+	if fieldErrors := comment.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating comment: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err = comment.editCommentTx(eximBinId, commentBinId, user.UserId.String())
+	if err != nil {
+		fmt.Printf("[err][api] updating db with edited comment: %v [%s]\n", err, cts())
+		sendCommentErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with updated comment.
+	encodeJsonAndRespond(w, comment)
+}
+
+// Deletes a comment's body, leaving any replies in place.
+func handleDeleteComment(w http.ResponseWriter, req *http.Request) {
+	var comment *Comment = new(Comment)
+	var exim *Exim = new(Exim)
+	var user *User = new(User)
+
+	fmt.Printf("[api] handling DELETE to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulids from strings into exim.EximId and comment.CommentId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+	if err := unmarshalUlid(w, &comment.CommentId, req.PathValue("commentId")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+	commentBinId, err := getBinId(w, comment.CommentId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, user.UserId)
+	if err != nil {
+		return
+	}
+
+	// Moderators may delete any comment, but deleting someone else's comment
+	// is a privileged action which requires TOTP verification (if enrolled).
+	isModerator := user.moderatorTx(userBinId) == nil
+	if isModerator {
+		if err := comment.getCommentTx(eximBinId, commentBinId); err != nil {
+			fmt.Printf("[err][api] fetching comment: %v [%s]\n", err, cts())
+			sendCommentErrorResponse(w, err)
+			return
+		}
+		if comment.AuthorId != user.UserId.String() {
+			if err := verifyElevation(w, req, user.UserId); err != nil {
+				return
+			}
+		}
+	}
+
+	// Execute db transaction.
+	err = comment.deleteCommentTx(eximBinId, commentBinId, user.UserId.String(), isModerator)
+	if err != nil {
+		fmt.Printf("[err][api] deleting comment: %v [%s]\n", err, cts())
+		sendCommentErrorResponse(w, err)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
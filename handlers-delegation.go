@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/oklog/ulid"
+)
+
+// Sends the response for errors from the delegation transactions.
+func sendDelegationErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errDelegationNotFound):
+		sendErrorResponse(w, err, http.StatusNotFound)
+	case errors.Is(err, errUserNotFound):
+		sendValidationErrorResponse(w, map[string]string{"delegateId": err.Error()})
+	case errors.Is(err, errDelegateSelf):
+		sendValidationErrorResponse(w, map[string]string{"delegateId": err.Error()})
+	case errors.Is(err, errTagNotFound):
+		sendErrorResponse(w, err, http.StatusNotFound)
+	default:
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+// Replies with the authenticated user's delegations, and who currently holds
+// each of them.
+func handleGetDelegations(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Delegations Delegations `json:"delegations"`
+	}
+	var resBody ResBody
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = resBody.Delegations.getDelegationsTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching delegations: %v [%s]\n", err, cts())
+		sendDelegationErrorResponse(w, err)
+		return
+	}
+
+	// Always reply with a list, even if empty.
+	if resBody.Delegations == nil {
+		resBody.Delegations = Delegations{}
+	}
+
+	// Success. Reply with delegations.
+	encodeJsonAndRespond(w, resBody)
+}
+
+// Delegates the authenticated user's support (PUT) or removes the delegation
+// (DELETE), globally or for the tag in the path.
+func handleSetDelegation(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		DelegateId string `json:"delegateId"`
+	}
+	var reqBody ReqBody
+	var delegation *Delegation = new(Delegation)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling %s to %s [%s]\n", req.Method, req.URL.Path, cts())
+
+	if req.Method == http.MethodPut {
+		// Enforce JSON Content-Type.
+		if err := verifyContentType(w, req); err != nil {
+			return
+		}
+		// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+		if err := unmarshalJson(w, &reqBody, req); err != nil {
+			return
+		}
+	}
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Update instance fields.
+	delegation.DelegateId = reqBody.DelegateId
+	delegation.Tag = req.PathValue("tag")
+
+	// Execute db transaction.
+	if req.Method == http.MethodPut {
+		err = delegation.setDelegationTx(userBinId)
+	} else {
+		err = delegation.removeDelegationTx(userBinId)
+	}
+	if err != nil {
+		fmt.Printf("[err][api] updating db with delegation: %v [%s]\n", err, cts())
+		sendDelegationErrorResponse(w, err)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// Sends the response for errors from the event transactions.
+func sendEventErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errEventNotFound), errors.Is(err, errRSVPNotFound):
+		sendErrorResponse(w, err, http.StatusNotFound)
+	case errors.Is(err, errEventNotPermitted):
+		sendErrorResponse(w, err, http.StatusForbidden)
+	case errors.Is(err, errEventStarted), errors.Is(err, errEventCancelled), errors.Is(err, errEventFull), errors.Is(err, errRSVPExists):
+		sendErrorResponse(w, err, http.StatusConflict)
+	case errors.Is(err, errEximNotFound):
+		sendValidationErrorResponse(w, map[string]string{"eximIds": err.Error()})
+	case errors.Is(err, errChapterNotFound):
+		sendValidationErrorResponse(w, map[string]string{"chapter": err.Error()})
+	case errors.Is(err, errEventCapacity):
+		sendValidationErrorResponse(w, map[string]string{"capacity": err.Error()})
+	default:
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+// Checks that the user may edit or cancel an event: its organizer may, and so
+// may moderators, for whom managing someone else's event is a privileged
+// action which requires TOTP verification (if enrolled). Send error response
+// if not.
+func verifyEventOrganizer(w http.ResponseWriter, req *http.Request, eventBinId []byte, user *User, userBinId []byte) error {
+	var event *Event = new(Event)
+
+	if err := event.getEventTx(eventBinId); err != nil {
+		fmt.Printf("[err][api] fetching event: %v [%s]\n", err, cts())
+		sendEventErrorResponse(w, err)
+		return err
+	}
+	if event.OrganizerId == user.UserId.String() {
+		return nil
+	}
+	if err := user.moderatorTx(userBinId); err != nil {
+		sendErrorResponse(w, errEventNotPermitted, http.StatusForbidden)
+		return errEventNotPermitted
+	}
+	return verifyElevation(w, req, user.UserId)
+}
+
+// Replies with the events which have not ended, soonest first, optionally only
+// those of the chapter in the chapter query parameter.
+func handleGetEvents(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Events Events `json:"events"`
+	}
+	var resBody ResBody
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Execute db transaction.
+	err := resBody.Events.getUpcomingEventsTx(req.URL.Query().Get("chapter"))
+	if err != nil {
+		fmt.Printf("[err][api] fetching events: %v [%s]\n", err, cts())
+		if errors.Is(err, errChapterNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with events.
+	encodeJsonAndRespond(w, resBody)
+}
+
+func handleGetEvent(w http.ResponseWriter, req *http.Request) {
+	var event *Event = new(Event)
+	var eventId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into eventId.
+	if err := unmarshalUlid(w, &eventId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	eventBinId, err := getBinId(w, eventId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = event.getEventTx(eventBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching event: %v [%s]\n", err, cts())
+		sendEventErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with event.
+	encodeJsonAndRespond(w, event)
+}
+
+// Creates an event organized by the authenticated user, see createEventTx.
+func handleCreateEvent(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Title       string    `json:"title"`
+		Description string    `json:"description"`
+		StartTs     time.Time `json:"startTs"`
+		EndTs       time.Time `json:"endTs"`
+		Location    string    `json:"location"`
+		OnlineLink  string    `json:"onlineLink"`
+		Chapter     string    `json:"chapter"`
+		EximIds     []string  `json:"eximIds"`
+		Capacity    int       `json:"capacity"`
+	}
+	var reqBody ReqBody
+	var event *Event = new(Event)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	event.Title = reqBody.Title
+	event.Description = reqBody.Description
+	event.StartTs = reqBody.StartTs
+	event.EndTs = reqBody.EndTs
+	event.Location = reqBody.Location
+	event.OnlineLink = reqBody.OnlineLink
+	event.Chapter = reqBody.Chapter
+	event.EximIds = reqBody.EximIds
+	event.Capacity = reqBody.Capacity
+	event.OrganizerId = userId.String()
+	if event.EximIds == nil {
+		event.EximIds = []string{}
+	}
+
+	// Validate fields, responding with every invalid field.
+	event.normalize()
+	if fieldErrors := event.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating event: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err = event.createEventTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with new event: %v [%s]\n", err, cts())
+		sendEventErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with event.
+	encodeJsonAndRespond(w, event)
+}
+
+// Edits an event's details, see editEventTx.
+func handleEditEvent(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Title       string    `json:"title"`
+		Description string    `json:"description"`
+		StartTs     time.Time `json:"startTs"`
+		EndTs       time.Time `json:"endTs"`
+		Location    string    `json:"location"`
+		OnlineLink  string    `json:"onlineLink"`
+		EximIds     []string  `json:"eximIds"`
+		Capacity    int       `json:"capacity"`
+	}
+	var reqBody ReqBody
+	var event *Event = new(Event)
+	var eventId ulid.ULID
+	var user *User = new(User)
+
+	fmt.Printf("[api] handling PUT to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into eventId.
+	if err := unmarshalUlid(w, &eventId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eventBinId, err := getBinId(w, eventId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, user.UserId)
+	if err != nil {
+		return
+	}
+
+	if err := verifyEventOrganizer(w, req, eventBinId, user, userBinId); err != nil {
+		return
+	}
+
+	event.Title = reqBody.Title
+	event.Description = reqBody.Description
+	event.StartTs = reqBody.StartTs
+	event.EndTs = reqBody.EndTs
+	event.Location = reqBody.Location
+	event.OnlineLink = reqBody.OnlineLink
+	event.EximIds = reqBody.EximIds
+	event.Capacity = reqBody.Capacity
+	if event.EximIds == nil {
+		event.EximIds = []string{}
+	}
+
+	// Validate fields, responding with every invalid field. The chapter
+	// cannot be changed, so is not validated.
+	event.normalize()
+	fieldErrors := event.validate()
+	delete(fieldErrors, "chapter")
+	if len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating event: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err = event.editEventTx(eventBinId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with event: %v [%s]\n", err, cts())
+		sendEventErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with event.
+	encodeJsonAndRespond(w, event)
+}
+
+// Cancels an event, see cancelEventTx.
+func handleCancelEvent(w http.ResponseWriter, req *http.Request) {
+	var event *Event = new(Event)
+	var eventId ulid.ULID
+	var user *User = new(User)
+
+	fmt.Printf("[api] handling DELETE to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into eventId.
+	if err := unmarshalUlid(w, &eventId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eventBinId, err := getBinId(w, eventId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, user.UserId)
+	if err != nil {
+		return
+	}
+
+	if err := verifyEventOrganizer(w, req, eventBinId, user, userBinId); err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = event.cancelEventTx(eventBinId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with cancelled event: %v [%s]\n", err, cts())
+		sendEventErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with cancelled event.
+	encodeJsonAndRespond(w, event)
+}
+
+// Replies with when the authenticated user RSVP'd to an event, if they have.
+func handleGetRSVP(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		HasRSVP bool   `json:"hasRSVP"`
+		RSVPTs  string `json:"rsvpTs,omitempty"`
+	}
+	var resBody ResBody
+	var eventId ulid.ULID
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into eventId.
+	if err := unmarshalUlid(w, &eventId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eventBinId, err := getBinId(w, eventId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	resBody.RSVPTs, err = getRSVPTx(eventBinId, userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching rsvp: %v [%s]\n", err, cts())
+		sendEventErrorResponse(w, err)
+		return
+	}
+	resBody.HasRSVP = resBody.RSVPTs != ""
+
+	// Success. Reply with RSVP status.
+	encodeJsonAndRespond(w, resBody)
+}
+
+// RSVPs (POST) or withdraws the RSVP of (DELETE) the authenticated user to an
+// event, see rsvpEventTx.
+func handleRSVPEvent(w http.ResponseWriter, req *http.Request) {
+	var eventId ulid.ULID
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling %s to %s [%s]\n", req.Method, req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into eventId.
+	if err := unmarshalUlid(w, &eventId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eventBinId, err := getBinId(w, eventId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	if req.Method == http.MethodPost {
+		err = rsvpEventTx(eventBinId, userBinId)
+	} else {
+		err = withdrawRSVPTx(eventBinId, userBinId)
+	}
+	if err != nil {
+		fmt.Printf("[err][api] updating db with rsvp: %v [%s]\n", err, cts())
+		sendEventErrorResponse(w, err)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Replies with the events which have not ended and which the authenticated
+// user organizes or has RSVP'd to, soonest first.
+func handleGetMemberEvents(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Events Events `json:"events"`
+	}
+	var resBody ResBody
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = resBody.Events.getMemberEventsTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching member events: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with events.
+	encodeJsonAndRespond(w, resBody)
+}
+
+// Replies with the path of the authenticated user's calendar feed, which
+// includes a token so that calendar apps can subscribe to it without logging
+// in (see handleGetMemberFeed).
+func handleGetCalendarFeed(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		FeedPath string `json:"feedPath"`
+	}
+	var resBody ResBody
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Success. Reply with feed path.
+	resBody.FeedPath = fmt.Sprintf("/api/user/%s/events.ics?token=%s", userId, eventFeedToken(userId))
+	encodeJsonAndRespond(w, resBody)
+}
+
+// Replies with a chapter's events as an iCalendar feed.
+func handleGetChapterFeed(w http.ResponseWriter, req *http.Request) {
+	var events Events
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	slug := req.PathValue("slug")
+
+	// Execute db transaction.
+	err := events.getChapterFeedTx(slug)
+	if err != nil {
+		fmt.Printf("[err][api] fetching chapter events: %v [%s]\n", err, cts())
+		if errors.Is(err, errChapterNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with feed.
+	sendICalResponse(w, events.ical(fmt.Sprintf("Cooperative Party: %s", slug)))
+}
+
+// Replies with the events a member organizes or has RSVP'd to as an
+// iCalendar feed. Calendar apps cannot log in, so the feed is authorized by
+// the token in the token query parameter instead (see handleGetCalendarFeed).
+func handleGetMemberFeed(w http.ResponseWriter, req *http.Request) {
+	var events Events
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into userId.
+	if err := unmarshalUlid(w, &userId, req.PathValue("ulid")); err != nil {
+		return
+	}
+	if !verifyEventFeedToken(userId, req.URL.Query().Get("token")) {
+		fmt.Printf("[err][api] verifying calendar feed token [%s]\n", cts())
+		sendErrorResponse(w, fmt.Errorf("invalid calendar feed token"), http.StatusForbidden)
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = events.getMemberFeedTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching member events: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with feed.
+	sendICalResponse(w, events.ical("Cooperative Party: my events"))
+}
+
+func sendICalResponse(w http.ResponseWriter, ical []byte) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="events.ics"`)
+	w.Write(ical)
+}
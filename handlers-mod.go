@@ -1,21 +1,31 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/oklog/ulid"
 )
 
 func handleCreateExim(w http.ResponseWriter, req *http.Request) {
 	type ReqBody struct {
-		Target     string `json:"target"`
-		Title      string `json:"title"`
-		Summary    string `json:"summary"`
-		Paragraph1 string `json:"paragraph1"`
-		Paragraph2 string `json:"paragraph2"`
-		Paragraph3 string `json:"paragraph3"`
-		Link       string `json:"link"`
+		Target   string       `json:"target"`
+		Title    string       `json:"title"`
+		Summary  string       `json:"summary"`
+		Sections EximSections `json:"sections"`
+		Link     string       `json:"link"`
+		// Optional, slugs of existing tags.
+		Tags []string `json:"tags"`
+		// Optional, slug of an existing chapter to scope the target to.
+		Chapter string `json:"chapter"`
+		// Optional, either "draft" or "submitted" (default).
+		State string `json:"state"`
+		// Optional, hides the author wherever the exim is shown.
+		IsAnonymous bool `json:"isAnonymous"`
 	}
 	type ResBody struct {
 		EximId string `json:"eximId"`
@@ -41,25 +51,41 @@ func handleCreateExim(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Create ULID and db key(s).
-	id, binId := createUlid()
-
 	// Update instance fields.
-	exim.EximId = id
-	exim.Author = userId.String()
-	exim.IsApproved = false
 	exim.Target = reqBody.Target
 	exim.Title = reqBody.Title
 	exim.Summary = reqBody.Summary
-	exim.Paragraph1 = reqBody.Paragraph1
-	exim.Paragraph2 = reqBody.Paragraph2
-	exim.Paragraph3 = reqBody.Paragraph3
+	exim.Sections = reqBody.Sections
 	exim.Link = reqBody.Link
+	exim.Tags = reqBody.Tags
+	exim.Chapter = reqBody.Chapter
+	exim.State = reqBody.State
+	exim.IsAnonymous = reqBody.IsAnonymous
+
+	// Validate fields, responding with every invalid field.
+	exim.normalize()
+	fieldErrors := exim.validate()
+	if exim.State != "" && exim.State != eximStateDraft && exim.State != eximStateSubmitted {
+		fieldErrors["state"] = fmt.Sprintf("This field must be either %s or %s.", eximStateDraft, eximStateSubmitted)
+	}
+	if len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating exim: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
 
 	// Execute db transaction.
-	err := exim.createEximTx(binId)
+	err := exim.create(userId)
 	if err != nil {
 		fmt.Printf("[err][api] updating db with new exim: %v [%s]\n", err, cts())
+		if errors.Is(err, errTagNotFound) {
+			sendValidationErrorResponse(w, map[string]string{"tags": err.Error()})
+			return
+		}
+		if errors.Is(err, errChapterNotFound) {
+			sendValidationErrorResponse(w, map[string]string{"chapter": err.Error()})
+			return
+		}
 		sendErrorResponse(w, err, http.StatusInternalServerError)
 		return
 	}
@@ -71,23 +97,105 @@ func handleCreateExim(w http.ResponseWriter, req *http.Request) {
 }
 
 func handleGetExims(w http.ResponseWriter, req *http.Request) {
-	type ResBody struct {
-		Exims Exims `json:"exims"`
-	}
-	var resBody ResBody
+	var page *EximPage = new(EximPage)
+	var query EximQuery
 
 	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
 
+	// Parse paging, sorting and filtering parameters.
+	if err := parseEximQuery(&query, req); err != nil {
+		fmt.Printf("[err][api] parsing exims query: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	// Drafts are only listed to their author and to moderators.
+	if viewerId := getViewerId(req); viewerId != (ulid.ULID{}) {
+		query.Viewer = viewerId.String()
+	}
+
 	// Execute db transaction.
-	err := resBody.Exims.getEximsTx()
+	err := page.getEximPageTx(query)
 	if err != nil {
 		fmt.Printf("[err][api] fetching exims: %v [%s]\n", err, cts())
 		sendErrorResponse(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	// Success. Reply with exims.
-	encodeJsonAndRespond(w, resBody)
+	// Success. Reply with page of exims and cursors.
+	encodeJsonAndRespond(w, page)
+}
+
+// Reads limit, after, before, sort, author, target, tag, chapter and state
+// (comma-separated) from the query string into dst, applying defaults.
+func parseEximQuery(dst *EximQuery, req *http.Request) error {
+	q := req.URL.Query()
+
+	dst.Limit = defaultEximPageLimit
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxEximPageLimit {
+			return fmt.Errorf("limit should be between 1 and %d", maxEximPageLimit)
+		}
+		dst.Limit = limit
+	}
+
+	if q.Get("after") != "" && q.Get("before") != "" {
+		return fmt.Errorf("after and before are mutually exclusive")
+	}
+	if s := q.Get("after"); s != "" {
+		c, err := parseEximCursor(s)
+		if err != nil {
+			return err
+		}
+		dst.After = c
+	}
+	if s := q.Get("before"); s != "" {
+		c, err := parseEximCursor(s)
+		if err != nil {
+			return err
+		}
+		dst.Before = c
+	}
+
+	dst.Sort = eximSortNewest
+	if s := q.Get("sort"); s != "" {
+		if s != eximSortNewest && s != eximSortOldest && s != eximSortSupported {
+			return fmt.Errorf("unknown sort (%s)", s)
+		}
+		dst.Sort = s
+	}
+
+	if s := q.Get("author"); s != "" {
+		if _, err := ulid.ParseStrict(s); err != nil {
+			return fmt.Errorf("invalid author (%s)", s)
+		}
+		dst.Author = s
+	}
+	dst.Target = strings.TrimSpace(q.Get("target"))
+
+	if s := q.Get("tag"); s != "" {
+		if !isTagSlug(s) {
+			return fmt.Errorf("invalid tag (%s)", s)
+		}
+		dst.Tag = s
+	}
+
+	if s := q.Get("chapter"); s != "" {
+		if !isTagSlug(s) {
+			return fmt.Errorf("invalid chapter (%s)", s)
+		}
+		dst.Chapter = s
+	}
+
+	if s := q.Get("state"); s != "" {
+		dst.States = strings.Split(s, ",")
+		for _, state := range dst.States {
+			if !isEximState(state) {
+				return fmt.Errorf("unknown exim state (%s)", state)
+			}
+		}
+	}
+	return nil
 }
 
 func handleGetEximDetails(w http.ResponseWriter, req *http.Request) {
@@ -106,14 +214,392 @@ func handleGetEximDetails(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Execute db transactions.
+	err = exim.getEximDetailsTx(eximBinId)
+	if err == nil {
+		err = exim.checkVisibleTx(getViewerId(req))
+	}
+	if errors.Is(err, errEximNotFound) {
+		sendErrorResponse(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Printf("[err][api] fetching exim details: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with exim details (or tombstone).
+	exim.tombstone()
+	if err := exim.presentTx(); err != nil {
+		fmt.Printf("[err][api] fetching exim author: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	encodeJsonAndRespond(w, exim)
+}
+
+func handleEditExim(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Target   string       `json:"target"`
+		Title    string       `json:"title"`
+		Summary  string       `json:"summary"`
+		Sections EximSections `json:"sections"`
+		Link     string       `json:"link"`
+		// Optional, tags are unchanged if omitted.
+		Tags []string `json:"tags"`
+		// Optional, the chapter is unchanged if omitted, and the target no
+		// longer scoped to a chapter if empty.
+		Chapter *string `json:"chapter"`
+	}
+	var reqBody ReqBody
+	var exim *Exim = new(Exim)
+	var current *Exim = new(Exim)
+	var user *User = new(User)
+
+	fmt.Printf("[api] handling PUT to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into exim.EximId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, user.UserId)
+	if err != nil {
+		return
+	}
+
+	// Update instance fields.
+	exim.Target = reqBody.Target
+	exim.Title = reqBody.Title
+	exim.Summary = reqBody.Summary
+	exim.Sections = reqBody.Sections
+	exim.Link = reqBody.Link
+	exim.Tags = reqBody.Tags
+	if reqBody.Chapter != nil {
+		exim.Chapter = *reqBody.Chapter
+	}
+
+	// Validate fields, responding with every invalid field.
+	exim.normalize()
+	if fieldErrors := exim.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating exim: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Moderators may edit any exim, but editing someone else's exim is a
+	// privileged action which requires TOTP verification (if enrolled).
+	isModerator := user.moderatorTx(userBinId) == nil
+	if isModerator {
+		if err := current.getEximDetailsTx(eximBinId); err != nil {
+			fmt.Printf("[err][api] fetching exim details: %v [%s]\n", err, cts())
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		if current.Author != user.UserId.String() {
+			if err := verifyElevation(w, req, user.UserId); err != nil {
+				return
+			}
+		}
+	}
+
 	// Execute db transaction.
+	err = exim.editEximTx(eximBinId, user.UserId, isModerator, reqBody.Chapter == nil)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with edited exim: %v [%s]\n", err, cts())
+		switch {
+		case errors.Is(err, errEximNotFound):
+			sendErrorResponse(w, err, http.StatusNotFound)
+		case errors.Is(err, errEximNotPermitted):
+			sendErrorResponse(w, err, http.StatusForbidden)
+		case errors.Is(err, errTagNotFound):
+			sendValidationErrorResponse(w, map[string]string{"tags": err.Error()})
+		case errors.Is(err, errChapterNotFound):
+			sendValidationErrorResponse(w, map[string]string{"chapter": err.Error()})
+		default:
+			sendErrorResponse(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Success. Reply with updated exim.
+	encodeJsonAndRespond(w, exim)
+}
+
+func handleGetEximRevisions(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Revisions EximRevisions `json:"revisions"`
+	}
+	var resBody ResBody
+	var exim *Exim = new(Exim)
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into exim.EximId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transactions. History of withdrawn or removed exims is gone.
 	err = exim.getEximDetailsTx(eximBinId)
+	if err == nil {
+		err = exim.checkVisibleTx(getViewerId(req))
+	}
 	if err != nil {
 		fmt.Printf("[err][api] fetching exim details: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusNotFound)
+		return
+	}
+	if exim.Removal != nil {
+		sendErrorResponse(w, errEximRemoved, http.StatusGone)
+		return
+	}
+	resBody.Revisions = EximRevisions{}
+	err = resBody.Revisions.getEximRevisionsTx(eximBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching exim revisions: %v [%s]\n", err, cts())
 		sendErrorResponse(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	// Success. Reply with exim details.
+	// Success. Reply with revisions, oldest first.
+	encodeJsonAndRespond(w, resBody)
+}
+
+func handleWithdrawExim(w http.ResponseWriter, req *http.Request) {
+	removeExim(w, req, "withdrawn")
+}
+
+func handleRemoveExim(w http.ResponseWriter, req *http.Request) {
+	removeExim(w, req, "removed")
+}
+
+// Soft-deletes an exim. Authors withdraw their own exims (reason optional),
+// moderators remove any exim (reason required).
+func removeExim(w http.ResponseWriter, req *http.Request, kind string) {
+	type ReqBody struct {
+		Reason string `json:"reason"`
+	}
+	var reqBody ReqBody
+	var exim *Exim = new(Exim)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into exim.EximId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+
+	// Validate reason.
+	reason := normalizeText(reqBody.Reason, true)
+	if kind == "removed" && isBlank(reason) {
+		sendValidationErrorResponse(w, map[string]string{"reason": "This field cannot be blank."})
+		return
+	}
+	if !maxChars(reason, maxRemovalReasonChars) {
+		sendValidationErrorResponse(w, map[string]string{"reason": fmt.Sprintf("This field cannot be more than %d characters long.", maxRemovalReasonChars)})
+		return
+	}
+
+	// Execute db transaction.
+	err = exim.removeEximTx(eximBinId, EximRemoval{
+		Kind:      kind,
+		ByUserId:  userId.String(),
+		Reason:    reason,
+		RemovedTs: time.Now(),
+	})
+	if err != nil {
+		fmt.Printf("[err][api] updating db with exim removal: %v [%s]\n", err, cts())
+		switch {
+		case errors.Is(err, errEximNotFound):
+			sendErrorResponse(w, err, http.StatusNotFound)
+		case errors.Is(err, errEximNotPermitted):
+			sendErrorResponse(w, err, http.StatusForbidden)
+		case errors.Is(err, errEximRemoved):
+			sendErrorResponse(w, err, http.StatusConflict)
+		default:
+			sendErrorResponse(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Success. Reply with tombstone.
+	exim.tombstone()
 	encodeJsonAndRespond(w, exim)
 }
+
+func handleTransitionExim(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		State string `json:"state"`
+		Note  string `json:"note"`
+	}
+	var reqBody ReqBody
+	var exim *Exim = new(Exim)
+	var user *User = new(User)
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into exim.EximId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, user.UserId)
+	if err != nil {
+		return
+	}
+
+	// Validate fields.
+	note := normalizeText(reqBody.Note, true)
+	if !isEximState(reqBody.State) {
+		sendValidationErrorResponse(w, map[string]string{"state": "This field must be a known exim state."})
+		return
+	}
+	if !maxChars(note, maxTransitionNoteChars) {
+		sendValidationErrorResponse(w, map[string]string{"note": fmt.Sprintf("This field cannot be more than %d characters long.", maxTransitionNoteChars)})
+		return
+	}
+
+	// Execute db transaction.
+	if err := exim.getEximDetailsTx(eximBinId); err != nil {
+		fmt.Printf("[err][api] fetching exim details: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusNotFound)
+		return
+	}
+
+	// Determine the role the user acts under. Acting as a moderator is a
+	// privileged action which requires TOTP verification (if enrolled).
+	isModerator := user.moderatorTx(userBinId) == nil
+	role, err := eximTransitionRole(exim.State, reqBody.State, exim.Author == user.UserId.String(), isModerator)
+	if err == nil && role == roleModerator {
+		err = verifyElevation(w, req, user.UserId)
+		if err != nil {
+			return
+		}
+	}
+
+	// Execute db transaction, which checks the transition again against the
+	// (possibly changed) current state.
+	if err == nil {
+		err = exim.transitionEximTx(eximBinId, reqBody.State, user.UserId, isModerator, note)
+	}
+	if err != nil {
+		fmt.Printf("[err][api] updating db with exim transition: %v [%s]\n", err, cts())
+		switch {
+		case errors.Is(err, errEximNotFound):
+			sendErrorResponse(w, err, http.StatusNotFound)
+		case errors.Is(err, errEximNotPermitted):
+			sendErrorResponse(w, err, http.StatusForbidden)
+		case errors.Is(err, errEximRemoved), errors.Is(err, errTransitionNotAllowed):
+			sendErrorResponse(w, err, http.StatusConflict)
+		default:
+			sendErrorResponse(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Success. Reply with updated exim.
+	encodeJsonAndRespond(w, exim)
+}
+
+func handleGetEximTransitions(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Transitions EximTransitions `json:"transitions"`
+	}
+	var resBody ResBody
+	var exim *Exim = new(Exim)
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into exim.EximId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transactions.
+	err = exim.getEximDetailsTx(eximBinId)
+	if err == nil {
+		err = exim.checkVisibleTx(getViewerId(req))
+	}
+	if err != nil {
+		fmt.Printf("[err][api] fetching exim details: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusNotFound)
+		return
+	}
+	resBody.Transitions = EximTransitions{}
+	err = resBody.Transitions.getEximTransitionsTx(eximBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching exim transitions: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with transition log, oldest first.
+	encodeJsonAndRespond(w, resBody)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func handleCreateOutcome(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Hypothesis    string          `json:"hypothesis"`
+		Metrics       []OutcomeMetric `json:"metrics"`
+		Results       string          `json:"results"`
+		Verdict       string          `json:"verdict"`
+		Conclusion    string          `json:"conclusion"`
+		EvidenceLinks []string        `json:"evidenceLinks"`
+	}
+	var reqBody ReqBody
+	var outcome *Outcome = new(Outcome)
+	var exim *Exim = new(Exim)
+	var user *User = new(User)
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into exim.EximId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, user.UserId)
+	if err != nil {
+		return
+	}
+
+	// Update instance fields.
+	outcome.AuthorId = user.UserId.String()
+	outcome.Hypothesis = reqBody.Hypothesis
+	outcome.Metrics = reqBody.Metrics
+	outcome.Results = reqBody.Results
+	outcome.Verdict = reqBody.Verdict
+	outcome.Conclusion = reqBody.Conclusion
+	outcome.EvidenceLinks = reqBody.EvidenceLinks
+
+	// Validate fields, responding with every invalid field.
+	outcome.normalize()
+	if fieldErrors := outcome.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating outcome: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Moderators may report on any exim, but reporting on someone else's exim
+	// is a privileged action which requires TOTP verification (if enrolled).
+	isModerator := user.moderatorTx(userBinId) == nil
+	if isModerator {
+		if err := exim.getEximDetailsTx(eximBinId); err != nil {
+			fmt.Printf("[err][api] fetching exim details: %v [%s]\n", err, cts())
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		if exim.Author != user.UserId.String() {
+			if err := verifyElevation(w, req, user.UserId); err != nil {
+				return
+			}
+		}
+	}
+
+	// Execute db transaction.
+	err = outcome.createOutcomeTx(eximBinId, isModerator)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with new outcome: %v [%s]\n", err, cts())
+		switch {
+		case errors.Is(err, errEximNotFound):
+			sendErrorResponse(w, err, http.StatusNotFound)
+		case errors.Is(err, errEximNotPermitted):
+			sendErrorResponse(w, err, http.StatusForbidden)
+		case errors.Is(err, errEximRemoved), errors.Is(err, errEximNotConcluded):
+			sendErrorResponse(w, err, http.StatusConflict)
+		default:
+			sendErrorResponse(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Success. Reply with outcome.
+	encodeJsonAndRespond(w, outcome)
+}
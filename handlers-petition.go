@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// Sends the response for errors from the petition transactions.
+func sendPetitionErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errEximNotFound), errors.Is(err, errPetitionNotFound), errors.Is(err, errSignatureNotFound):
+		sendErrorResponse(w, err, http.StatusNotFound)
+	case errors.Is(err, errSignerNotVerified), errors.Is(err, errNoLegalName):
+		sendErrorResponse(w, err, http.StatusForbidden)
+	case errors.Is(err, errEximRemoved), errors.Is(err, errPetitionClosed), errors.Is(err, errPetitionSigned):
+		sendErrorResponse(w, err, http.StatusConflict)
+	case errors.Is(err, errSignerNoAddress):
+		sendValidationErrorResponse(w, map[string]string{"address": err.Error()})
+	default:
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+// Checks that the user may manage an exim's petition: its author may, and so
+// may moderators, for whom managing someone else's petition is a privileged
+// action which requires TOTP verification (if enrolled). Send error response
+// if not.
+func verifyPetitionManager(w http.ResponseWriter, req *http.Request, eximBinId []byte, user *User, userBinId []byte) error {
+	var exim *Exim = new(Exim)
+
+	if err := exim.getEximDetailsTx(eximBinId); err != nil {
+		fmt.Printf("[err][api] fetching exim details: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusNotFound)
+		return err
+	}
+	if exim.Author == user.UserId.String() {
+		return nil
+	}
+	if err := user.moderatorTx(userBinId); err != nil {
+		sendErrorResponse(w, errEximNotPermitted, http.StatusForbidden)
+		return errEximNotPermitted
+	}
+	return verifyElevation(w, req, user.UserId)
+}
+
+func handleGetPetition(w http.ResponseWriter, req *http.Request) {
+	var petition *Petition = new(Petition)
+	var eximId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into eximId.
+	if err := unmarshalUlid(w, &eximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	eximBinId, err := getBinId(w, eximId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = petition.getPetitionTx(eximBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching petition: %v [%s]\n", err, cts())
+		sendPetitionErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with petition and signature counts.
+	encodeJsonAndRespond(w, petition)
+}
+
+// Sets up (or updates) an exim's petition, see setPetitionTx.
+func handleSetPetition(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Recipient string    `json:"recipient"`
+		ClosesTs  time.Time `json:"closesTs"`
+	}
+	var reqBody ReqBody
+	var petition *Petition = new(Petition)
+	var eximId ulid.ULID
+	var user *User = new(User)
+
+	fmt.Printf("[api] handling PUT to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into eximId.
+	if err := unmarshalUlid(w, &eximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eximBinId, err := getBinId(w, eximId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, user.UserId)
+	if err != nil {
+		return
+	}
+
+	// Validate fields, responding with every invalid field.
+	petition.Recipient = reqBody.Recipient
+	petition.ClosesTs = reqBody.ClosesTs
+	petition.normalize()
+	if fieldErrors := petition.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating petition: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	if err := verifyPetitionManager(w, req, eximBinId, user, userBinId); err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = petition.setPetitionTx(eximBinId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with petition: %v [%s]\n", err, cts())
+		sendPetitionErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with petition.
+	encodeJsonAndRespond(w, petition)
+}
+
+// Signs (POST) or withdraws the signature from (DELETE) an exim's petition as
+// the authenticated user, see signPetitionTx.
+func handleSignPetition(w http.ResponseWriter, req *http.Request) {
+	var signature *PetitionSignature = new(PetitionSignature)
+	var eximId ulid.ULID
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling %s to %s [%s]\n", req.Method, req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into eximId.
+	if err := unmarshalUlid(w, &eximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eximBinId, err := getBinId(w, eximId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	if req.Method == http.MethodPost {
+		err = signature.signPetitionTx(eximBinId, userBinId)
+	} else {
+		err = withdrawSignatureTx(eximBinId, userBinId)
+	}
+	if err != nil {
+		fmt.Printf("[err][api] updating db with signature: %v [%s]\n", err, cts())
+		sendPetitionErrorResponse(w, err)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Replies with every signature on an exim's petition, for submission to the
+// recipient: as CSV (format=csv, the default), or as a page ready to print or
+// save as PDF (format=html). The CSV is signed with the server's private key;
+// the signature (base64url, RSA PKCS #1 v1.5 over SHA-256) is sent in the
+// Signature header, and shown on the printable page. Signatures hold signers'
+// legal names and addresses, so only moderators who have enrolled in TOTP may
+// export them, with elevation, and every export is audited.
+func handleExportPetition(w http.ResponseWriter, req *http.Request) {
+	var petition *Petition = new(Petition)
+	var eximId ulid.ULID
+	var user *User = new(User)
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "html" {
+		sendErrorResponse(w, fmt.Errorf("format should be csv or html"), http.StatusBadRequest)
+		return
+	}
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+		return
+	}
+	// Require TOTP, which modMiddleware only verifies if enrolled.
+	if err := requireElevation(w, req, user.UserId); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into eximId.
+	if err := unmarshalUlid(w, &eximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	eximBinId, err := getBinId(w, eximId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	signatures, err := petition.exportPetitionSignaturesTx(eximBinId, user.UserId, format)
+	if err != nil {
+		fmt.Printf("[err][api] fetching petition signatures: %v [%s]\n", err, cts())
+		sendPetitionErrorResponse(w, err)
+		return
+	}
+	csvBytes, err := signatures.csv()
+	if err != nil {
+		fmt.Printf("[err][api] writing petition csv: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	csvSignature := signMessage(string(csvBytes))
+
+	// Success. Reply with the signed list.
+	if format == "html" {
+		var exim *Exim = new(Exim)
+		if err := exim.getEximDetailsTx(eximBinId); err != nil {
+			fmt.Printf("[err][api] fetching exim details: %v [%s]\n", err, cts())
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		renderDocument(w, http.StatusOK, "petition-print.tmpl.html", petitionDocument{
+			Title:        exim.Title,
+			Petition:     petition,
+			Signatures:   signatures,
+			CSVHash:      fmt.Sprintf("%x", sha256.Sum256(csvBytes)),
+			CSVSignature: csvSignature,
+			ExportedTs:   time.Now(),
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="petition-%s.csv"`, eximId))
+	w.Header().Set("Signature", csvSignature)
+	w.Write(csvBytes)
+}
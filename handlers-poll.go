@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+func handleGetPolls(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Polls Polls `json:"polls"`
+	}
+	var resBody ResBody
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Execute db transaction.
+	err := resBody.Polls.getPollsTx()
+	if err != nil {
+		fmt.Printf("[err][api] fetching polls: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Always reply with a list, even if empty.
+	if resBody.Polls == nil {
+		resBody.Polls = Polls{}
+	}
+
+	// Success. Reply with polls.
+	encodeJsonAndRespond(w, resBody)
+}
+
+func handleGetPoll(w http.ResponseWriter, req *http.Request) {
+	var poll *Poll = new(Poll)
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into poll.PollId.
+	if err := unmarshalUlid(w, &poll.PollId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	pollBinId, err := getBinId(w, poll.PollId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = poll.getPollTx(pollBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching poll: %v [%s]\n", err, cts())
+		if errors.Is(err, errPollNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with poll.
+	encodeJsonAndRespond(w, poll)
+}
+
+// Replies with whether the authenticated user has voted in a poll, and whether
+// they are eligible to.
+func handleGetPollVoter(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		HasVoted   bool `json:"hasVoted"`
+		IsEligible bool `json:"isEligible"`
+	}
+	var resBody ResBody
+	var pollId ulid.ULID
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into pollId.
+	if err := unmarshalUlid(w, &pollId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	pollBinId, err := getBinId(w, pollId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	resBody.HasVoted, resBody.IsEligible, err = getPollVoterTx(pollBinId, userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching poll voter: %v [%s]\n", err, cts())
+		if errors.Is(err, errPollNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with voter status.
+	encodeJsonAndRespond(w, resBody)
+}
+
+// Casts the authenticated user's secret ballot, see castBallotTx.
+func handleCastBallot(w http.ResponseWriter, req *http.Request) {
+	var ballot *Ballot = new(Ballot)
+	var pollId ulid.ULID
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into Ballot struct.
+	if err := unmarshalJson(w, ballot, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into pollId.
+	if err := unmarshalUlid(w, &pollId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	pollBinId, err := getBinId(w, pollId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = ballot.castBallotTx(pollBinId, userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with ballot: %v [%s]\n", err, cts())
+		switch {
+		case errors.Is(err, errPollNotFound):
+			sendErrorResponse(w, err, http.StatusNotFound)
+		case errors.Is(err, errPollNotEligible):
+			sendErrorResponse(w, err, http.StatusForbidden)
+		case errors.Is(err, errPollNotOpen), errors.Is(err, errPollVoted):
+			sendErrorResponse(w, err, http.StatusConflict)
+		case errors.Is(err, errBallotInvalid):
+			sendValidationErrorResponse(w, map[string]string{"choices": err.Error()})
+		default:
+			sendErrorResponse(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Replies with a closed poll's results, see tallyBallots.
+func handleGetPollResults(w http.ResponseWriter, req *http.Request) {
+	var results *PollResults = new(PollResults)
+	var pollId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into pollId.
+	if err := unmarshalUlid(w, &pollId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	pollBinId, err := getBinId(w, pollId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = results.getPollResultsTx(pollBinId)
+	if err != nil {
+		fmt.Printf("[err][api] tallying poll: %v [%s]\n", err, cts())
+		switch {
+		case errors.Is(err, errPollNotFound):
+			sendErrorResponse(w, err, http.StatusNotFound)
+		case errors.Is(err, errPollNotClosed):
+			sendErrorResponse(w, err, http.StatusConflict)
+		default:
+			sendErrorResponse(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Success. Reply with results.
+	encodeJsonAndRespond(w, results)
+}
+
+func handleCreatePoll(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Title       string      `json:"title"`
+		Description string      `json:"description"`
+		Method      string      `json:"method"`
+		Options     PollOptions `json:"options"`
+		// Optional, defaults to now.
+		OpensTs  time.Time `json:"opensTs"`
+		ClosesTs time.Time `json:"closesTs"`
+		// Optional eligibility rules.
+		VerifiedOnly bool   `json:"verifiedOnly"`
+		Chapter      string `json:"chapter"`
+	}
+	var reqBody ReqBody
+	var poll *Poll = new(Poll)
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+
+	// Update instance fields.
+	poll.Title = reqBody.Title
+	poll.Description = reqBody.Description
+	poll.Method = reqBody.Method
+	poll.Options = reqBody.Options
+	poll.OpensTs = reqBody.OpensTs
+	poll.ClosesTs = reqBody.ClosesTs
+	poll.VerifiedOnly = reqBody.VerifiedOnly
+	poll.Chapter = reqBody.Chapter
+
+	// Validate fields, responding with every invalid field.
+	poll.normalize()
+	if fieldErrors := poll.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating poll: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err := poll.createPollTx()
+	if err != nil {
+		fmt.Printf("[err][api] updating db with new poll: %v [%s]\n", err, cts())
+		switch {
+		case errors.Is(err, errEximNotFound):
+			sendValidationErrorResponse(w, map[string]string{"options": err.Error()})
+		case errors.Is(err, errChapterNotFound):
+			sendValidationErrorResponse(w, map[string]string{"chapter": err.Error()})
+		default:
+			sendErrorResponse(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Success. Reply with poll.
+	encodeJsonAndRespond(w, poll)
+}
+
+// Deletes a poll and its ballots.
+func handleDeletePoll(w http.ResponseWriter, req *http.Request) {
+	var poll *Poll = new(Poll)
+
+	fmt.Printf("[api] handling DELETE to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into poll.PollId.
+	if err := unmarshalUlid(w, &poll.PollId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	pollBinId, err := getBinId(w, poll.PollId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = poll.deletePollTx(pollBinId)
+	if err != nil {
+		fmt.Printf("[err][api] deleting poll from db: %v [%s]\n", err, cts())
+		if errors.Is(err, errPollNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
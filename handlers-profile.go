@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/oklog/ulid"
+)
+
+func handleGetProfile(w http.ResponseWriter, req *http.Request) {
+	var profile *Profile = new(Profile)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into userId.
+	if err := unmarshalUlid(w, &userId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = profile.getProfileTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching profile: %v [%s]\n", err, cts())
+		if errors.Is(err, errUserNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with profile.
+	encodeJsonAndRespond(w, profile)
+}
+
+// Replaces the authenticated user's profile. Fields which are omitted are
+// cleared.
+func handleUpdateProfile(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		DisplayName string `json:"displayName"`
+		Bio         string `json:"bio"`
+		Pronouns    string `json:"pronouns"`
+	}
+	var reqBody ReqBody
+	var profile *Profile = new(Profile)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling PUT to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Validate fields, responding with every invalid field.
+	profile.DisplayName = reqBody.DisplayName
+	profile.Bio = reqBody.Bio
+	profile.Pronouns = reqBody.Pronouns
+	profile.normalize()
+	if fieldErrors := profile.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating profile: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err = profile.updateProfileTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with profile: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with updated profile.
+	encodeJsonAndRespond(w, profile)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/oklog/ulid"
+)
+
+// Responds with the status code matching a report error.
+func sendReportErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errEximNotFound), errors.Is(err, errCommentNotFound), errors.Is(err, errReportNotFound):
+		sendErrorResponse(w, err, http.StatusNotFound)
+	case errors.Is(err, errEximRemoved), errors.Is(err, errCommentDeleted),
+		errors.Is(err, errReportDuplicate), errors.Is(err, errReportResolved):
+		sendErrorResponse(w, err, http.StatusConflict)
+	default:
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+func handleReportExim(w http.ResponseWriter, req *http.Request) {
+	createReport(w, req, reportKindExim)
+}
+
+func handleReportComment(w http.ResponseWriter, req *http.Request) {
+	createReport(w, req, reportKindComment)
+}
+
+// Reports an exim, or one of its comments, to the moderators.
+func createReport(w http.ResponseWriter, req *http.Request, kind string) {
+	type ReqBody struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+	var reqBody ReqBody
+	var report *Report = new(Report)
+	var eximId, commentId, userId ulid.ULID
+	var commentBinId []byte
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulids from strings into eximId (and commentId).
+	if err := unmarshalUlid(w, &eximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+	if kind == reportKindComment {
+		if err := unmarshalUlid(w, &commentId, req.PathValue("commentId")); err != nil {
+			return
+		}
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eximBinId, err := getBinId(w, eximId)
+	if err != nil {
+		return
+	}
+	if kind == reportKindComment {
+		if commentBinId, err = getBinId(w, commentId); err != nil {
+			return
+		}
+	}
+
+	// Update instance fields.
+	report.ReporterId = userId.String()
+	report.Reason = reqBody.Reason
+	report.Details = reqBody.Details
+
+	// Validate fields, responding with every invalid field.
+	report.normalize()
+	if fieldErrors := report.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating report: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err = report.createReportTx(eximBinId, commentBinId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with new report: %v [%s]\n", err, cts())
+		sendReportErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with report.
+	encodeJsonAndRespond(w, report)
+}
+
+// Lists reports for moderators: the open reports (the moderation queue) by
+// default, or the resolved reports if status is "resolved".
+func handleGetReports(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Reports Reports `json:"reports"`
+	}
+	var resBody ResBody
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	status := req.URL.Query().Get("status")
+	if status == "" {
+		status = reportStatusOpen
+	}
+	if status != reportStatusOpen && status != reportStatusResolved {
+		err := fmt.Errorf("status should be %s or %s", reportStatusOpen, reportStatusResolved)
+		sendErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	limit, err := parseLimit(req, defaultReportLimit, maxReportLimit)
+	if err != nil {
+		sendErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Execute db transaction.
+	err = resBody.Reports.getReportsTx(status, limit)
+	if err != nil {
+		fmt.Printf("[err][api] fetching reports: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Always reply with a list, even if empty.
+	if resBody.Reports == nil {
+		resBody.Reports = Reports{}
+	}
+
+	// Success. Reply with reports.
+	encodeJsonAndRespond(w, resBody)
+}
+
+// Resolves a report by dismissing it, hiding the reported content, or warning
+// or suspending its author. Warned and suspended authors are notified.
+func handleResolveReport(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Action      string `json:"action"`
+		Note        string `json:"note"`
+		SuspendDays int    `json:"suspendDays"`
+	}
+	var reqBody ReqBody
+	var report *Report = new(Report)
+	var sanction *Sanction = new(Sanction)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into report.ReportId.
+	if err := unmarshalUlid(w, &report.ReportId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	reportBinId, err := getBinId(w, report.ReportId)
+	if err != nil {
+		return
+	}
+
+	resolution := ReportResolution{
+		Action:      reqBody.Action,
+		ByUserId:    userId.String(),
+		Note:        reqBody.Note,
+		SuspendDays: reqBody.SuspendDays,
+	}
+
+	// Validate fields, responding with every invalid field.
+	resolution.normalize()
+	if fieldErrors := resolution.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating report resolution: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err = report.resolveReportTx(reportBinId, resolution, sanction)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with report resolution: %v [%s]\n", err, cts())
+		sendReportErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with resolved report.
+	encodeJsonAndRespond(w, report)
+
+	// Notify the sanctioned author, if any.
+	if sanction.Kind != "" {
+		notifySanctionedUser(sanction)
+	}
+}
+
+// Lists the moderation audit trail, newest first. Pass the auditId of the
+// last entry as before to read the next (older) entries.
+func handleGetAudit(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Entries AuditEntries `json:"entries"`
+	}
+	var resBody ResBody
+	var before *ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	limit, err := parseLimit(req, defaultAuditLimit, maxAuditLimit)
+	if err != nil {
+		sendErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+	if s := req.URL.Query().Get("before"); s != "" {
+		before = new(ulid.ULID)
+		if err := unmarshalUlid(w, before, s); err != nil {
+			return
+		}
+	}
+
+	// Execute db transaction.
+	err = resBody.Entries.getAuditTx(limit, before)
+	if err != nil {
+		fmt.Printf("[err][api] fetching audit trail: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Always reply with a list, even if empty.
+	if resBody.Entries == nil {
+		resBody.Entries = AuditEntries{}
+	}
+
+	// Success. Reply with audit entries.
+	encodeJsonAndRespond(w, resBody)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// Responds with the status code matching a sanction error.
+func sendSanctionErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUserNotFound), errors.Is(err, errSanctionNotFound):
+		sendErrorResponse(w, err, http.StatusNotFound)
+	case errors.Is(err, errSanctionLifted):
+		sendErrorResponse(w, err, http.StatusConflict)
+	default:
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+	}
+}
+
+// Lists every sanction of a user, including expired and lifted ones.
+func handleGetSanctions(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Sanctions Sanctions `json:"sanctions"`
+	}
+	var resBody ResBody
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Decode & unmarshal ulid from string into userId.
+	if err := unmarshalUlid(w, &userId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = resBody.Sanctions.getSanctionsTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] fetching sanctions: %v [%s]\n", err, cts())
+		sendSanctionErrorResponse(w, err)
+		return
+	}
+
+	// Always reply with a list, even if empty.
+	if resBody.Sanctions == nil {
+		resBody.Sanctions = Sanctions{}
+	}
+
+	// Success. Reply with sanctions.
+	encodeJsonAndRespond(w, resBody)
+}
+
+// Warns, suspends or bans a user, and notifies them.
+func handleCreateSanction(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Kind   string `json:"kind"`
+		Reason string `json:"reason"`
+		// Days is the length of a suspension or (optionally) a ban.
+		Days int `json:"days"`
+	}
+	var reqBody ReqBody
+	var sanction *Sanction = new(Sanction)
+	var adminId, userId ulid.ULID
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set adminId from context provided by adminIdentityMiddleware.
+	if err := setAdminIdFromContext(w, &adminId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into userId.
+	if err := unmarshalUlid(w, &userId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Update instance fields.
+	sanction.UserId = userId.String()
+	sanction.ByAdminId = adminId.String()
+	sanction.Kind = reqBody.Kind
+	sanction.Reason = reqBody.Reason
+
+	// Validate fields, responding with every invalid field.
+	sanction.normalize()
+	if fieldErrors := sanction.validate(reqBody.Days); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating sanction: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+	if reqBody.Days > 0 {
+		expiresTs := time.Now().AddDate(0, 0, reqBody.Days)
+		sanction.ExpiresTs = &expiresTs
+	}
+
+	// Execute db transaction.
+	err = sanction.createSanctionTx(userBinId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with new sanction: %v [%s]\n", err, cts())
+		sendSanctionErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with sanction.
+	encodeJsonAndRespond(w, sanction)
+
+	// Notify the sanctioned user.
+	notifySanctionedUser(sanction)
+}
+
+// Lifts a suspension or ban before it expires, and notifies the user.
+func handleLiftSanction(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Reason string `json:"reason"`
+	}
+	var reqBody ReqBody
+	var sanction *Sanction = new(Sanction)
+	var adminId, userId ulid.ULID
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set adminId from context provided by adminIdentityMiddleware.
+	if err := setAdminIdFromContext(w, &adminId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulids from strings into userId and sanction.SanctionId.
+	if err := unmarshalUlid(w, &userId, req.PathValue("ulid")); err != nil {
+		return
+	}
+	if err := unmarshalUlid(w, &sanction.SanctionId, req.PathValue("sanctionId")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+	sanctionBinId, err := getBinId(w, sanction.SanctionId)
+	if err != nil {
+		return
+	}
+
+	// Validate reason.
+	reason := normalizeText(reqBody.Reason, true)
+	if isBlank(reason) {
+		sendValidationErrorResponse(w, map[string]string{"reason": "This field cannot be blank."})
+		return
+	}
+	if !maxChars(reason, maxSanctionReasonChars) {
+		sendValidationErrorResponse(w, map[string]string{"reason": fmt.Sprintf("This field cannot be more than %d characters long.", maxSanctionReasonChars)})
+		return
+	}
+
+	// Execute db transaction.
+	err = sanction.liftSanctionTx(userBinId, sanctionBinId, SanctionLift{
+		ByAdminId: adminId.String(),
+		Reason:    reason,
+	})
+	if err != nil {
+		fmt.Printf("[err][api] updating db with lifted sanction: %v [%s]\n", err, cts())
+		sendSanctionErrorResponse(w, err)
+		return
+	}
+
+	// Success. Reply with lifted sanction.
+	encodeJsonAndRespond(w, sanction)
+
+	// Notify the formerly sanctioned user.
+	notifySanctionedUser(sanction)
+}
+
+// Looks up the sanctioned user's email and notifies them of the sanction.
+// Failures are logged, since the sanction itself has already been applied.
+func notifySanctionedUser(sanction *Sanction) {
+	var user *User = new(User)
+
+	userId, err := ulid.ParseStrict(sanction.UserId)
+	if err != nil {
+		fmt.Printf("[err][api] parsing sanctioned userId: %v [%s]\n", err, cts())
+		return
+	}
+	userBinId, err := userId.MarshalBinary()
+	if err != nil {
+		fmt.Printf("[err][api] marshaling sanctioned userId: %v [%s]\n", err, cts())
+		return
+	}
+	if err := user.getEmailTx(userBinId); err != nil {
+		fmt.Printf("[err][api] fetching sanctioned user email: %v [%s]\n", err, cts())
+		return
+	}
+	sanction.sendSanctionEmail(user.Email)
+}
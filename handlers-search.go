@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func handleSearchExims(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Results EximSearchResults `json:"results"`
+	}
+	var resBody ResBody
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	query, limit, err := parseSearchQuery(req)
+	if err != nil {
+		fmt.Printf("[err][api] parsing search query: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Execute db transaction.
+	err = resBody.Results.searchEximsTx(query, limit)
+	if err != nil {
+		fmt.Printf("[err][api] searching exims: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Always reply with a list, even if empty.
+	if resBody.Results == nil {
+		resBody.Results = EximSearchResults{}
+	}
+
+	// Success. Reply with ranked results.
+	encodeJsonAndRespond(w, resBody)
+}
+
+// Reads q and limit from the query string, applying defaults.
+func parseSearchQuery(req *http.Request) (string, int, error) {
+	q := req.URL.Query()
+
+	query := normalizeText(q.Get("q"), false)
+	if query == "" {
+		return "", 0, fmt.Errorf("q is required")
+	}
+	if !maxChars(query, maxSearchQueryChars) {
+		return "", 0, fmt.Errorf("q should be at most %d characters", maxSearchQueryChars)
+	}
+
+	limit := defaultSearchLimit
+	if s := strings.TrimSpace(q.Get("limit")); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return "", 0, fmt.Errorf("limit should be between 1 and %d", maxSearchLimit)
+		}
+		limit = n
+	}
+	return query, limit, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/oklog/ulid"
+)
+
+// Adds the user's support (POST) or removes it (DELETE).
+func handleSupportExim(w http.ResponseWriter, req *http.Request) {
+	var exim *Exim = new(Exim)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling %s to %s [%s]\n", req.Method, req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into exim.EximId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = exim.supportEximTx(eximBinId, userBinId, req.Method == http.MethodPost)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with exim support: %v [%s]\n", err, cts())
+		switch {
+		case errors.Is(err, errEximNotFound):
+			sendErrorResponse(w, err, http.StatusNotFound)
+		case errors.Is(err, errEximRemoved), errors.Is(err, errEximNotSupportable):
+			sendErrorResponse(w, err, http.StatusConflict)
+		default:
+			sendErrorResponse(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Adds the user's abstention (POST) or removes it (DELETE), see
+// abstainEximTx.
+func handleAbstainExim(w http.ResponseWriter, req *http.Request) {
+	var exim *Exim = new(Exim)
+	var userId ulid.ULID
+
+	fmt.Printf("[api] handling %s to %s [%s]\n", req.Method, req.URL.Path, cts())
+
+	// Get/set userId from context provided by authMiddleware and assert type.
+	if err := setUserIdFromContext(w, &userId, req); err != nil {
+		return
+	}
+	// Decode & unmarshal ulid from string into exim.EximId.
+	if err := unmarshalUlid(w, &exim.EximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulids to byte slices to use as db keys.
+	eximBinId, err := getBinId(w, exim.EximId)
+	if err != nil {
+		return
+	}
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = exim.abstainEximTx(eximBinId, userBinId, req.Method == http.MethodPost)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with exim abstention: %v [%s]\n", err, cts())
+		switch {
+		case errors.Is(err, errEximNotFound):
+			sendErrorResponse(w, err, http.StatusNotFound)
+		case errors.Is(err, errEximRemoved), errors.Is(err, errEximNotSupportable):
+			sendErrorResponse(w, err, http.StatusConflict)
+		default:
+			sendErrorResponse(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
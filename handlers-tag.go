@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func handleGetTags(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Tags Tags `json:"tags"`
+	}
+	var resBody ResBody
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	// Execute db transaction.
+	err := resBody.Tags.getTagsTx()
+	if err != nil {
+		fmt.Printf("[err][api] fetching tags: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Always reply with a list, even if empty.
+	if resBody.Tags == nil {
+		resBody.Tags = Tags{}
+	}
+
+	// Success. Reply with tags.
+	encodeJsonAndRespond(w, resBody)
+}
+
+func handleGetTag(w http.ResponseWriter, req *http.Request) {
+	var tag *Tag = new(Tag)
+
+	fmt.Printf("[api] handling GET to %s [%s]\n", req.URL.Path, cts())
+
+	tag.Slug = req.PathValue("slug")
+
+	// Execute db transaction.
+	err := tag.getTagTx()
+	if err != nil {
+		fmt.Printf("[err][api] fetching tag: %v [%s]\n", err, cts())
+		if errors.Is(err, errTagNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with tag.
+	encodeJsonAndRespond(w, tag)
+}
+
+func handleCreateTag(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Slug        string `json:"slug"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	var reqBody ReqBody
+	var tag *Tag = new(Tag)
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+
+	// Update instance fields.
+	tag.Slug = reqBody.Slug
+	tag.Name = reqBody.Name
+	tag.Description = reqBody.Description
+
+	// Validate fields, responding with every invalid field.
+	tag.normalize()
+	if fieldErrors := tag.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating tag: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err := tag.createTagTx()
+	if err != nil {
+		fmt.Printf("[err][api] updating db with new tag: %v [%s]\n", err, cts())
+		if errors.Is(err, errTagExists) {
+			sendErrorResponse(w, err, http.StatusConflict)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with tag.
+	encodeJsonAndRespond(w, tag)
+}
+
+// Updates a tag's name and description; the slug cannot be changed.
+func handleUpdateTag(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	var reqBody ReqBody
+	var tag *Tag = new(Tag)
+
+	fmt.Printf("[api] handling PUT to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+
+	// Update instance fields.
+	tag.Slug = req.PathValue("slug")
+	tag.Name = reqBody.Name
+	tag.Description = reqBody.Description
+
+	// Validate fields, responding with every invalid field.
+	tag.normalize()
+	if fieldErrors := tag.validate(); len(fieldErrors) > 0 {
+		fmt.Printf("[err][api] validating tag: %v [%s]\n", fieldErrors, cts())
+		sendValidationErrorResponse(w, fieldErrors)
+		return
+	}
+
+	// Execute db transaction.
+	err := tag.updateTagTx()
+	if err != nil {
+		fmt.Printf("[err][api] updating db with edited tag: %v [%s]\n", err, cts())
+		if errors.Is(err, errTagNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Reply with updated tag.
+	encodeJsonAndRespond(w, tag)
+}
+
+// Deletes a tag, untagging every exim tagged with it.
+func handleDeleteTag(w http.ResponseWriter, req *http.Request) {
+	var tag *Tag = new(Tag)
+
+	fmt.Printf("[api] handling DELETE to %s [%s]\n", req.URL.Path, cts())
+
+	tag.Slug = req.PathValue("slug")
+
+	// Execute db transaction.
+	err := tag.deleteTagTx()
+	if err != nil {
+		fmt.Printf("[err][api] deleting tag from db: %v [%s]\n", err, cts())
+		if errors.Is(err, errTagNotFound) {
+			sendErrorResponse(w, err, http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// How long a successful TOTP verification grants access to privileged endpoints.
+const elevationDuration = 15 * time.Minute
+
+// Gets the TOTP owner, which is either an administrator (adminIdentityMiddleware)
+// or a moderator (modIdentityMiddleware), from context.
+func setTotpOwnerFromContext(w http.ResponseWriter, dst *ulid.ULID, req *http.Request) error {
+	if _, ok := req.Context().Value(adminIdContextKey).(ulid.ULID); ok {
+		return setAdminIdFromContext(w, dst, req)
+	}
+	return setUserIdFromContext(w, dst, req)
+}
+
+func handleTotpEnroll(w http.ResponseWriter, req *http.Request) {
+	type ResBody struct {
+		Secret        string   `json:"secret"`
+		Uri           string   `json:"uri"`
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	var totp *Totp = new(Totp)
+	var resBody ResBody
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set ownerId from context provided by middleware.
+	if err := setTotpOwnerFromContext(w, &totp.OwnerId, req); err != nil {
+		return
+	}
+	// Replacing an existing enrollment requires verifying the existing one.
+	if err := verifyElevation(w, req, totp.OwnerId); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	binId, err := getBinId(w, totp.OwnerId)
+	if err != nil {
+		return
+	}
+
+	secret, err := generateTotpSecret()
+	if err != nil {
+		fmt.Printf("[err][api] generating totp secret: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		fmt.Printf("[err][api] generating recovery codes: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+	encrypted, err := encryptSecret(secret)
+	if err != nil {
+		fmt.Printf("[err][api] encrypting totp secret: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Enrollment is pending until confirmed with a valid code.
+	totp.TotpGrp.Secret = encrypted
+	totp.TotpGrp.IsEnrolled = false
+	totp.TotpGrp.RecoveryCodes = hashes
+
+	// Execute db transaction.
+	err = totp.enrollTotpTx(binId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db with totp enrollment: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resBody.Secret = secret
+	resBody.Uri = totpUri(secret, totp.OwnerId.String())
+	resBody.RecoveryCodes = codes
+
+	// Success. Reply with secret and recovery codes, which are not retrievable
+	// after this response.
+	encodeJsonAndRespond(w, resBody)
+}
+
+func handleTotpConfirm(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		Code string `json:"code"`
+	}
+	var reqBody ReqBody
+	var totp *Totp = new(Totp)
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set ownerId from context provided by middleware.
+	if err := setTotpOwnerFromContext(w, &totp.OwnerId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	binId, err := getBinId(w, totp.OwnerId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = totp.verifyTotpTx(binId, reqBody.Code, true)
+	if err != nil {
+		fmt.Printf("[err][api] confirming totp enrollment: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleTotpVerify(w http.ResponseWriter, req *http.Request) {
+	type ReqBody struct {
+		// Either a current TOTP code or an unused recovery code.
+		Code string `json:"code"`
+	}
+	type ResBody struct {
+		Token                  string    `json:"token"`
+		ExpiresTs              time.Time `json:"expiresTs"`
+		RemainingRecoveryCodes int       `json:"remainingRecoveryCodes"`
+	}
+	var reqBody ReqBody
+	var totp *Totp = new(Totp)
+	var resBody ResBody
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Enforce JSON Content-Type.
+	if err := verifyContentType(w, req); err != nil {
+		return
+	}
+	// Decode & unmarshal JSON request body (stream) into ReqBody struct.
+	if err := unmarshalJson(w, &reqBody, req); err != nil {
+		return
+	}
+	// Get/set ownerId from context provided by middleware.
+	if err := setTotpOwnerFromContext(w, &totp.OwnerId, req); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	binId, err := getBinId(w, totp.OwnerId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = totp.verifyTotpTx(binId, reqBody.Code, false)
+	if err != nil {
+		fmt.Printf("[err][api] verifying totp code: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	// Success. Create and reply with elevation token, which is to be sent in
+	// the Elevation-Authorization header of requests to privileged endpoints.
+	resBody.ExpiresTs = time.Now().Add(elevationDuration)
+	resBody.Token = createElevationToken(totp.OwnerId.String(), elevationSession(req, totp.OwnerId), resBody.ExpiresTs)
+	resBody.RemainingRecoveryCodes = len(totp.TotpGrp.RecoveryCodes)
+
+	encodeJsonAndRespond(w, resBody)
+}
+
+func handleTotpDisable(w http.ResponseWriter, req *http.Request) {
+	var totp *Totp = new(Totp)
+
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Get/set ownerId from context provided by middleware.
+	if err := setTotpOwnerFromContext(w, &totp.OwnerId, req); err != nil {
+		return
+	}
+	// Disabling requires verifying the existing enrollment.
+	if err := verifyElevation(w, req, totp.OwnerId); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	binId, err := getBinId(w, totp.OwnerId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	err = totp.disableTotpTx(binId)
+	if err != nil {
+		fmt.Printf("[err][api] removing totp enrollment from db: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Respond with 204 No Content.
+	w.WriteHeader(http.StatusNoContent)
+}
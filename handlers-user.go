@@ -2,17 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/oklog/ulid"
 )
 
 // Go prefers that the key used in context.WithValue be of a custom type.
 type contextKeyType string
 
 const userIdContextKey = contextKeyType("userId")
+const adminIdContextKey = contextKeyType("adminId")
 const maxLoginCodeAttempts = 3
 
 // Checks authorization header for "Bearer " prefix and valid token.
@@ -70,6 +74,13 @@ func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		// Suspended and banned users may not use their sessions.
+		if err := user.checkSanctionsTx(binId); err != nil {
+			fmt.Printf("[err][api] checking user sanctions: %v [%s]\n", err, cts())
+			sendLoginSanctionErrorResponse(w, err)
+			return
+		}
+
 		// Add userId to the request context.
 		ctx := context.WithValue(req.Context(), userIdContextKey, user.UserId)
 
@@ -78,6 +89,69 @@ func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// Authenticates the user like authMiddleware if an Authorization header is
+// provided. Unlike authMiddleware, a missing header is not an error; the
+// request is handled for an anonymous visitor.
+func optionalAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	authNext := authMiddleware(next)
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") == "" {
+			next(w, req)
+			return
+		}
+		authNext(w, req)
+	}
+}
+
+// Checks that the authenticated user is a moderator and, if the moderator has
+// enrolled in TOTP, for a valid elevation token. Call next handler in chain.
+func modMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return modIdentityMiddleware(func(w http.ResponseWriter, req *http.Request) {
+		var userId ulid.ULID
+
+		// Get/set userId from context provided by authMiddleware.
+		if err := setUserIdFromContext(w, &userId, req); err != nil {
+			return
+		}
+		// Require TOTP verification for privileged endpoints.
+		if err := verifyElevation(w, req, userId); err != nil {
+			return
+		}
+
+		// Call the next handler in the chain.
+		next(w, req)
+	})
+}
+
+// Checks that the authenticated user is a moderator, and call next handler in
+// chain. Does not require TOTP verification, so should only wrap the endpoints
+// used to enroll in and verify TOTP.
+func modIdentityMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return authMiddleware(func(w http.ResponseWriter, req *http.Request) {
+		var user *User = new(User)
+
+		// Get/set userId from context provided by authMiddleware.
+		if err := setUserIdFromContext(w, &user.UserId, req); err != nil {
+			return
+		}
+
+		// Convert ulid to byte slice to use as db key.
+		binId, err := getBinId(w, user.UserId)
+		if err != nil {
+			return
+		}
+
+		// Execute db transaction.
+		if err := user.moderatorTx(binId); err != nil {
+			sendErrorResponse(w, err, http.StatusForbidden)
+			return
+		}
+
+		// Call the next handler in the chain.
+		next(w, req)
+	})
+}
+
 func handleSignup(w http.ResponseWriter, req *http.Request) {
 	type ReqBody struct {
 		Email string `json:"email"`
@@ -100,19 +174,10 @@ func handleSignup(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Create ULID.
-	id, binId := createUlid()
-
-	resBody.UserId = id.String()
-
-	user.UserId = id
 	user.Email = reqBody.Email
-	user.AuthGrp.LoginCode = generateLoginCode()
-	user.AuthGrp.LoginAttempts = 0
-	// Note, AuthGrp.LogoutTs will default to zero value.
 
 	// Execute db transaction.
-	err := user.signupTx(binId)
+	err := user.signup()
 	if err != nil {
 		fmt.Printf("[err][api] updating db with new user: %v [%s]\n", err, cts())
 		const statusUnprocessableEntity = 422
@@ -120,16 +185,13 @@ func handleSignup(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	resBody.UserId = user.UserId.String()
+
 	// Success. Reply with userId.
 	encodeJsonAndRespond(w, resBody)
 
 	// Send email to user in production environment.
-	if env != nil && *env == "prod" {
-		err = sendEmail(user.Email, "Login code for Cooperative Party!", fmt.Sprintf("Thanks for signing up! You may now login using the following code: %v", user.AuthGrp.LoginCode))
-		if err != nil {
-			fmt.Printf("[err][api] sending email to user: %v [%s]\n", err, cts())
-		}
-	}
+	user.sendLoginCodeEmail(true)
 }
 
 func handleLogin(w http.ResponseWriter, req *http.Request) {
@@ -164,18 +226,26 @@ func handleLogin(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Convert ulid to byte slice to use as db key.
+	binId, err := getBinId(w, user.UserId)
+	if err != nil {
+		return
+	}
+
+	// Suspended and banned users may not login (nor are sent a login code).
+	if err := user.checkSanctionsTx(binId); err != nil {
+		fmt.Printf("[err][api] checking user sanctions: %v [%s]\n", err, cts())
+		sendLoginSanctionErrorResponse(w, err)
+		return
+	}
+
 	resBody.UserId = user.UserId.String()
 
 	// Success. Reply with userId.
 	encodeJsonAndRespond(w, resBody)
 
 	// Send email to user in production environment.
-	if env != nil && *env == "prod" {
-		err = sendEmail(user.Email, "Login code for Cooperative Party!", fmt.Sprintf("It looks like you're attempting to login to Cooperative Party. Please proceed by entering the following code: %v", user.AuthGrp.LoginCode))
-		if err != nil {
-			fmt.Printf("[err][api] sending email to user: %v [%s]\n", err, cts())
-		}
-	}
+	user.sendLoginCodeEmail(false)
 }
 
 func handleLoginCode(w http.ResponseWriter, req *http.Request) {
@@ -229,9 +299,10 @@ func handleLoginCode(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Success. Create and reply with token.
-	sessionInfo := fmt.Sprintf("%s.%s", strconv.Itoa(user.AuthGrp.LoginCode), user.AuthGrp.LogoutTs)
-	signedSessionInfo := signMessage(sessionInfo)
-	resBody.Token = fmt.Sprintf("%s.%s", user.UserId, signedSessionInfo)
+	resBody.Token = user.createToken()
+
+	// Also issue the token as a session cookie for server-rendered pages.
+	setSessionCookie(w, resBody.Token)
 
 	encodeJsonAndRespond(w, resBody)
 }
@@ -264,22 +335,78 @@ func handleLogout(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Explicitly set LoginAttempts to zero, even though they were reset
-	// during login-code validation, and even though the marshaling process
-	// would default to zero value anyway.
-	user.AuthGrp.LoginAttempts = 0
-	// Generate new login code and set logoutTs to now.
-	user.AuthGrp.LoginCode = generateLoginCode()
-	user.AuthGrp.LogoutTs = time.Now()
-
 	// Execute db transaction.
-	err = user.logoutTx(binId)
+	err = user.logout(binId)
 	if err != nil {
 		fmt.Printf("[err][api] updating db in logout transaction: %v [%s]\n", err, cts())
 		sendErrorResponse(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	// Success. Respond with 204 No Content.
+	// Success. Clear session cookie and respond with 204 No Content.
+	clearSessionCookie(w)
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// Responds with 403 if a user is restricted by a sanction, see
+// checkSanctionsTx.
+func sendLoginSanctionErrorResponse(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUserSuspended) || errors.Is(err, errUserBanned) {
+		sendErrorResponse(w, err, http.StatusForbidden)
+		return
+	}
+	sendErrorResponse(w, err, http.StatusInternalServerError)
+}
+
+// Creates a new user for the receiver's email, with a fresh login code. Shared
+// by handleSignup and ssrSignupPost.
+func (u *User) signup() error {
+	// Create ULID.
+	id, binId := createUlid()
+
+	u.UserId = id
+	u.AuthGrp.LoginCode = generateLoginCode()
+	u.AuthGrp.LoginAttempts = 0
+	// Note, AuthGrp.LogoutTs will default to zero value.
+
+	// Execute db transaction.
+	return u.signupTx(binId)
+}
+
+// Sends the login code to the user's email in production environment.
+func (u *User) sendLoginCodeEmail(isSignup bool) {
+	if env == nil || *env != "prod" {
+		return
+	}
+	body := fmt.Sprintf("It looks like you're attempting to login to Cooperative Party. Please proceed by entering the following code: %v", u.AuthGrp.LoginCode)
+	if isSignup {
+		body = fmt.Sprintf("Thanks for signing up! You may now login using the following code: %v", u.AuthGrp.LoginCode)
+	}
+	err := sendEmail(u.Email, "Login code for Cooperative Party!", body)
+	if err != nil {
+		fmt.Printf("[err][api] sending email to user: %v [%s]\n", err, cts())
+	}
+}
+
+// Creates a token made up of the userId and a key-signed signature of the
+// current session info (login code + logoutTs), separated by a period.
+func (u *User) createToken() string {
+	sessionInfo := fmt.Sprintf("%s.%s", strconv.Itoa(u.AuthGrp.LoginCode), u.AuthGrp.LogoutTs)
+	signedSessionInfo := signMessage(sessionInfo)
+	return fmt.Sprintf("%s.%s", u.UserId, signedSessionInfo)
+}
+
+// Invalidates all existing tokens for the user by rotating the login code and
+// logoutTs. Shared by handleLogout and ssrLogoutPost.
+func (u *User) logout(binId []byte) error {
+	// Explicitly set LoginAttempts to zero, even though they were reset
+	// during login-code validation, and even though the marshaling process
+	// would default to zero value anyway.
+	u.AuthGrp.LoginAttempts = 0
+	// Generate new login code and set logoutTs to now.
+	u.AuthGrp.LoginCode = generateLoginCode()
+	u.AuthGrp.LogoutTs = time.Now()
+
+	// Execute db transaction.
+	return u.logoutTx(binId)
+}
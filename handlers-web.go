@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"html/template"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/oklog/ulid"
 )
 
 func ssrHome(w http.ResponseWriter, req *http.Request) {
@@ -14,33 +18,46 @@ func ssrHome(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Keep "base" template as first file in the slice.
-	files := []string{
-		"./ui/base.tmpl.html",
-		"./ui/partial-nav.tmpl.html",
-		"./ui/home.tmpl.html",
-	}
+	data := newTemplateData(req)
 
-	// Read the template files into a template set.
-	ts, err := template.ParseFiles(files...)
-	if err != nil {
-		fmt.Printf("[err][api] parsing template file: %v [%s]\n", err, cts())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	// Show search results instead of the list when searching.
+	if q := req.URL.Query().Get("q"); q != "" {
+		data.SearchQuery = q
+		query := normalizeText(q, false)
+		if !maxChars(query, maxSearchQueryChars) {
+			query = string([]rune(query)[:maxSearchQueryChars])
+		}
+		if err := data.SearchResults.searchEximsTx(query, defaultSearchLimit); err != nil {
+			fmt.Printf("[err][api] searching exims: %v [%s]\n", err, cts())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		renderPage(w, http.StatusOK, "home.tmpl.html", data)
 		return
 	}
 
-	// Use the ExecuteTemplate() method to write the content of the "base"
-	// template as the response body.
-	err = ts.ExecuteTemplate(w, "base", nil)
-	if err != nil {
-		fmt.Printf("[err][api] executing template: %v [%s]\n", err, cts())
+	// List the most supported exims first.
+	data.EximPage = new(EximPage)
+	query := EximQuery{Limit: defaultEximPageLimit, Sort: eximSortSupported}
+	if err := data.EximPage.getEximPageTx(query); err != nil {
+		fmt.Printf("[err][api] fetching exims: %v [%s]\n", err, cts())
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
+	renderPage(w, http.StatusOK, "home.tmpl.html", data)
+
 	// w.Write([]byte("Hello from Cooperative Party"))
 }
 
 func ssrEximDetails(w http.ResponseWriter, req *http.Request) {
+	form := commentForm{ParentId: req.URL.Query().Get("reply")}
+	renderEximDetails(w, req, http.StatusOK, form)
+}
+
+// Renders the exim details page, including its comments and the comment form
+// (which replies to form.ParentId, if set).
+func renderEximDetails(w http.ResponseWriter, req *http.Request, status int, form commentForm) {
 	var exim *Exim = new(Exim)
 	eximId := req.PathValue("ulid")
 
@@ -55,38 +72,786 @@ func ssrEximDetails(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Execute db transaction.
+	// Execute db transactions.
 	err = exim.getEximDetailsTx(eximBinId)
+	if err == nil {
+		err = exim.checkVisibleTx(getViewerId(req))
+	}
+	if errors.Is(err, errEximNotFound) {
+		http.NotFound(w, req)
+		return
+	}
 	if err != nil {
 		fmt.Printf("[err][api] fetching exim details: %v [%s]\n", err, cts())
 		sendErrorResponse(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	// Keep "base" template as first file in the slice.
-	files := []string{
-		"./ui/base.tmpl.html",
-		"./ui/partial-nav.tmpl.html",
-		"./ui/exim-view.tmpl.html",
+	// Render page, passing in exim struct (or tombstone) as data.
+	exim.tombstone()
+	if err := exim.presentTx(); err != nil {
+		fmt.Printf("[err][api] fetching exim author: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data := newTemplateData(req)
+	data.Exim = exim
+
+	// Comments are hidden along with the content of a withdrawn or removed exim.
+	if exim.Removal == nil {
+		if err := data.Comments.getEximCommentsTx(eximBinId); err != nil {
+			fmt.Printf("[err][api] fetching exim comments: %v [%s]\n", err, cts())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if form.ParentId != "" {
+			if form.Parent = data.Comments.find(form.ParentId); form.Parent == nil {
+				form.ParentId = ""
+			}
+		}
 	}
+	data.Form = form
+
+	renderPage(w, status, "exim-view.tmpl.html", data)
+}
 
-	// Read the template files into a template set.
-	ts, err := template.ParseFiles(files...)
+func ssrCreateCommentPost(w http.ResponseWriter, req *http.Request) {
+	var comment *Comment = new(Comment)
+	var eximId ulid.ULID
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	data := newTemplateData(req)
+	if !data.IsAuthenticated {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Decode & unmarshal ulid from string into eximId.
+	if err := unmarshalUlid(w, &eximId, req.PathValue("ulid")); err != nil {
+		return
+	}
+	// Convert ulid to byte slice to use as db key.
+	eximBinId, err := getBinId(w, eximId)
 	if err != nil {
-		fmt.Printf("[err][api] parsing template file: %v [%s]\n", err, cts())
+		return
+	}
+
+	comment.AuthorId = data.UserId.String()
+	comment.ParentId = req.PostFormValue("parentId")
+	comment.Body = req.PostFormValue("body")
+	comment.normalize()
+
+	form := commentForm{Body: comment.Body, ParentId: comment.ParentId}
+	form.FieldErrors = comment.validate()
+
+	// Execute db transaction.
+	if len(form.FieldErrors) == 0 {
+		if err := comment.createCommentTx(eximBinId); err != nil {
+			fmt.Printf("[err][api] updating db with new comment: %v [%s]\n", err, cts())
+			switch {
+			case errors.Is(err, errCommentNotFound), errors.Is(err, errCommentTooDeep):
+				form.FieldErrors["body"] = "This comment can no longer be replied to."
+			case errors.Is(err, errEximRemoved), errors.Is(err, errEximNotCommentable):
+				form.FieldErrors["body"] = "This exim cannot be commented on."
+			default:
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	// Re-render page with errors inline.
+	if len(form.FieldErrors) > 0 {
+		renderEximDetails(w, req, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	// Success. Show the comment in its thread.
+	http.Redirect(w, req, fmt.Sprintf("/exim/details/%s#comment-%s", eximId, comment.CommentId), http.StatusSeeOther)
+}
+
+func ssrCreateExim(w http.ResponseWriter, req *http.Request) {
+	data := newTemplateData(req)
+	if !data.IsAuthenticated {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	form := eximForm{Exim: new(Exim), MaxSections: maxEximSections}
+	err := form.AvailableTags.getTagsTx()
+	if err == nil {
+		err = form.AvailableChapters.getChaptersTx()
+	}
+	if err != nil {
+		fmt.Printf("[err][api] fetching tags and chapters: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data.Form = form
+	renderPage(w, http.StatusOK, "exim-create.tmpl.html", data)
+}
+
+// Handles each step of the create form. The "preview" action re-renders the
+// submitted values as they will appear once published, the "edit" action
+// returns to the form, and the "publish" action validates and writes the exim.
+func ssrCreateEximPost(w http.ResponseWriter, req *http.Request) {
+	var exim *Exim = new(Exim)
+	var form eximForm
+	var userId ulid.ULID
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	data := newTemplateData(req)
+	if !data.IsAuthenticated {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	userId = data.UserId
+
+	exim.Target = req.PostFormValue("target")
+	exim.Title = req.PostFormValue("title")
+	exim.Summary = req.PostFormValue("summary")
+	exim.Sections = sectionsFromForm(req)
+	exim.Link = req.PostFormValue("link")
+	exim.Tags = req.PostForm["tags"]
+	exim.Chapter = req.PostFormValue("chapter")
+	exim.IsAnonymous = req.PostFormValue("anonymous") == "on"
+	exim.Author = userId.String()
+	exim.normalize()
+
+	form.Exim = exim
+	form.MaxSections = maxEximSections
+	form.FieldErrors = exim.validate()
+	err := form.AvailableTags.getTagsTx()
+	if err == nil {
+		err = form.AvailableChapters.getChaptersTx()
+	}
+	if err != nil {
+		fmt.Printf("[err][api] fetching tags and chapters: %v [%s]\n", err, cts())
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// Use the ExecuteTemplate() method to write the content of the "base"
-	// template as the response body. Pass in exim struct as data.
-	err = ts.ExecuteTemplate(w, "base", exim)
+	action := req.PostFormValue("action")
+
+	// Re-render form when returning from preview, or with room for another
+	// section. The form is still being written, so errors aren't shown yet.
+	if action == "edit" || action == "add-section" {
+		form.FieldErrors = map[string]string{}
+		data.Form = form
+		renderPage(w, http.StatusOK, "exim-create.tmpl.html", data)
+		return
+	}
+
+	// Re-render form with errors inline.
+	if len(form.FieldErrors) > 0 {
+		data.Form = form
+		renderPage(w, http.StatusUnprocessableEntity, "exim-create.tmpl.html", data)
+		return
+	}
+
+	// Show preview before publishing, with the author as it will be shown.
+	// The exim itself keeps its Author, which is needed to publish it.
+	if action != "publish" {
+		preview := *exim
+		if err := preview.presentTx(); err != nil {
+			fmt.Printf("[err][api] fetching exim author: %v [%s]\n", err, cts())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		form.Exim = &preview
+		form.IsPreview = true
+		data.Form = form
+		renderPage(w, http.StatusOK, "exim-create.tmpl.html", data)
+		return
+	}
+
+	// Execute db transaction.
+	err = exim.create(userId)
 	if err != nil {
-		fmt.Printf("[err][api] executing template: %v [%s]\n", err, cts())
+		fmt.Printf("[err][api] updating db with new exim: %v [%s]\n", err, cts())
+		// A tag or chapter may have been deleted while the form was open.
+		if errors.Is(err, errTagNotFound) {
+			form.FieldErrors["tags"] = "Please choose from the available tags."
+			data.Form = form
+			renderPage(w, http.StatusUnprocessableEntity, "exim-create.tmpl.html", data)
+			return
+		}
+		if errors.Is(err, errChapterNotFound) {
+			form.FieldErrors["chapter"] = "Please choose from the available chapters."
+			data.Form = form
+			renderPage(w, http.StatusUnprocessableEntity, "exim-create.tmpl.html", data)
+			return
+		}
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
+
+	// Success. Show the published exim.
+	http.Redirect(w, req, fmt.Sprintf("/exim/details/%s", exim.EximId), http.StatusSeeOther)
 }
 
-func ssrCreateExim(w http.ResponseWriter, req *http.Request) {
-	w.Write([]byte("Create a new exim..."))
+// Reads the form's sections, which are submitted as pairs of section_heading
+// and section_body fields, in order.
+func sectionsFromForm(req *http.Request) EximSections {
+	headings := req.PostForm["section_heading"]
+	bodies := req.PostForm["section_body"]
+
+	var sections EximSections
+	for i := 0; i < min(len(headings), len(bodies)); i++ {
+		sections = append(sections, EximSection{Heading: headings[i], Body: bodies[i]})
+	}
+	return sections
+}
+
+func ssrTags(w http.ResponseWriter, req *http.Request) {
+	data := newTemplateData(req)
+
+	// Execute db transaction.
+	if err := data.Tags.getTagsTx(); err != nil {
+		fmt.Printf("[err][api] fetching tags: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	renderPage(w, http.StatusOK, "tag-list.tmpl.html", data)
+}
+
+// Lists the exims with a tag, newest first, a page at a time.
+func ssrTagExims(w http.ResponseWriter, req *http.Request) {
+	data := newTemplateData(req)
+	data.Tag = &Tag{Slug: req.PathValue("slug")}
+	data.EximPage = new(EximPage)
+
+	// Execute db transactions.
+	if err := data.Tag.getTagTx(); err != nil {
+		fmt.Printf("[err][api] fetching tag: %v [%s]\n", err, cts())
+		http.NotFound(w, req)
+		return
+	}
+	query := EximQuery{Limit: defaultEximPageLimit, Sort: eximSortNewest, Tag: data.Tag.Slug}
+	if s := req.URL.Query().Get("after"); s != "" {
+		query.After, _ = parseEximCursor(s)
+	} else if s := req.URL.Query().Get("before"); s != "" {
+		query.Before, _ = parseEximCursor(s)
+	}
+	if err := data.EximPage.getEximPageTx(query); err != nil {
+		fmt.Printf("[err][api] fetching exims: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	renderPage(w, http.StatusOK, "tag-view.tmpl.html", data)
+}
+
+func ssrChapters(w http.ResponseWriter, req *http.Request) {
+	data := newTemplateData(req)
+
+	// Execute db transaction.
+	if err := data.Chapters.getChaptersTx(); err != nil {
+		fmt.Printf("[err][api] fetching chapters: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	renderPage(w, http.StatusOK, "chapter-list.tmpl.html", data)
+}
+
+// Renders a chapter along with the exims scoped to it or published by its
+// members.
+func ssrChapterExims(w http.ResponseWriter, req *http.Request) {
+	data := newTemplateData(req)
+	data.Chapter = &Chapter{Slug: req.PathValue("slug")}
+	data.EximPage = new(EximPage)
+
+	// Execute db transactions.
+	if err := data.Chapter.getChapterTx(); err != nil {
+		fmt.Printf("[err][api] fetching chapter: %v [%s]\n", err, cts())
+		http.NotFound(w, req)
+		return
+	}
+	query := EximQuery{Limit: defaultEximPageLimit, Sort: eximSortNewest, Chapter: data.Chapter.Slug}
+	if s := req.URL.Query().Get("after"); s != "" {
+		query.After, _ = parseEximCursor(s)
+	} else if s := req.URL.Query().Get("before"); s != "" {
+		query.Before, _ = parseEximCursor(s)
+	}
+	if err := data.EximPage.getEximPageTx(query); err != nil {
+		fmt.Printf("[err][api] fetching exims: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	renderPage(w, http.StatusOK, "chapter-view.tmpl.html", data)
+}
+
+// Renders a member's profile along with the exims they have published under
+// their name.
+func ssrMember(w http.ResponseWriter, req *http.Request) {
+	var userId ulid.ULID
+	data := newTemplateData(req)
+	data.Profile = new(Profile)
+	data.EximPage = new(EximPage)
+
+	// Decode & unmarshal ulid from string into userId.
+	if err := unmarshalUlid(w, &userId, req.PathValue("ulid")); err != nil {
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, userId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transactions.
+	if err := data.Profile.getProfileTx(userBinId); err != nil {
+		fmt.Printf("[err][api] fetching profile: %v [%s]\n", err, cts())
+		http.NotFound(w, req)
+		return
+	}
+	query := EximQuery{Limit: defaultEximPageLimit, Sort: eximSortNewest, Author: data.Profile.UserId}
+	if s := req.URL.Query().Get("after"); s != "" {
+		query.After, _ = parseEximCursor(s)
+	} else if s := req.URL.Query().Get("before"); s != "" {
+		query.Before, _ = parseEximCursor(s)
+	}
+	if err := data.EximPage.getEximPageTx(query); err != nil {
+		fmt.Printf("[err][api] fetching exims: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	renderPage(w, http.StatusOK, "member-view.tmpl.html", data)
+}
+
+func ssrEditProfile(w http.ResponseWriter, req *http.Request) {
+	data := newTemplateData(req)
+	if !data.IsAuthenticated {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, data.UserId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	form := profileForm{Profile: new(Profile)}
+	if err := form.Profile.getProfileTx(userBinId); err != nil {
+		fmt.Printf("[err][api] fetching profile: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data.Form = form
+	renderPage(w, http.StatusOK, "member-edit.tmpl.html", data)
+}
+
+func ssrEditProfilePost(w http.ResponseWriter, req *http.Request) {
+	var profile *Profile = new(Profile)
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	data := newTemplateData(req)
+	if !data.IsAuthenticated {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, data.UserId)
+	if err != nil {
+		return
+	}
+
+	profile.DisplayName = req.PostFormValue("displayName")
+	profile.Bio = req.PostFormValue("bio")
+	profile.Pronouns = req.PostFormValue("pronouns")
+	profile.normalize()
+
+	// Re-render form with errors inline.
+	form := profileForm{Profile: profile, FieldErrors: profile.validate()}
+	if len(form.FieldErrors) > 0 {
+		data.Form = form
+		renderPage(w, http.StatusUnprocessableEntity, "member-edit.tmpl.html", data)
+		return
+	}
+
+	// Execute db transaction.
+	if err := profile.updateProfileTx(userBinId); err != nil {
+		fmt.Printf("[err][api] updating db with profile: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Show the updated profile.
+	http.Redirect(w, req, fmt.Sprintf("/member/%s", profile.UserId), http.StatusSeeOther)
+}
+
+func ssrEditAddress(w http.ResponseWriter, req *http.Request) {
+	var location MemberLocation
+	data := newTemplateData(req)
+	if !data.IsAuthenticated {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, data.UserId)
+	if err != nil {
+		return
+	}
+
+	// Execute db transaction.
+	if err := location.getLocationTx(userBinId); err != nil {
+		fmt.Printf("[err][api] fetching address: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	form := addressForm{Address: location.Address, Chapter: location.Chapter}
+	if form.Address == nil {
+		form.Address = new(Address)
+	}
+	data.Form = form
+	renderPage(w, http.StatusOK, "member-address.tmpl.html", data)
+}
+
+// Sets the member's address, or removes it if the "remove" action is chosen.
+func ssrEditAddressPost(w http.ResponseWriter, req *http.Request) {
+	var location MemberLocation
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	data := newTemplateData(req)
+	if !data.IsAuthenticated {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Convert ulid to byte slice to use as db key.
+	userBinId, err := getBinId(w, data.UserId)
+	if err != nil {
+		return
+	}
+
+	if req.PostFormValue("action") != "remove" {
+		location.Address = &Address{
+			Line1:      req.PostFormValue("line1"),
+			Line2:      req.PostFormValue("line2"),
+			City:       req.PostFormValue("city"),
+			Region:     req.PostFormValue("region"),
+			PostalCode: req.PostFormValue("postalCode"),
+			Country:    req.PostFormValue("country"),
+		}
+		location.Address.normalize()
+
+		// Re-render form with errors inline.
+		form := addressForm{Address: location.Address, FieldErrors: location.Address.validate()}
+		if len(form.FieldErrors) > 0 {
+			data.Form = form
+			renderPage(w, http.StatusUnprocessableEntity, "member-address.tmpl.html", data)
+			return
+		}
+	}
+
+	// Execute db transaction.
+	if err := location.updateAddressTx(userBinId); err != nil {
+		fmt.Printf("[err][api] updating db with address: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Show the stored address and assigned chapter.
+	http.Redirect(w, req, "/address", http.StatusSeeOther)
+}
+
+func ssrAbout(w http.ResponseWriter, req *http.Request) {
+	renderPage(w, http.StatusOK, "about.tmpl.html", newTemplateData(req))
+}
+
+func ssrSignup(w http.ResponseWriter, req *http.Request) {
+	data := newTemplateData(req)
+	if data.IsAuthenticated {
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+		return
+	}
+	data.Form = userForm{}
+	renderPage(w, http.StatusOK, "user-signup.tmpl.html", data)
+}
+
+func ssrSignupPost(w http.ResponseWriter, req *http.Request) {
+	var user *User = new(User)
+	var form userForm
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	form.Email = strings.TrimSpace(req.PostFormValue("email"))
+	form.FieldErrors = map[string]string{}
+
+	// Unlike the JSON API, there is no client to validate the email address.
+	if err := validateEmail(form.Email); err != nil {
+		form.FieldErrors["email"] = "Please enter a valid email address."
+	} else {
+		// Execute db transaction.
+		user.Email = form.Email
+		if err := user.signup(); err != nil {
+			fmt.Printf("[err][api] updating db with new user: %v [%s]\n", err, cts())
+			form.FieldErrors["email"] = "An account already exists for this email address, please login instead."
+		}
+	}
+
+	// Re-render form with errors inline.
+	if len(form.FieldErrors) > 0 {
+		data := newTemplateData(req)
+		data.Form = form
+		renderPage(w, http.StatusUnprocessableEntity, "user-signup.tmpl.html", data)
+		return
+	}
+
+	// Send email to user in production environment.
+	user.sendLoginCodeEmail(true)
+
+	// Success. Proceed to code entry.
+	http.Redirect(w, req, fmt.Sprintf("/login/code?user=%s", user.UserId), http.StatusSeeOther)
+}
+
+func ssrLogin(w http.ResponseWriter, req *http.Request) {
+	data := newTemplateData(req)
+	if data.IsAuthenticated {
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+		return
+	}
+	data.Form = userForm{}
+	renderPage(w, http.StatusOK, "user-login.tmpl.html", data)
+}
+
+func ssrLoginPost(w http.ResponseWriter, req *http.Request) {
+	var user *User = new(User)
+	var form userForm
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	form.Email = strings.TrimSpace(req.PostFormValue("email"))
+	form.FieldErrors = map[string]string{}
+
+	if err := validateEmail(form.Email); err != nil {
+		form.FieldErrors["email"] = "Please enter a valid email address."
+	} else {
+		// Execute db transaction.
+		user.Email = form.Email
+		if err := user.loginTx(); err != nil {
+			fmt.Printf("[err][api] querying db for user email: %v [%s]\n", err, cts())
+			form.FieldErrors["email"] = "This email address is not on file, please signup instead."
+		} else if err := checkLoginSanctions(user); err != nil {
+			// Suspended and banned users may not login.
+			fmt.Printf("[err][api] checking user sanctions: %v [%s]\n", err, cts())
+			form.FieldErrors["email"] = fmt.Sprintf("This %v.", err)
+		}
+	}
+
+	// Re-render form with errors inline.
+	if len(form.FieldErrors) > 0 {
+		data := newTemplateData(req)
+		data.Form = form
+		renderPage(w, http.StatusUnprocessableEntity, "user-login.tmpl.html", data)
+		return
+	}
+
+	// Send email to user in production environment.
+	user.sendLoginCodeEmail(false)
+
+	// Success. Proceed to code entry.
+	http.Redirect(w, req, fmt.Sprintf("/login/code?user=%s", user.UserId), http.StatusSeeOther)
+}
+
+func ssrLoginCode(w http.ResponseWriter, req *http.Request) {
+	data := newTemplateData(req)
+	if data.IsAuthenticated {
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+		return
+	}
+
+	// Without a (valid) userId, start over.
+	userId, err := ulid.ParseStrict(req.URL.Query().Get("user"))
+	if err != nil {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+
+	data.Form = userForm{UserId: userId.String()}
+	renderPage(w, http.StatusOK, "user-login-code.tmpl.html", data)
+}
+
+func ssrLoginCodePost(w http.ResponseWriter, req *http.Request) {
+	var user *User = new(User)
+	var form userForm
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	form.UserId = req.PostFormValue("userId")
+	form.FieldErrors = map[string]string{}
+
+	userId, binId, err := parseUlidString(form.UserId)
+	if err != nil {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	user.UserId = userId
+
+	code, err := strconv.Atoi(strings.TrimSpace(req.PostFormValue("code")))
+	if err != nil {
+		form.FieldErrors["code"] = "Please enter the 6 digit code from your email."
+	} else {
+		// Execute db transaction.
+		err = user.loginCodeTx(binId, code)
+		if err != nil {
+			fmt.Printf("[err][api] updating db in login-code transaction: %v [%s]\n", err, cts())
+			form.Message = "Too many incorrect attempts. Please request a new code by logging in again."
+		} else if user.AuthGrp.LoginCode != code {
+			form.FieldErrors["code"] = fmt.Sprintf("Incorrect code, %d attempt(s) remaining.", user.calculateRemainingAttempts())
+		}
+	}
+
+	// Re-render form with errors inline.
+	if len(form.FieldErrors) > 0 || form.Message != "" {
+		data := newTemplateData(req)
+		data.Form = form
+		renderPage(w, http.StatusUnprocessableEntity, "user-login-code.tmpl.html", data)
+		return
+	}
+
+	// Success. Issue session cookie and go home.
+	setSessionCookie(w, user.createToken())
+	http.Redirect(w, req, "/", http.StatusSeeOther)
+}
+
+func ssrLogoutPost(w http.ResponseWriter, req *http.Request) {
+	var user *User = new(User)
+	fmt.Printf("[api] handling POST to %s [%s]\n", req.URL.Path, cts())
+
+	// Nothing to do if not logged in.
+	id, ok := req.Context().Value(userIdContextKey).(ulid.ULID)
+	if !ok {
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+		return
+	}
+	user.UserId = id
+
+	// Convert ulid to byte slice to use as db key.
+	binId, err := user.UserId.MarshalBinary()
+	if err != nil {
+		fmt.Printf("[err][api] marshaling ULID: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Execute db transaction.
+	err = user.logout(binId)
+	if err != nil {
+		fmt.Printf("[err][api] updating db in logout transaction: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Success. Clear session cookie and go home.
+	clearSessionCookie(w)
+	http.Redirect(w, req, "/", http.StatusSeeOther)
+}
+
+func ssrEximHistory(w http.ResponseWriter, req *http.Request) {
+	var exim *Exim = new(Exim)
+	var revisions EximRevisions
+
+	// Parse ulid from path into exim.EximId and db key.
+	eximId, eximBinId, err := parseUlidString(req.PathValue("ulid"))
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	exim.EximId = eximId
+
+	// Execute db transactions.
+	err = exim.getEximDetailsTx(eximBinId)
+	if err == nil {
+		err = exim.checkVisibleTx(getViewerId(req))
+	}
+	if errors.Is(err, errEximNotFound) {
+		http.NotFound(w, req)
+		return
+	}
+	if err == nil {
+		err = revisions.getEximRevisionsTx(eximBinId)
+	}
+	if err != nil {
+		fmt.Printf("[err][api] fetching exim history: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// History of withdrawn or removed exims is gone; show the tombstone.
+	if exim.Removal != nil {
+		http.Redirect(w, req, fmt.Sprintf("/exim/details/%s", exim.EximId), http.StatusSeeOther)
+		return
+	}
+
+	// Each revision holds the version prior to an edit, so diff it against
+	// the next revision (or the current version). Newest edit first.
+	data := newTemplateData(req)
+	data.Exim = exim
+	for i := len(revisions) - 1; i >= 0; i-- {
+		next := exim
+		if i+1 < len(revisions) {
+			next = &revisions[i+1].Exim
+		}
+		data.EximChanges = append(data.EximChanges, diffExims(&revisions[i], next))
+	}
+
+	renderPage(w, http.StatusOK, "exim-history.tmpl.html", data)
+}
+
+// Diffs each field of a revision against the version that replaced it.
+func diffExims(rev *EximRevision, next *Exim) eximChange {
+	change := eximChange{EditorId: rev.EditorId, EditorName: rev.EditorName, EditedTs: rev.EditedTs}
+	prev := &rev.Exim
+	type field struct {
+		name string
+		a, b string
+	}
+	fields := []field{
+		{"Target", prev.Target, next.Target},
+		{"Title", prev.Title, next.Title},
+		{"Summary", prev.Summary, next.Summary},
+	}
+	// Sections are compared by position; added or removed sections diff
+	// against nothing.
+	for i := 0; i < max(len(prev.Sections), len(next.Sections)); i++ {
+		var a, b EximSection
+		if i < len(prev.Sections) {
+			a = prev.Sections[i]
+		}
+		if i < len(next.Sections) {
+			b = next.Sections[i]
+		}
+		fields = append(fields,
+			field{fmt.Sprintf("Section %d heading", i+1), a.Heading, b.Heading},
+			field{fmt.Sprintf("Section %d", i+1), a.Body, b.Body},
+		)
+	}
+	fields = append(fields,
+		field{"Chapter", prev.Chapter, next.Chapter},
+		field{"Link", prev.Link, next.Link},
+		field{"Tags", strings.Join(prev.Tags, ", "), strings.Join(next.Tags, ", ")},
+	)
+	for _, f := range fields {
+		if f.a != f.b {
+			change.Fields = append(change.Fields, fieldDiff{Name: f.name, Segments: diffWords(f.a, f.b)})
+		}
+	}
+	return change
+}
+
+// Checks whether a user found by loginTx is restricted by a sanction.
+func checkLoginSanctions(user *User) error {
+	binId, err := user.UserId.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return user.checkSanctionsTx(binId)
 }
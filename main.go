@@ -68,18 +68,123 @@ func main() {
 		if _, err := tx.CreateBucketIfNotExists([]byte("USER_VERIFIED")); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("USER_LEGAL_NAME")); err != nil {
+			return err
+		}
 		if _, err := tx.CreateBucketIfNotExists([]byte("USER_ADDR")); err != nil {
 			return err
 		}
 		if _, err := tx.CreateBucketIfNotExists([]byte("USER_AUTH")); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("USER_MODERATOR")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("TOTP")); err != nil {
+			return err
+		}
 		if _, err := tx.CreateBucketIfNotExists([]byte("BYPASS")); err != nil {
 			return err
 		}
 		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM")); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_REV")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_STATE")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_OUTCOME")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_SUPPORT")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_ABSTAIN")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_SUPPORT_COUNT")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_SUPPORT_RANK")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_SEARCH")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_COMMENT")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("REPORT")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("REPORT_OPEN")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_AUDIT")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("USER_SANCTION")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("USER_DELETION")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("USER_EMAIL_CHANGE")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("USER_PROFILE")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("USER_DELEGATION")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("POLL")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("POLL_BALLOT")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("POLL_VOTER")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("APPROVAL_RULE")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_PETITION")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("PETITION_SIGNATURE")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("EVENT")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("EVENT_RSVP")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("EVENT_REMINDED")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("USER_CHAPTER")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("CHAPTER")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("CHAPTER_MEMBER")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("TAG")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_TAG")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("MOD_EXIM_TAG_COUNT")); err != nil {
+			return err
+		}
 		return nil
 	})
 
@@ -88,9 +193,40 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Migrate exims stored before sections replaced Paragraph1..3.
+	if err := migrateEximSectionsTx(); err != nil {
+		fmt.Printf("[err][api] migrating exim sections: %v [%s]\n", err, cts())
+		os.Exit(1)
+	}
+	// Migrate exims stored before lifecycle states replaced IsApproved.
+	if err := migrateEximStatesTx(); err != nil {
+		fmt.Printf("[err][api] migrating exim states: %v [%s]\n", err, cts())
+		os.Exit(1)
+	}
+	// Index existing exims for search.
+	if err := buildSearchIndexTx(); err != nil {
+		fmt.Printf("[err][api] building search index: %v [%s]\n", err, cts())
+		os.Exit(1)
+	}
+	// Index the support of existing exims, for sorting by most supported.
+	if err := buildSupportIndexTx(); err != nil {
+		fmt.Printf("[err][api] building support index: %v [%s]\n", err, cts())
+		os.Exit(1)
+	}
+	// Count the listed exims of existing tags.
+	if err := buildTagCountsTx(); err != nil {
+		fmt.Printf("[err][api] counting tag exims: %v [%s]\n", err, cts())
+		os.Exit(1)
+	}
+
 	// Set global private key variable.
 	setPrivateKey()
 
+	// Approve submitted exims which meet an approval rule, in the background.
+	go runApprovalEvaluator()
+	// Remind members of events they have RSVP'd to, in the background.
+	go runEventReminder()
+
 	// Create file server.
 	fileServer := http.FileServer(http.Dir("./ui/static/"))
 
@@ -108,23 +244,135 @@ func main() {
 	// value of type http.HandlerFunc, which is a type that satisfies the
 	// http.Handler interface which requires a method with the signature
 	// ServeHTTP(http.ResponseWriter, *http.Request).
-	mux.HandleFunc("GET /", ssrHome)
+	mux.HandleFunc("GET /", sessionMiddleware(ssrHome))
 
 	// Since the fileServer is already pointed to the ./ui/static dir, when a
 	// request is made to /static/main.js (example) it should only search for
 	// the "/main.js" file, hence strip prefix.
 	mux.Handle("GET /static/", http.StripPrefix("/static", fileServer))
 
-	mux.HandleFunc("GET /api/exims", handleGetExims)
-	mux.HandleFunc("GET /exim/details/{ulid}", ssrEximDetails)
-	mux.HandleFunc("GET /api/exim/{ulid}", handleGetEximDetails)
-	mux.HandleFunc("GET /exim/create/", ssrCreateExim)
-	mux.HandleFunc("POST /api/exim/create/", authMiddleware(handleCreateExim))
+	mux.HandleFunc("GET /about", sessionMiddleware(ssrAbout))
+	mux.HandleFunc("GET /tags", sessionMiddleware(ssrTags))
+	mux.HandleFunc("GET /tag/{slug}", sessionMiddleware(ssrTagExims))
+	mux.HandleFunc("GET /chapters", sessionMiddleware(ssrChapters))
+	mux.HandleFunc("GET /chapter/{slug}", sessionMiddleware(ssrChapterExims))
+	mux.HandleFunc("GET /member/{ulid}", sessionMiddleware(ssrMember))
+	mux.HandleFunc("GET /profile", sessionMiddleware(ssrEditProfile))
+	mux.HandleFunc("POST /profile", sessionMiddleware(ssrEditProfilePost))
+	mux.HandleFunc("GET /address", sessionMiddleware(ssrEditAddress))
+	mux.HandleFunc("POST /address", sessionMiddleware(ssrEditAddressPost))
+	mux.HandleFunc("GET /signup", sessionMiddleware(ssrSignup))
+	mux.HandleFunc("POST /signup", sessionMiddleware(ssrSignupPost))
+	mux.HandleFunc("GET /login", sessionMiddleware(ssrLogin))
+	mux.HandleFunc("POST /login", sessionMiddleware(ssrLoginPost))
+	mux.HandleFunc("GET /login/code", sessionMiddleware(ssrLoginCode))
+	mux.HandleFunc("POST /login/code", sessionMiddleware(ssrLoginCodePost))
+	mux.HandleFunc("POST /logout", sessionMiddleware(ssrLogoutPost))
+	mux.HandleFunc("GET /api/exims", optionalAuthMiddleware(handleGetExims))
+	mux.HandleFunc("GET /api/exims/search", handleSearchExims)
+	mux.HandleFunc("GET /api/tags", handleGetTags)
+	mux.HandleFunc("GET /api/tag/{slug}", handleGetTag)
+	mux.HandleFunc("GET /api/chapters", handleGetChapters)
+	mux.HandleFunc("GET /api/chapter/{slug}", handleGetChapter)
+	mux.HandleFunc("GET /api/chapter/{slug}/events.ics", handleGetChapterFeed)
+	mux.HandleFunc("GET /api/approval-rules", handleGetApprovalRules)
+	mux.HandleFunc("GET /api/polls", handleGetPolls)
+	mux.HandleFunc("GET /api/poll/{ulid}", handleGetPoll)
+	mux.HandleFunc("GET /api/poll/{ulid}/voter", authMiddleware(handleGetPollVoter))
+	mux.HandleFunc("POST /api/poll/{ulid}/ballot/", authMiddleware(handleCastBallot))
+	mux.HandleFunc("GET /api/poll/{ulid}/results", handleGetPollResults)
+	mux.HandleFunc("GET /api/events", handleGetEvents)
+	mux.HandleFunc("GET /api/event/{ulid}", handleGetEvent)
+	mux.HandleFunc("POST /api/event/create/{$}", authMiddleware(handleCreateEvent))
+	mux.HandleFunc("PUT /api/event/{ulid}", authMiddleware(handleEditEvent))
+	mux.HandleFunc("DELETE /api/event/{ulid}", authMiddleware(handleCancelEvent))
+	mux.HandleFunc("GET /api/event/{ulid}/rsvp", authMiddleware(handleGetRSVP))
+	mux.HandleFunc("POST /api/event/{ulid}/rsvp/", authMiddleware(handleRSVPEvent))
+	mux.HandleFunc("DELETE /api/event/{ulid}/rsvp/", authMiddleware(handleRSVPEvent))
+	mux.HandleFunc("GET /exim/details/{ulid}", sessionMiddleware(ssrEximDetails))
+	mux.HandleFunc("GET /exim/details/{ulid}/history", sessionMiddleware(ssrEximHistory))
+	mux.HandleFunc("POST /exim/details/{ulid}/comments", sessionMiddleware(ssrCreateCommentPost))
+	mux.HandleFunc("GET /api/exim/{ulid}", optionalAuthMiddleware(handleGetEximDetails))
+	mux.HandleFunc("PUT /api/exim/{ulid}", authMiddleware(handleEditExim))
+	mux.HandleFunc("GET /api/exim/{ulid}/revisions", optionalAuthMiddleware(handleGetEximRevisions))
+	mux.HandleFunc("POST /api/exim/{ulid}/withdraw/", authMiddleware(handleWithdrawExim))
+	mux.HandleFunc("POST /api/exim/{ulid}/remove/", modMiddleware(handleRemoveExim))
+	mux.HandleFunc("POST /api/exim/{ulid}/transition/", authMiddleware(handleTransitionExim))
+	mux.HandleFunc("GET /api/exim/{ulid}/transitions", optionalAuthMiddleware(handleGetEximTransitions))
+	mux.HandleFunc("POST /api/exim/{ulid}/outcomes", authMiddleware(handleCreateOutcome))
+	mux.HandleFunc("POST /api/exim/{ulid}/support/", authMiddleware(handleSupportExim))
+	mux.HandleFunc("GET /api/exim/{ulid}/comments", handleGetEximComments)
+	mux.HandleFunc("POST /api/exim/{ulid}/comments", authMiddleware(handleCreateComment))
+	mux.HandleFunc("PUT /api/exim/{ulid}/comments/{commentId}", authMiddleware(handleEditComment))
+	mux.HandleFunc("DELETE /api/exim/{ulid}/comments/{commentId}", authMiddleware(handleDeleteComment))
+	mux.HandleFunc("DELETE /api/exim/{ulid}/support/", authMiddleware(handleSupportExim))
+	mux.HandleFunc("POST /api/exim/{ulid}/abstain/", authMiddleware(handleAbstainExim))
+	mux.HandleFunc("DELETE /api/exim/{ulid}/abstain/", authMiddleware(handleAbstainExim))
+	mux.HandleFunc("GET /api/exim/{ulid}/petition", handleGetPetition)
+	mux.HandleFunc("PUT /api/exim/{ulid}/petition", authMiddleware(handleSetPetition))
+	mux.HandleFunc("POST /api/exim/{ulid}/petition/sign/", authMiddleware(handleSignPetition))
+	mux.HandleFunc("DELETE /api/exim/{ulid}/petition/sign/", authMiddleware(handleSignPetition))
+	mux.HandleFunc("GET /api/exim/{ulid}/petition/signatures", modMiddleware(handleExportPetition))
+	mux.HandleFunc("POST /api/exim/{ulid}/report/", authMiddleware(handleReportExim))
+	mux.HandleFunc("POST /api/exim/{ulid}/comments/{commentId}/report/", authMiddleware(handleReportComment))
+	mux.HandleFunc("GET /api/mod/reports", modMiddleware(handleGetReports))
+	mux.HandleFunc("POST /api/mod/report/{ulid}/resolve/", modMiddleware(handleResolveReport))
+	mux.HandleFunc("GET /api/mod/audit", modMiddleware(handleGetAudit))
+	mux.HandleFunc("GET /exim/create/", sessionMiddleware(ssrCreateExim))
+	mux.HandleFunc("POST /exim/create/", sessionMiddleware(ssrCreateEximPost))
+	mux.HandleFunc("POST /api/exim/create/{$}", authMiddleware(handleCreateExim))
 	mux.HandleFunc("POST /api/user/signup/", handleSignup)
 	mux.HandleFunc("POST /api/user/login/", handleLogin)
 	mux.HandleFunc("POST /api/user/login-code/", handleLoginCode)
 	mux.HandleFunc("POST /api/user/logout/", authMiddleware(handleLogout))
+	mux.HandleFunc("GET /api/user/{ulid}/profile", handleGetProfile)
+	mux.HandleFunc("PUT /api/user/profile", authMiddleware(handleUpdateProfile))
+	mux.HandleFunc("GET /api/user/address", authMiddleware(handleGetAddress))
+	mux.HandleFunc("PUT /api/user/address", authMiddleware(handleSetAddress))
+	mux.HandleFunc("DELETE /api/user/address", authMiddleware(handleSetAddress))
+	mux.HandleFunc("GET /api/user/delegations", authMiddleware(handleGetDelegations))
+	mux.HandleFunc("PUT /api/user/delegation/{$}", authMiddleware(handleSetDelegation))
+	mux.HandleFunc("PUT /api/user/delegation/{tag}", authMiddleware(handleSetDelegation))
+	mux.HandleFunc("DELETE /api/user/delegation/{$}", authMiddleware(handleSetDelegation))
+	mux.HandleFunc("DELETE /api/user/delegation/{tag}", authMiddleware(handleSetDelegation))
+	mux.HandleFunc("GET /api/user/events", authMiddleware(handleGetMemberEvents))
+	mux.HandleFunc("GET /api/user/calendar", authMiddleware(handleGetCalendarFeed))
+	mux.HandleFunc("GET /api/user/{ulid}/events.ics", handleGetMemberFeed)
+	mux.HandleFunc("GET /api/user/export", authMiddleware(handleExportAccount))
+	mux.HandleFunc("POST /api/user/delete/", authMiddleware(handleRequestAccountDeletion))
+	mux.HandleFunc("POST /api/user/delete/confirm/", authMiddleware(handleConfirmAccountDeletion))
+	mux.HandleFunc("POST /api/user/email/", authMiddleware(handleRequestEmailChange))
+	mux.HandleFunc("POST /api/user/email/confirm/", authMiddleware(handleConfirmEmailChange))
+	mux.HandleFunc("POST /api/user/totp/enroll/", modIdentityMiddleware(handleTotpEnroll))
+	mux.HandleFunc("POST /api/user/totp/confirm/", modIdentityMiddleware(handleTotpConfirm))
+	mux.HandleFunc("POST /api/user/totp/verify/", modIdentityMiddleware(handleTotpVerify))
+	mux.HandleFunc("POST /api/user/totp/disable/", modIdentityMiddleware(handleTotpDisable))
+	mux.HandleFunc("POST /api/admin/totp/enroll/", adminIdentityMiddleware(handleTotpEnroll))
+	mux.HandleFunc("POST /api/admin/totp/confirm/", adminIdentityMiddleware(handleTotpConfirm))
+	mux.HandleFunc("POST /api/admin/totp/verify/", adminIdentityMiddleware(handleTotpVerify))
+	mux.HandleFunc("POST /api/admin/totp/disable/", adminIdentityMiddleware(handleTotpDisable))
+	mux.HandleFunc("POST /api/admin/moderator/{ulid}", adminMiddleware(handleSetModerator))
+	mux.HandleFunc("DELETE /api/admin/moderator/{ulid}", adminMiddleware(handleSetModerator))
+	mux.HandleFunc("POST /api/admin/verified/{ulid}", adminMiddleware(handleSetVerified))
+	mux.HandleFunc("DELETE /api/admin/verified/{ulid}", adminMiddleware(handleSetVerified))
 	mux.HandleFunc("GET /api/admin/bypass-email/{ulid}", adminMiddleware(handleGetUserAuthGrp))
+	mux.HandleFunc("GET /api/admin/bypass-email/{ulid}/deletion", adminMiddleware(handleGetAccountDeletion))
+	mux.HandleFunc("GET /api/admin/bypass-email/{ulid}/email-change", adminMiddleware(handleGetEmailChange))
+	mux.HandleFunc("GET /api/admin/user/{ulid}/sanctions", adminMiddleware(handleGetSanctions))
+	mux.HandleFunc("POST /api/admin/user/{ulid}/sanction/{$}", adminMiddleware(handleCreateSanction))
+	mux.HandleFunc("POST /api/admin/user/{ulid}/sanction/{sanctionId}/lift/", adminMiddleware(handleLiftSanction))
+	mux.HandleFunc("POST /api/admin/exim/purge/{ulid}", adminMiddleware(handlePurgeExim))
+	mux.HandleFunc("POST /api/admin/tag/{$}", adminMiddleware(handleCreateTag))
+	mux.HandleFunc("PUT /api/admin/tag/{slug}", adminMiddleware(handleUpdateTag))
+	mux.HandleFunc("DELETE /api/admin/tag/{slug}", adminMiddleware(handleDeleteTag))
+	mux.HandleFunc("POST /api/admin/chapter/{$}", adminMiddleware(handleCreateChapter))
+	mux.HandleFunc("PUT /api/admin/chapter/{slug}", adminMiddleware(handleUpdateChapter))
+	mux.HandleFunc("DELETE /api/admin/chapter/{slug}", adminMiddleware(handleDeleteChapter))
+	mux.HandleFunc("POST /api/admin/approval-rule/{$}", adminMiddleware(handleCreateApprovalRule))
+	mux.HandleFunc("DELETE /api/admin/approval-rule/{ulid}", adminMiddleware(handleDeleteApprovalRule))
+	mux.HandleFunc("POST /api/admin/approval-rule/evaluate/", adminMiddleware(handleEvaluateApprovalRules))
+	mux.HandleFunc("POST /api/admin/poll/{$}", adminMiddleware(handleCreatePoll))
+	mux.HandleFunc("DELETE /api/admin/poll/{ulid}", adminMiddleware(handleDeletePoll))
 	mux.HandleFunc("POST /api/admin/log-bucket-custom-key/{bucket}", adminMiddleware(handleLogBucketUlidValue))
 	mux.HandleFunc("POST /api/admin/log-bucket/{bucket}", adminMiddleware(handleLogBucketUlidKey))
 	mux.HandleFunc("POST /api/admin/shutdown/", adminMiddleware(func(w http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Replaces the userId of a deleted user wherever it appears, e.g. as the
+// Author of their exims.
+const deletedUserId = "[deleted]"
+
+// Account deletion and email changes are confirmed by a code sent by email.
+// How long a code may be used for, and how many wrong attempts are allowed
+// before a new code must be requested.
+const confirmationCodeWindow = time.Hour
+const maxConfirmationCodeAttempts = 3
+
+// Buckets keyed by userId, whose entries are removed when a user is deleted.
+var userKeyedBuckets = []string{"USER_AUTH", "USER_VERIFIED", "USER_LEGAL_NAME", "USER_ADDR", "USER_MODERATOR", "TOTP", "BYPASS", "USER_DELETION", "USER_EMAIL_CHANGE", "USER_PROFILE", "USER_CHAPTER"}
+
+// Buckets whose JSON values may refer to a user by userId (as authors,
+// editors, reporters and so on). The userId is anonymized in such values when
+// the user is deleted.
+var userContentBuckets = []string{"MOD_EXIM", "MOD_EXIM_REV", "MOD_EXIM_STATE", "MOD_EXIM_OUTCOME", "MOD_EXIM_COMMENT", "REPORT", "USER_SANCTION", "MOD_AUDIT", "EVENT"}
+
+// Those of userContentBuckets whose values are exported as they are. Reports
+// and the audit trail may hold what others said about the user, so only the
+// user's own reports and their sanctions are exported, see AccountExport.
+var userExportBuckets = []string{"MOD_EXIM", "MOD_EXIM_REV", "MOD_EXIM_STATE", "MOD_EXIM_OUTCOME", "MOD_EXIM_COMMENT", "EVENT"}
+
+var errAccountDeletionNotRequested = errors.New("account deletion has not been requested, or has expired")
+var errAccountDeletionCode = errors.New("account deletion code is incorrect")
+var errAccountDeletionAttempts = errors.New("account deletion attempts exceeded, please request a new code")
+var errEmailInUse = errors.New("email is already in use")
+var errEmailChangeNotRequested = errors.New("email change has not been requested, or has expired")
+var errEmailChangeCode = errors.New("email change code is incorrect")
+var errEmailChangeAttempts = errors.New("email change attempts exceeded, please request a new code")
+
+// A pending request to delete an account, confirmed by entering Code (which
+// is sent to the user's email).
+type AccountDeletion struct {
+	Code        int       `json:"code"`
+	Attempts    int       `json:"attempts"`
+	RequestedTs time.Time `json:"requestedTs"`
+	ExpiresTs   time.Time `json:"expiresTs"`
+}
+
+// A pending change of a user's email to NewEmail, confirmed by entering Code
+// (which is sent to NewEmail).
+type EmailChange struct {
+	NewEmail    string    `json:"newEmail"`
+	Code        int       `json:"code"`
+	Attempts    int       `json:"attempts"`
+	RequestedTs time.Time `json:"requestedTs"`
+	ExpiresTs   time.Time `json:"expiresTs"`
+}
+
+// Everything stored about a user. Secrets (login codes and TOTP secrets) are
+// left out.
+type AccountExport struct {
+	UserId     string    `json:"userId"`
+	Email      string    `json:"email"`
+	ExportedTs time.Time `json:"exportedTs"`
+	Auth       struct {
+		LoginAttempts int       `json:"loginAttempts"`
+		LogoutTs      time.Time `json:"logoutTs"`
+	} `json:"auth"`
+	ModeratorSinceTs string   `json:"moderatorSinceTs,omitempty"`
+	IsTotpEnrolled   bool     `json:"isTotpEnrolled"`
+	Address          *Address `json:"address,omitempty"`
+	Chapter          string   `json:"chapter,omitempty"`
+	Verified         string   `json:"verified,omitempty"`
+	LegalName        string   `json:"legalName,omitempty"`
+	// PendingEmail is set while a change of email awaits confirmation.
+	PendingEmail string   `json:"pendingEmail,omitempty"`
+	Profile      *Profile `json:"profile,omitempty"`
+	// Support lists the exims the user supports, and Abstentions those they
+	// abstained on.
+	Support     []AccountSupport    `json:"support"`
+	Abstentions []AccountAbstention `json:"abstentions"`
+	// Delegations lists whom the user delegates their support to.
+	Delegations Delegations `json:"delegations"`
+	// Polls lists the polls the user voted in. Ballots are secret, so how
+	// they voted is not stored with their identity, and cannot be exported.
+	Polls []AccountPollVote `json:"polls"`
+	// Signatures lists the user's petition signatures.
+	Signatures []AccountSignature `json:"signatures"`
+	// RSVPs lists the events the user RSVP'd to.
+	RSVPs []AccountRSVP `json:"rsvps"`
+	// Reports lists the reports the user made, and Sanctions those applied
+	// to them, without moderators' identities or notes.
+	Reports   []AccountReport   `json:"reports"`
+	Sanctions []AccountSanction `json:"sanctions"`
+	// Records holds every other record which refers to the user, by bucket.
+	Records map[string][]json.RawMessage `json:"records"`
+}
+
+type AccountSupport struct {
+	EximId      string `json:"eximId"`
+	SupportedTs string `json:"supportedTs"`
+}
+
+type AccountAbstention struct {
+	EximId      string `json:"eximId"`
+	AbstainedTs string `json:"abstainedTs"`
+}
+
+type AccountPollVote struct {
+	PollId  string `json:"pollId"`
+	VotedTs string `json:"votedTs"`
+}
+
+type AccountSignature struct {
+	EximId    string          `json:"eximId"`
+	Signature json.RawMessage `json:"signature"`
+}
+
+type AccountRSVP struct {
+	EventId string `json:"eventId"`
+	RSVPTs  string `json:"rsvpTs"`
+}
+
+type AccountReport struct {
+	ReportId  string    `json:"reportId"`
+	Kind      string    `json:"kind"`
+	EximId    string    `json:"eximId"`
+	CommentId string    `json:"commentId,omitempty"`
+	Reason    string    `json:"reason"`
+	Details   string    `json:"details"`
+	Status    string    `json:"status"`
+	CreatedTs time.Time `json:"createdTs"`
+}
+
+// The reasons of a sanction and of its lift are those emailed to the user.
+type AccountSanction struct {
+	SanctionId string     `json:"sanctionId"`
+	Kind       string     `json:"kind"`
+	Reason     string     `json:"reason"`
+	CreatedTs  time.Time  `json:"createdTs"`
+	ExpiresTs  *time.Time `json:"expiresTs,omitempty"`
+	LiftReason string     `json:"liftReason,omitempty"`
+	LiftedTs   *time.Time `json:"liftedTs,omitempty"`
+}
+
+// Reads everything stored about the user into the receiver.
+func (ae *AccountExport) exportAccountTx(userBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		user := User{}
+		if err := user.UserId.UnmarshalBinary(userBinId); err != nil {
+			return err
+		}
+		ae.UserId = user.UserId.String()
+		ae.ExportedTs = time.Now()
+
+		// Email is keyed by address.
+		email, err := getUserEmail(tx, userBinId)
+		if err != nil {
+			return err
+		}
+		ae.Email = email
+
+		// Entries keyed by userId.
+		if agJs := tx.Bucket([]byte("USER_AUTH")).Get(userBinId); agJs != nil {
+			if err := json.Unmarshal(agJs, &user.AuthGrp); err != nil {
+				return err
+			}
+		}
+		ae.Auth.LoginAttempts = user.AuthGrp.LoginAttempts
+		ae.Auth.LogoutTs = user.AuthGrp.LogoutTs
+		ae.ModeratorSinceTs = string(tx.Bucket([]byte("USER_MODERATOR")).Get(userBinId))
+		if tgJs := tx.Bucket([]byte("TOTP")).Get(userBinId); tgJs != nil {
+			var totpGrp TotpGrp
+			if err := json.Unmarshal(tgJs, &totpGrp); err != nil {
+				return err
+			}
+			ae.IsTotpEnrolled = totpGrp.IsEnrolled
+		}
+		location, err := getMemberLocation(tx, userBinId)
+		if err != nil {
+			return err
+		}
+		ae.Address = location.Address
+		if location.Chapter != nil {
+			ae.Chapter = location.Chapter.Slug
+		}
+		ae.Verified = string(tx.Bucket([]byte("USER_VERIFIED")).Get(userBinId))
+		ae.LegalName = string(tx.Bucket([]byte("USER_LEGAL_NAME")).Get(userBinId))
+		if ecJs := tx.Bucket([]byte("USER_EMAIL_CHANGE")).Get(userBinId); ecJs != nil {
+			var change EmailChange
+			if err := json.Unmarshal(ecJs, &change); err != nil {
+				return err
+			}
+			ae.PendingEmail = change.NewEmail
+		}
+		if profileJs := tx.Bucket([]byte("USER_PROFILE")).Get(userBinId); profileJs != nil {
+			profile, err := getProfile(tx, userBinId)
+			if err != nil {
+				return err
+			}
+			ae.Profile = &profile
+		}
+
+		// Support and abstentions are keyed by eximId + userId, and delegations
+		// by userId + tag.
+		ae.Support = []AccountSupport{}
+		err = forEachUserVote(tx, "MOD_EXIM_SUPPORT", userBinId, func(eximId string, ts string) {
+			ae.Support = append(ae.Support, AccountSupport{EximId: eximId, SupportedTs: ts})
+		})
+		if err != nil {
+			return err
+		}
+		ae.Abstentions = []AccountAbstention{}
+		err = forEachUserVote(tx, "MOD_EXIM_ABSTAIN", userBinId, func(eximId string, ts string) {
+			ae.Abstentions = append(ae.Abstentions, AccountAbstention{EximId: eximId, AbstainedTs: ts})
+		})
+		if err != nil {
+			return err
+		}
+		ae.Delegations = Delegations{}
+		c := tx.Bucket([]byte("USER_DELEGATION")).Cursor()
+		for k, v := c.Seek(userBinId); k != nil && bytes.HasPrefix(k, userBinId); k, v = c.Next() {
+			var d Delegation
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			ae.Delegations = append(ae.Delegations, d)
+		}
+		ae.Polls = []AccountPollVote{}
+		err = forEachUserVote(tx, "POLL_VOTER", userBinId, func(pollId string, ts string) {
+			ae.Polls = append(ae.Polls, AccountPollVote{PollId: pollId, VotedTs: ts})
+		})
+		if err != nil {
+			return err
+		}
+		ae.Signatures = []AccountSignature{}
+		err = forEachUserVote(tx, "PETITION_SIGNATURE", userBinId, func(eximId string, signatureJs string) {
+			ae.Signatures = append(ae.Signatures, AccountSignature{EximId: eximId, Signature: json.RawMessage(signatureJs)})
+		})
+		if err != nil {
+			return err
+		}
+		ae.RSVPs = []AccountRSVP{}
+		err = forEachUserVote(tx, "EVENT_RSVP", userBinId, func(eventId string, ts string) {
+			ae.RSVPs = append(ae.RSVPs, AccountRSVP{EventId: eventId, RSVPTs: ts})
+		})
+		if err != nil {
+			return err
+		}
+
+		ae.Reports = []AccountReport{}
+		err = tx.Bucket([]byte("REPORT")).ForEach(func(k, v []byte) error {
+			var r Report
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if r.ReporterId == ae.UserId {
+				ae.Reports = append(ae.Reports, AccountReport{
+					ReportId:  r.ReportId.String(),
+					Kind:      r.Kind,
+					EximId:    r.EximId,
+					CommentId: r.CommentId,
+					Reason:    r.Reason,
+					Details:   r.Details,
+					Status:    r.Status,
+					CreatedTs: r.CreatedTs,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Sanctions are keyed by userId + sanctionId.
+		ae.Sanctions = []AccountSanction{}
+		c = tx.Bucket([]byte("USER_SANCTION")).Cursor()
+		for k, v := c.Seek(userBinId); k != nil && bytes.HasPrefix(k, userBinId); k, v = c.Next() {
+			var s Sanction
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			sanction := AccountSanction{
+				SanctionId: s.SanctionId.String(),
+				Kind:       s.Kind,
+				Reason:     s.Reason,
+				CreatedTs:  s.CreatedTs,
+				ExpiresTs:  s.ExpiresTs,
+			}
+			if s.Lift != nil {
+				sanction.LiftReason = s.Lift.Reason
+				sanction.LiftedTs = &s.Lift.LiftedTs
+			}
+			ae.Sanctions = append(ae.Sanctions, sanction)
+		}
+
+		// Every other record which refers to the user.
+		ae.Records = map[string][]json.RawMessage{}
+		quotedId := []byte(`"` + ae.UserId + `"`)
+		for _, name := range userExportBuckets {
+			records := []json.RawMessage{}
+			err := tx.Bucket([]byte(name)).ForEach(func(k, v []byte) error {
+				if bytes.Contains(v, quotedId) {
+					records = append(records, json.RawMessage(bytes.Clone(v)))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ae.Records[name] = records
+		}
+		return nil
+	})
+}
+
+// Calls fn with the eximId and value of each of the user's votes in a bucket
+// keyed by parentId + userId (e.g. eximId + userId in MOD_EXIM_SUPPORT), within
+// an existing db transaction.
+func forEachUserVote(tx *bolt.Tx, bucket string, userBinId []byte, fn func(parentId string, value string)) error {
+	return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+		if !bytes.HasSuffix(k, userBinId) {
+			return nil
+		}
+		var parentId ulid.ULID
+		if err := parentId.UnmarshalBinary(k[:len(k)-len(userBinId)]); err != nil {
+			return err
+		}
+		fn(parentId.String(), string(v))
+		return nil
+	})
+}
+
+// Writes a new deletion request for the user, replacing any previous one. On
+// success, the receiver is set to the request.
+func (ad *AccountDeletion) requestAccountDeletionTx(userBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("USER_DELETION"))
+
+		ad.Code = generateLoginCode()
+		ad.Attempts = 0
+		ad.RequestedTs = time.Now()
+		ad.ExpiresTs = ad.RequestedTs.Add(confirmationCodeWindow)
+
+		// Marshal AccountDeletion to be stored.
+		adJs, err := json.Marshal(ad)
+		if err != nil {
+			return err
+		}
+		return b.Put(userBinId, adJs)
+	})
+}
+
+// Reads the user's pending deletion request. Used to bypass email outside of
+// production environment.
+func (ad *AccountDeletion) getAccountDeletionTx(userBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		adJs := tx.Bucket([]byte("USER_DELETION")).Get(userBinId)
+		if adJs == nil {
+			return errAccountDeletionNotRequested
+		}
+		return json.Unmarshal(adJs, ad)
+	})
+}
+
+// Checks the code against the user's pending deletion request and, if it
+// matches, deletes the user: entries keyed by userId (including their email,
+// sanctions and support) are removed, and every other reference to their
+// userId is replaced with deletedUserId. A wrong code counts as an attempt.
+func (ad *AccountDeletion) confirmAccountDeletionTx(userBinId []byte, code int) error {
+	var wrongCode bool
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("USER_DELETION"))
+
+		// Retrieve pending request.
+		adJs := b.Get(userBinId)
+		if adJs == nil {
+			return errAccountDeletionNotRequested
+		}
+		if err := json.Unmarshal(adJs, ad); err != nil {
+			return err
+		}
+		if time.Now().After(ad.ExpiresTs) {
+			return errAccountDeletionNotRequested
+		}
+		if ad.Attempts >= maxConfirmationCodeAttempts {
+			return errAccountDeletionAttempts
+		}
+
+		// Record a wrong attempt; the transaction must commit for it to count.
+		if ad.Code != code {
+			ad.Attempts++
+			adJs, err := json.Marshal(ad)
+			if err != nil {
+				return err
+			}
+			wrongCode = true
+			return b.Put(userBinId, adJs)
+		}
+
+		return deleteUser(tx, userBinId)
+	})
+	if err == nil && wrongCode {
+		return errAccountDeletionCode
+	}
+	return err
+}
+
+// Deletes a user within an existing db transaction, see
+// confirmAccountDeletionTx.
+func deleteUser(tx *bolt.Tx, userBinId []byte) error {
+	var user User
+	if err := user.UserId.UnmarshalBinary(userBinId); err != nil {
+		return err
+	}
+
+	// Remove email, which is keyed by address.
+	email, err := getUserEmail(tx, userBinId)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket([]byte("USER_EMAIL")).Delete([]byte(email)); err != nil {
+		return err
+	}
+
+	// Remove chapter membership, which is also keyed by chapter.
+	if err := assignChapter(tx, userBinId, ""); err != nil {
+		return err
+	}
+
+	// Remove entries keyed by userId.
+	for _, name := range userKeyedBuckets {
+		if err := tx.Bucket([]byte(name)).Delete(userBinId); err != nil {
+			return err
+		}
+	}
+
+	// Remove entries keyed by userId + childId (sanctions) and by
+	// parentId + userId (support, abstentions, petition signatures, event
+	// RSVPs and their reminders, and records of voting in polls, whose ballots
+	// are kept since they cannot be linked to the user).
+	// Collect keys first, since deleting while iterating is not permitted.
+	var sanctionKeys [][]byte
+	sb := tx.Bucket([]byte("USER_SANCTION"))
+	c := sb.Cursor()
+	for k, _ := c.Seek(userBinId); k != nil && bytes.HasPrefix(k, userBinId); k, _ = c.Next() {
+		sanctionKeys = append(sanctionKeys, bytes.Clone(k))
+	}
+	for _, k := range sanctionKeys {
+		if err := sb.Delete(k); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{"MOD_EXIM_SUPPORT", "MOD_EXIM_ABSTAIN", "PETITION_SIGNATURE", "POLL_VOTER", "EVENT_RSVP", "EVENT_REMINDED"} {
+		var voteKeys [][]byte
+		vb := tx.Bucket([]byte(name))
+		err = vb.ForEach(func(k, v []byte) error {
+			if bytes.HasSuffix(k, userBinId) {
+				voteKeys = append(voteKeys, bytes.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range voteKeys {
+			if err := vb.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove delegations, both by and to the user.
+	if err := removeUserDelegations(tx, userBinId); err != nil {
+		return err
+	}
+	// Recount support, which may have come from the user.
+	if err := reindexSupport(tx); err != nil {
+		return err
+	}
+
+	// Anonymize every other reference to the user.
+	quotedId := []byte(`"` + user.UserId.String() + `"`)
+	quotedDeletedId := []byte(`"` + deletedUserId + `"`)
+	for _, name := range userContentBuckets {
+		b := tx.Bucket([]byte(name))
+		updates := map[string][]byte{}
+		err := b.ForEach(func(k, v []byte) error {
+			if bytes.Contains(v, quotedId) {
+				updates[string(k)] = bytes.ReplaceAll(v, quotedId, quotedDeletedId)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range updates {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Writes a new request to change the user's email to the receiver's NewEmail,
+// replacing any previous one. The new email must not be in use. On success,
+// the receiver is set to the request.
+func (ec *EmailChange) requestEmailChangeTx(userBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte("USER_EMAIL"))
+		b := tx.Bucket([]byte("USER_EMAIL_CHANGE"))
+
+		if eb.Get([]byte(ec.NewEmail)) != nil {
+			return errEmailInUse
+		}
+
+		ec.Code = generateLoginCode()
+		ec.Attempts = 0
+		ec.RequestedTs = time.Now()
+		ec.ExpiresTs = ec.RequestedTs.Add(confirmationCodeWindow)
+
+		// Marshal EmailChange to be stored.
+		ecJs, err := json.Marshal(ec)
+		if err != nil {
+			return err
+		}
+		return b.Put(userBinId, ecJs)
+	})
+}
+
+// Reads the user's pending email change. Used to bypass email outside of
+// production environment.
+func (ec *EmailChange) getEmailChangeTx(userBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		ecJs := tx.Bucket([]byte("USER_EMAIL_CHANGE")).Get(userBinId)
+		if ecJs == nil {
+			return errEmailChangeNotRequested
+		}
+		return json.Unmarshal(ecJs, ec)
+	})
+}
+
+// Checks the code against the user's pending email change and, if it matches,
+// moves the user's USER_EMAIL key from their old email to the new one. A wrong
+// code counts as an attempt. On success, the receiver is set to the request
+// and oldEmail to the email it replaced.
+func (ec *EmailChange) confirmEmailChangeTx(userBinId []byte, code int, oldEmail *string) error {
+	var wrongCode bool
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte("USER_EMAIL"))
+		b := tx.Bucket([]byte("USER_EMAIL_CHANGE"))
+
+		// Retrieve pending request.
+		ecJs := b.Get(userBinId)
+		if ecJs == nil {
+			return errEmailChangeNotRequested
+		}
+		if err := json.Unmarshal(ecJs, ec); err != nil {
+			return err
+		}
+		if time.Now().After(ec.ExpiresTs) {
+			return errEmailChangeNotRequested
+		}
+		if ec.Attempts >= maxConfirmationCodeAttempts {
+			return errEmailChangeAttempts
+		}
+
+		// Record a wrong attempt; the transaction must commit for it to count.
+		if ec.Code != code {
+			ec.Attempts++
+			ecJs, err := json.Marshal(ec)
+			if err != nil {
+				return err
+			}
+			wrongCode = true
+			return b.Put(userBinId, ecJs)
+		}
+
+		// The new email may have been taken since the change was requested.
+		if eb.Get([]byte(ec.NewEmail)) != nil {
+			return errEmailInUse
+		}
+		email, err := getUserEmail(tx, userBinId)
+		if err != nil {
+			return err
+		}
+		if err := eb.Delete([]byte(email)); err != nil {
+			return err
+		}
+		if err := eb.Put([]byte(ec.NewEmail), userBinId); err != nil {
+			return err
+		}
+		*oldEmail = email
+		return b.Delete(userBinId)
+	})
+	if err == nil && wrongCode {
+		return errEmailChangeCode
+	}
+	return err
+}
+
+// Reads a user's email within an existing db transaction. Emails are keyed by
+// address, so every entry is scanned.
+func getUserEmail(tx *bolt.Tx, userBinId []byte) (string, error) {
+	c := tx.Bucket([]byte("USER_EMAIL")).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if bytes.Equal(v, userBinId) {
+			return string(k), nil
+		}
+	}
+	return "", errUserNotFound
+}
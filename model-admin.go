@@ -1,12 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/oklog/ulid"
 	bolt "go.etcd.io/bbolt"
 )
 
+const maxLegalNameChars = 100
+
+var errNoLegalName = errors.New("no legal name was recorded when the member was verified")
+
 type Admin struct {
 	AdminId ulid.ULID
 }
@@ -28,3 +34,78 @@ func (a *Admin) adminMiddlewareTx() error {
 		return nil
 	})
 }
+
+// Grants (or revokes) the moderator role by writing (or deleting) the user's
+// key in the USER_MODERATOR bucket. Value is the time the role was granted.
+func (a *Admin) setModeratorTx(userBinId []byte, isModerator bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		ab := tx.Bucket([]byte("USER_AUTH"))
+		mb := tx.Bucket([]byte("USER_MODERATOR"))
+
+		if !isModerator {
+			return mb.Delete(userBinId)
+		}
+
+		// Only existing users may become moderators.
+		if ab.Get(userBinId) == nil {
+			return fmt.Errorf("user does not exist for specified userId")
+		}
+		return mb.Put(userBinId, []byte(time.Now().Format(time.RFC3339)))
+	})
+}
+
+// Checks a legal name, returning a map of field name to error message if it is
+// invalid. The name should be normalized first.
+func validateLegalName(legalName string) map[string]string {
+	fieldErrors := map[string]string{}
+	if isBlank(legalName) {
+		fieldErrors["legalName"] = "This field cannot be blank."
+	} else if !maxChars(legalName, maxLegalNameChars) {
+		fieldErrors["legalName"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxLegalNameChars)
+	}
+	return fieldErrors
+}
+
+// Marks the user as verified, e.g. once their identity has been confirmed as a
+// party member under their legal name, by writing their key in the
+// USER_VERIFIED bucket (value is the time they were verified) and their legal
+// name in the USER_LEGAL_NAME bucket. Pass an empty legal name to unmark them,
+// deleting both.
+func (a *Admin) setVerifiedTx(userBinId []byte, legalName string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		ab := tx.Bucket([]byte("USER_AUTH"))
+		vb := tx.Bucket([]byte("USER_VERIFIED"))
+		nb := tx.Bucket([]byte("USER_LEGAL_NAME"))
+
+		if legalName == "" {
+			if err := nb.Delete(userBinId); err != nil {
+				return err
+			}
+			return vb.Delete(userBinId)
+		}
+
+		// Only existing users may be verified.
+		if ab.Get(userBinId) == nil {
+			return errUserNotFound
+		}
+		if err := nb.Put(userBinId, []byte(legalName)); err != nil {
+			return err
+		}
+		return vb.Put(userBinId, []byte(time.Now().Format(time.RFC3339)))
+	})
+}
+
+// Reports whether the user is verified, within an existing db transaction.
+func isVerified(tx *bolt.Tx, userBinId []byte) bool {
+	return tx.Bucket([]byte("USER_VERIFIED")).Get(userBinId) != nil
+}
+
+// Returns the legal name the user was verified under, within an existing db
+// transaction. Members verified before legal names were recorded have none.
+func getLegalName(tx *bolt.Tx, userBinId []byte) (string, error) {
+	legalName := tx.Bucket([]byte("USER_LEGAL_NAME")).Get(userBinId)
+	if legalName == nil {
+		return "", errNoLegalName
+	}
+	return string(legalName), nil
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// How often submitted exims are checked against the approval rules.
+const approvalEvaluationInterval = time.Minute
+
+const maxApprovalRuleNameChars = 100
+const maxApprovalRuleWindowDays = 365
+
+var errApprovalRuleNotFound = errors.New("approval rule does not exist")
+
+// A rule under which submitted exims are approved automatically, without a
+// moderator: once an exim's support (including delegated support, see
+// getEximSupport) reaches the rule's threshold. The threshold is either
+// MinSupport members, or MinPercent of verified members (at least one). Only
+// the support of verified members counts towards a percentage, so that
+// unverified accounts can't inflate it.
+//
+// If WindowDays is set, the threshold must be reached within that many days
+// of the exim's (latest) submission. If Tag or Chapter is set, the rule only
+// applies to exims tagged with, or scoped to, it; a rule whose tag or chapter
+// is deleted no longer applies to any exim.
+type ApprovalRule struct {
+	RuleId     ulid.ULID `json:"ruleId"`
+	Name       string    `json:"name"`
+	MinSupport int       `json:"minSupport,omitempty"`
+	MinPercent float64   `json:"minPercent,omitempty"`
+	WindowDays int       `json:"windowDays,omitempty"`
+	Tag        string    `json:"tag,omitempty"`
+	Chapter    string    `json:"chapter,omitempty"`
+	CreatedTs  time.Time `json:"createdTs"`
+}
+
+type ApprovalRules []ApprovalRule
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (r *ApprovalRule) normalize() {
+	r.Name = normalizeText(r.Name, false)
+	r.Tag = strings.ToLower(normalizeText(r.Tag, false))
+	r.Chapter = strings.ToLower(normalizeText(r.Chapter, false))
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (r *ApprovalRule) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	if isBlank(r.Name) {
+		fieldErrors["name"] = "This field cannot be blank."
+	} else if !maxChars(r.Name, maxApprovalRuleNameChars) {
+		fieldErrors["name"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxApprovalRuleNameChars)
+	}
+	switch {
+	case (r.MinSupport == 0) == (r.MinPercent == 0):
+		fieldErrors["minSupport"] = "Exactly one of minSupport and minPercent must be set."
+	case r.MinSupport < 0:
+		fieldErrors["minSupport"] = "This field cannot be negative."
+	case r.MinPercent < 0 || r.MinPercent > 100:
+		fieldErrors["minPercent"] = "This field must be between 0 and 100."
+	}
+	if r.WindowDays < 0 || r.WindowDays > maxApprovalRuleWindowDays {
+		fieldErrors["windowDays"] = fmt.Sprintf("This field must be between 0 and %d.", maxApprovalRuleWindowDays)
+	}
+	if r.Tag != "" && !isTagSlug(r.Tag) {
+		fieldErrors["tag"] = "This field must be the slug of an existing tag."
+	}
+	if r.Chapter != "" && !isTagSlug(r.Chapter) {
+		fieldErrors["chapter"] = "This field must be the slug of an existing chapter."
+	}
+
+	return fieldErrors
+}
+
+// Returns the support an exim needs under the rule, given the number of
+// verified members.
+func (r *ApprovalRule) threshold(verifiedCount int) int {
+	if r.MinSupport > 0 {
+		return r.MinSupport
+	}
+	return max(int(math.Ceil(r.MinPercent*float64(verifiedCount)/100)), 1)
+}
+
+// Reports whether the rule applies to an exim submitted at submittedTs.
+func (r *ApprovalRule) applies(e *Exim, submittedTs time.Time, now time.Time) bool {
+	if r.Tag != "" && !slices.Contains(e.Tags, r.Tag) {
+		return false
+	}
+	if r.Chapter != "" && e.Chapter != r.Chapter {
+		return false
+	}
+	return r.WindowDays == 0 || now.Before(submittedTs.AddDate(0, 0, r.WindowDays))
+}
+
+// Writes a new approval rule to db, checking that its tag and chapter exist.
+func (r *ApprovalRule) createApprovalRuleTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if r.Tag != "" && tx.Bucket([]byte("TAG")).Get([]byte(r.Tag)) == nil {
+			return errTagNotFound
+		}
+		if r.Chapter != "" {
+			if _, err := getChapter(tx, r.Chapter); err != nil {
+				return err
+			}
+		}
+
+		id, binId := createUlid()
+		r.RuleId = id
+		r.CreatedTs = time.Now()
+
+		ruleJs, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("APPROVAL_RULE")).Put(binId, ruleJs)
+	})
+}
+
+// Reads every approval rule within an existing db transaction, oldest first.
+func getApprovalRules(tx *bolt.Tx) (ApprovalRules, error) {
+	var rules ApprovalRules
+	err := tx.Bucket([]byte("APPROVAL_RULE")).ForEach(func(k, v []byte) error {
+		var rule ApprovalRule
+		if err := json.Unmarshal(v, &rule); err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+		return nil
+	})
+	return rules, err
+}
+
+func (rs *ApprovalRules) getApprovalRulesTx() error {
+	return db.View(func(tx *bolt.Tx) error {
+		rules, err := getApprovalRules(tx)
+		*rs = rules
+		return err
+	})
+}
+
+func (r *ApprovalRule) deleteApprovalRuleTx(ruleBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("APPROVAL_RULE"))
+		if b.Get(ruleBinId) == nil {
+			return errApprovalRuleNotFound
+		}
+		return b.Delete(ruleBinId)
+	})
+}
+
+// Returns when an exim was last submitted, from its transition log, within an
+// existing db transaction.
+func getEximSubmittedTs(tx *bolt.Tx, eximBinId []byte) (time.Time, error) {
+	var submittedTs time.Time
+	c := tx.Bucket([]byte("MOD_EXIM_STATE")).Cursor()
+	for k, v := c.Seek(eximBinId); k != nil && bytes.HasPrefix(k, eximBinId); k, v = c.Next() {
+		var transition EximTransition
+		if err := json.Unmarshal(v, &transition); err != nil {
+			return submittedTs, err
+		}
+		if transition.To == eximStateSubmitted {
+			submittedTs = transition.TransitionTs
+		}
+	}
+	return submittedTs, nil
+}
+
+// Counts an exim's support from verified members only, within an existing db
+// transaction, see getEximSupporters.
+func getEximVerifiedSupport(tx *bolt.Tx, eximBinId []byte, dm *delegationMap) (int, error) {
+	userIds, err := getEximSupporters(tx, eximBinId, dm)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, userId := range userIds {
+		_, userBinId, err := parseUlidString(userId)
+		if err != nil {
+			return 0, err
+		}
+		if isVerified(tx, userBinId) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Finds the submitted exims which meet an approval rule within an existing db
+// transaction, trying rules oldest first. Returns the transition to approve
+// each with, keyed by eximId. Only exims with at least the lowest threshold of
+// support are read, from the support index (see supportRankKey).
+func findApprovals(tx *bolt.Tx, now time.Time) (map[string]EximTransition, error) {
+	eb := tx.Bucket([]byte("MOD_EXIM"))
+
+	rules, err := getApprovalRules(tx)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	verifiedCount := 0
+	c := tx.Bucket([]byte("USER_VERIFIED")).Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		verifiedCount++
+	}
+	minNeeded := math.MaxInt
+	for _, rule := range rules {
+		minNeeded = min(minNeeded, rule.threshold(verifiedCount))
+	}
+	dm, err := getDelegationMap(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	approvals := map[string]EximTransition{}
+	c = tx.Bucket([]byte("MOD_EXIM_SUPPORT_RANK")).Cursor()
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		// Verified support is at most the indexed support.
+		support := int(binary.BigEndian.Uint32(k[:supportRankBytes]))
+		if support < minNeeded {
+			break
+		}
+		eximBinId := k[supportRankBytes:]
+
+		var exim Exim
+		if err := json.Unmarshal(eb.Get(eximBinId), &exim); err != nil {
+			return nil, err
+		}
+		if exim.State != eximStateSubmitted || exim.Removal != nil {
+			continue
+		}
+		submittedTs, err := getEximSubmittedTs(tx, eximBinId)
+		if err != nil {
+			return nil, err
+		}
+		verifiedSupport := -1
+
+		for _, rule := range rules {
+			needed := rule.threshold(verifiedCount)
+			if !rule.applies(&exim, submittedTs, now) || support < needed {
+				continue
+			}
+			count, noun := support, "supporters"
+			if rule.MinPercent > 0 {
+				if verifiedSupport < 0 {
+					if verifiedSupport, err = getEximVerifiedSupport(tx, eximBinId, dm); err != nil {
+						return nil, err
+					}
+				}
+				count, noun = verifiedSupport, "verified supporters"
+			}
+			if count < needed {
+				continue
+			}
+			approvals[string(eximBinId)] = EximTransition{
+				From:   eximStateSubmitted,
+				To:     eximStateApproved,
+				RuleId: rule.RuleId.String(),
+				Note:   fmt.Sprintf("Approved automatically under rule %q: %d %s of %d needed.", rule.Name, count, noun, needed),
+			}
+			break
+		}
+	}
+	return approvals, nil
+}
+
+// Approves every submitted exim which meets an approval rule, and logs each
+// transition with the rule which triggered it. Exims are found in a read-only
+// transaction (see findApprovals), so that writers are only blocked while the
+// approvals themselves are written. Returns the number of exims approved.
+func evaluateApprovalRulesTx() (int, error) {
+	var approvals map[string]EximTransition
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		approvals, err = findApprovals(tx, time.Now())
+		return err
+	})
+	if err != nil || len(approvals) == 0 {
+		return 0, err
+	}
+
+	approved := 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+
+		approved = 0
+		for k, transition := range approvals {
+			// The exim may have changed since it was found.
+			eximBytes := eb.Get([]byte(k))
+			if eximBytes == nil {
+				continue
+			}
+			var exim Exim
+			if err := json.Unmarshal(eximBytes, &exim); err != nil {
+				return err
+			}
+			if exim.State != eximStateSubmitted || exim.Removal != nil {
+				continue
+			}
+
+			exim.State = eximStateApproved
+			eximJs, err := json.Marshal(exim)
+			if err != nil {
+				return err
+			}
+			if err := eb.Put([]byte(k), eximJs); err != nil {
+				return err
+			}
+			if err := putEximTransition(tx, []byte(k), transition); err != nil {
+				return err
+			}
+			approved++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return approved, nil
+}
+
+// Evaluates the approval rules every approvalEvaluationInterval, for as long
+// as the server runs. Errors are logged, and evaluation is retried on the next
+// tick.
+func runApprovalEvaluator() {
+	ticker := time.NewTicker(approvalEvaluationInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		approved, err := evaluateApprovalRulesTx()
+		if err != nil {
+			fmt.Printf("[err][api] evaluating approval rules: %v [%s]\n", err, cts())
+			continue
+		}
+		if approved > 0 {
+			fmt.Printf("[api] approved %d exims under approval rules [%s]\n", approved, cts())
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Audited moderation actions.
+const auditActionEximRemove = "exim.remove"
+const auditActionEximPurge = "exim.purge"
+const auditActionCommentDelete = "comment.delete"
+const auditActionReportResolve = "report.resolve"
+const auditActionUserSanction = "user.sanction"
+const auditActionUserLift = "user.lift"
+const auditActionPetitionExport = "petition.export"
+
+const defaultAuditLimit = 50
+const maxAuditLimit = 200
+
+// Records a moderation action: who took it, when, on what (Subject), and why.
+// Entries are never modified or deleted, except to anonymize deleted users
+// (see deleteUser).
+type AuditEntry struct {
+	AuditId ulid.ULID `json:"auditId"`
+	ActorId string    `json:"actorId"`
+	Action  string    `json:"action"`
+	// SubjectKind is "exim", "comment" or "user". For comments, SubjectId is
+	// the commentId, and EximId the exim it belongs to.
+	SubjectKind string    `json:"subjectKind"`
+	SubjectId   string    `json:"subjectId"`
+	EximId      string    `json:"eximId,omitempty"`
+	ReportId    string    `json:"reportId,omitempty"`
+	Detail      string    `json:"detail,omitempty"`
+	Note        string    `json:"note"`
+	CreatedTs   time.Time `json:"createdTs"`
+}
+
+type AuditEntries []AuditEntry
+
+// Appends an entry to the audit trail within an existing db transaction.
+func writeAudit(tx *bolt.Tx, entry AuditEntry) error {
+	ab := tx.Bucket([]byte("MOD_AUDIT"))
+
+	id, binId := createUlid()
+	entry.AuditId = id
+	entry.CreatedTs = time.Now()
+
+	entryJs, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return ab.Put(binId, entryJs)
+}
+
+// Reads up to limit audit entries, newest first. If before is set, only
+// entries older than it are read.
+func (a *AuditEntries) getAuditTx(limit int, before *ulid.ULID) error {
+	return db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("MOD_AUDIT")).Cursor()
+
+		k, v := c.Last()
+		if before != nil {
+			beforeBinId, err := before.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			// Seek finds the first key >= before, so step back from it.
+			if k, v = c.Seek(beforeBinId); k == nil {
+				k, v = c.Last()
+			}
+			if k != nil && bytes.Compare(k, beforeBinId) >= 0 {
+				k, v = c.Prev()
+			}
+		}
+
+		for ; k != nil && len(*a) < limit; k, v = c.Prev() {
+			var entry AuditEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			*a = append(*a, entry)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// A local chapter, which members are assigned to by their address (see
+// ChapterRule). The slug identifies the chapter in URLs and on exims, and
+// cannot be changed.
+type Chapter struct {
+	Slug        string       `json:"slug"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Rules       ChapterRules `json:"rules"`
+	CreatedTs   time.Time    `json:"createdTs"`
+	// MemberCount is computed from CHAPTER_MEMBER, and only set on read.
+	MemberCount int `json:"memberCount"`
+}
+
+type Chapters []Chapter
+
+// Describes an area a chapter covers: a country, optionally narrowed to a
+// region and/or to postal codes starting with PostalPrefix.
+type ChapterRule struct {
+	Country      string `json:"country"`
+	Region       string `json:"region"`
+	PostalPrefix string `json:"postalPrefix"`
+}
+
+type ChapterRules []ChapterRule
+
+// A member's postal address. Only Country, Region and PostalCode are used to
+// assign the member to a chapter.
+type Address struct {
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postalCode"`
+	// Country is an ISO 3166-1 alpha-2 code, e.g. "US".
+	Country string `json:"country"`
+}
+
+// A member's address, and the chapter it assigns them to (if any).
+type MemberLocation struct {
+	Address *Address `json:"address"`
+	Chapter *Chapter `json:"chapter"`
+}
+
+var errChapterNotFound = errors.New("chapter does not exist")
+var errChapterExists = errors.New("chapter already exists")
+
+var countryCodeRX = regexp.MustCompile(`^[A-Z]{2}$`)
+
+const maxChapterNameChars = 50
+const maxChapterDescriptionChars = 500
+const maxChapterRules = 20
+const maxChapterRegionChars = 50
+const maxChapterPostalPrefixChars = 10
+const maxAddressLineChars = 100
+const maxAddressPostalCodeChars = 20
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (ch *Chapter) normalize() {
+	ch.Slug = strings.ToLower(normalizeText(ch.Slug, false))
+	ch.Name = normalizeText(ch.Name, false)
+	ch.Description = normalizeText(ch.Description, true)
+	for i := range ch.Rules {
+		r := &ch.Rules[i]
+		r.Country = strings.ToUpper(normalizeText(r.Country, false))
+		r.Region = normalizeText(r.Region, false)
+		r.PostalPrefix = normalizePostalCode(r.PostalPrefix)
+	}
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (ch *Chapter) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	// Chapter slugs take the same form as tag slugs.
+	if !isTagSlug(ch.Slug) {
+		fieldErrors["slug"] = fmt.Sprintf("This field must be at most %d lowercase letters, digits and single hyphens.", maxTagSlugChars)
+	}
+	if isBlank(ch.Name) {
+		fieldErrors["name"] = "This field cannot be blank."
+	} else if !maxChars(ch.Name, maxChapterNameChars) {
+		fieldErrors["name"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxChapterNameChars)
+	}
+	if !maxChars(ch.Description, maxChapterDescriptionChars) {
+		fieldErrors["description"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxChapterDescriptionChars)
+	}
+
+	// Rules are named by index, e.g. "rules.0.country".
+	if len(ch.Rules) == 0 {
+		fieldErrors["rules"] = "This field must have at least one rule."
+	} else if len(ch.Rules) > maxChapterRules {
+		fieldErrors["rules"] = fmt.Sprintf("This field cannot have more than %d rules.", maxChapterRules)
+	}
+	for i, r := range ch.Rules {
+		if !countryCodeRX.MatchString(r.Country) {
+			fieldErrors[fmt.Sprintf("rules.%d.country", i)] = "This field must be a two letter country code."
+		}
+		if !maxChars(r.Region, maxChapterRegionChars) {
+			fieldErrors[fmt.Sprintf("rules.%d.region", i)] = fmt.Sprintf("This field cannot be more than %d characters long.", maxChapterRegionChars)
+		}
+		if !maxChars(r.PostalPrefix, maxChapterPostalPrefixChars) {
+			fieldErrors[fmt.Sprintf("rules.%d.postalPrefix", i)] = fmt.Sprintf("This field cannot be more than %d characters long.", maxChapterPostalPrefixChars)
+		}
+	}
+
+	return fieldErrors
+}
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (a *Address) normalize() {
+	a.Line1 = normalizeText(a.Line1, false)
+	a.Line2 = normalizeText(a.Line2, false)
+	a.City = normalizeText(a.City, false)
+	a.Region = normalizeText(a.Region, false)
+	a.PostalCode = normalizePostalCode(a.PostalCode)
+	a.Country = strings.ToUpper(normalizeText(a.Country, false))
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (a *Address) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"line1", a.Line1},
+		{"city", a.City},
+	}
+	for _, f := range required {
+		if isBlank(f.value) {
+			fieldErrors[f.name] = "This field cannot be blank."
+		} else if !maxChars(f.value, maxAddressLineChars) {
+			fieldErrors[f.name] = fmt.Sprintf("This field cannot be more than %d characters long.", maxAddressLineChars)
+		}
+	}
+	if !maxChars(a.Line2, maxAddressLineChars) {
+		fieldErrors["line2"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxAddressLineChars)
+	}
+	if !maxChars(a.Region, maxChapterRegionChars) {
+		fieldErrors["region"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxChapterRegionChars)
+	}
+	if !maxChars(a.PostalCode, maxAddressPostalCodeChars) {
+		fieldErrors["postalCode"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxAddressPostalCodeChars)
+	}
+	if !countryCodeRX.MatchString(a.Country) {
+		fieldErrors["country"] = "This field must be a two letter country code."
+	}
+
+	return fieldErrors
+}
+
+// Uppercases a postal code and removes its spaces, so that e.g. "sw1a 1aa"
+// and "SW1A1AA" match the same rules.
+func normalizePostalCode(s string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(s), ""))
+}
+
+// Returns how specifically the rule covers the address, or 0 if it does not.
+// A postal prefix is more specific than a region, and a longer prefix more
+// specific than a shorter one.
+func (r *ChapterRule) match(a *Address) int {
+	if r.Country != a.Country {
+		return 0
+	}
+	score := 1
+	if r.Region != "" {
+		if !strings.EqualFold(r.Region, a.Region) {
+			return 0
+		}
+		score += 10
+	}
+	if r.PostalPrefix != "" {
+		if !strings.HasPrefix(a.PostalCode, r.PostalPrefix) {
+			return 0
+		}
+		score += 100 + len(r.PostalPrefix)
+	}
+	return score
+}
+
+// Builds the CHAPTER_MEMBER key of a chapter's member. The 0 byte separates
+// the slug from the userId, so a chapter's members can be iterated by seeking
+// to slug + 0.
+func chapterMemberKey(slug string, userBinId []byte) []byte {
+	return compositeKey(tagPrefix(slug), userBinId)
+}
+
+// Returns the slug of the chapter which most specifically covers the address,
+// or "" if none does. Ties go to the first chapter by slug.
+func matchChapter(tx *bolt.Tx, a *Address) (string, error) {
+	best, bestScore := "", 0
+	err := tx.Bucket([]byte("CHAPTER")).ForEach(func(k, v []byte) error {
+		var chapter Chapter
+		if err := json.Unmarshal(v, &chapter); err != nil {
+			return err
+		}
+		for _, r := range chapter.Rules {
+			if score := r.match(a); score > bestScore {
+				best, bestScore = chapter.Slug, score
+			}
+		}
+		return nil
+	})
+	return best, err
+}
+
+// Assigns a member to a chapter within an existing db transaction, replacing
+// any previous assignment. Pass "" to unassign the member.
+func assignChapter(tx *bolt.Tx, userBinId []byte, slug string) error {
+	ub := tx.Bucket([]byte("USER_CHAPTER"))
+	mb := tx.Bucket([]byte("CHAPTER_MEMBER"))
+
+	prior := string(ub.Get(userBinId))
+	if prior == slug {
+		return nil
+	}
+	if prior != "" {
+		if err := mb.Delete(chapterMemberKey(prior, userBinId)); err != nil {
+			return err
+		}
+	}
+	if slug == "" {
+		return ub.Delete(userBinId)
+	}
+	if err := mb.Put(chapterMemberKey(slug, userBinId), []byte{}); err != nil {
+		return err
+	}
+	return ub.Put(userBinId, []byte(slug))
+}
+
+// Re-assigns every member with an address, within an existing db transaction.
+// Called whenever chapters (and so their rules) change.
+func reassignChapters(tx *bolt.Tx) error {
+	return tx.Bucket([]byte("USER_ADDR")).ForEach(func(k, v []byte) error {
+		var address Address
+		if err := json.Unmarshal(v, &address); err != nil {
+			return err
+		}
+		slug, err := matchChapter(tx, &address)
+		if err != nil {
+			return err
+		}
+		return assignChapter(tx, k, slug)
+	})
+}
+
+// Reads the userIds of a chapter's members, within an existing db transaction.
+func getChapterMembers(tx *bolt.Tx, slug string) (map[string]bool, error) {
+	members := map[string]bool{}
+	prefix := tagPrefix(slug)
+	c := tx.Bucket([]byte("CHAPTER_MEMBER")).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		var userId ulid.ULID
+		if err := userId.UnmarshalBinary(k[len(prefix):]); err != nil {
+			return nil, err
+		}
+		members[userId.String()] = true
+	}
+	return members, nil
+}
+
+// Reads a chapter within an existing db transaction, including its number of
+// members.
+func getChapter(tx *bolt.Tx, slug string) (Chapter, error) {
+	var chapter Chapter
+	chapterBytes := tx.Bucket([]byte("CHAPTER")).Get([]byte(slug))
+	if chapterBytes == nil {
+		return chapter, errChapterNotFound
+	}
+	if err := json.Unmarshal(chapterBytes, &chapter); err != nil {
+		return chapter, err
+	}
+	members, err := getChapterMembers(tx, slug)
+	chapter.MemberCount = len(members)
+	return chapter, err
+}
+
+// Checks that the exim's chapter (if any) exists, within an existing db
+// transaction.
+func (e *Exim) checkChapterExists(tx *bolt.Tx) error {
+	if e.Chapter == "" {
+		return nil
+	}
+	if tx.Bucket([]byte("CHAPTER")).Get([]byte(e.Chapter)) == nil {
+		return fmt.Errorf("%w (%s)", errChapterNotFound, e.Chapter)
+	}
+	return nil
+}
+
+// Writes a new chapter to db, and assigns the members it covers to it.
+func (ch *Chapter) createChapterTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("CHAPTER"))
+
+		if b.Get([]byte(ch.Slug)) != nil {
+			return errChapterExists
+		}
+
+		ch.CreatedTs = time.Now()
+		chapterJs, err := json.Marshal(ch)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(ch.Slug), chapterJs); err != nil {
+			return err
+		}
+		if err := reassignChapters(tx); err != nil {
+			return err
+		}
+
+		chapter, err := getChapter(tx, ch.Slug)
+		*ch = chapter
+		return err
+	})
+}
+
+// Updates an existing chapter's name, description and rules, and re-assigns
+// members accordingly. On success, the receiver is set to the updated chapter.
+func (ch *Chapter) updateChapterTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("CHAPTER"))
+
+		current, err := getChapter(tx, ch.Slug)
+		if err != nil {
+			return err
+		}
+
+		current.Name = ch.Name
+		current.Description = ch.Description
+		current.Rules = ch.Rules
+		current.MemberCount = 0
+		chapterJs, err := json.Marshal(current)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(ch.Slug), chapterJs); err != nil {
+			return err
+		}
+		if err := reassignChapters(tx); err != nil {
+			return err
+		}
+
+		chapter, err := getChapter(tx, ch.Slug)
+		*ch = chapter
+		return err
+	})
+}
+
+// Deletes a chapter, unscoping every exim scoped to it and re-assigning its
+// members to whichever other chapter covers them.
+func (ch *Chapter) deleteChapterTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		// Retrieve buckets.
+		b := tx.Bucket([]byte("CHAPTER"))
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+
+		if b.Get([]byte(ch.Slug)) == nil {
+			return errChapterNotFound
+		}
+
+		// Exims are not indexed by chapter, so every exim is scanned. Collect
+		// updates first, since writing while iterating is not permitted.
+		updates := map[string][]byte{}
+		err := eb.ForEach(func(k, v []byte) error {
+			var exim Exim
+			if err := json.Unmarshal(v, &exim); err != nil {
+				return err
+			}
+			if exim.Chapter != ch.Slug {
+				return nil
+			}
+			exim.Chapter = ""
+			eximJs, err := json.Marshal(exim)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = eximJs
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range updates {
+			if err := eb.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+
+		if err := b.Delete([]byte(ch.Slug)); err != nil {
+			return err
+		}
+		return reassignChapters(tx)
+	})
+}
+
+// Reads a chapter, including its number of members.
+func (ch *Chapter) getChapterTx() error {
+	return db.View(func(tx *bolt.Tx) error {
+		chapter, err := getChapter(tx, ch.Slug)
+		*ch = chapter
+		return err
+	})
+}
+
+// Reads all chapters sorted by name, including their number of members.
+func (chs *Chapters) getChaptersTx() error {
+	return db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte("CHAPTER")).ForEach(func(k, v []byte) error {
+			chapter, err := getChapter(tx, string(k))
+			if err != nil {
+				return err
+			}
+			*chs = append(*chs, chapter)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		slices.SortFunc(*chs, func(a, b Chapter) int {
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		})
+		return nil
+	})
+}
+
+// Reads a member's address and chapter within an existing db transaction.
+func getMemberLocation(tx *bolt.Tx, userBinId []byte) (MemberLocation, error) {
+	var location MemberLocation
+	if addressJs := tx.Bucket([]byte("USER_ADDR")).Get(userBinId); addressJs != nil {
+		location.Address = new(Address)
+		if err := json.Unmarshal(addressJs, location.Address); err != nil {
+			return location, err
+		}
+	}
+	if slug := tx.Bucket([]byte("USER_CHAPTER")).Get(userBinId); slug != nil {
+		chapter, err := getChapter(tx, string(slug))
+		if err != nil {
+			return location, err
+		}
+		location.Chapter = &chapter
+	}
+	return location, nil
+}
+
+// Reads the member's address and chapter into the receiver.
+func (l *MemberLocation) getLocationTx(userBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		location, err := getMemberLocation(tx, userBinId)
+		*l = location
+		return err
+	})
+}
+
+// Replaces the member's address with the receiver's Address (or removes it,
+// if nil), and assigns the member to the chapter covering it. On success, the
+// receiver is set to the member's address and chapter.
+func (l *MemberLocation) updateAddressTx(userBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("USER_ADDR"))
+
+		if tx.Bucket([]byte("USER_AUTH")).Get(userBinId) == nil {
+			return errUserNotFound
+		}
+
+		slug := ""
+		if l.Address == nil {
+			if err := b.Delete(userBinId); err != nil {
+				return err
+			}
+		} else {
+			addressJs, err := json.Marshal(l.Address)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(userBinId, addressJs); err != nil {
+				return err
+			}
+			if slug, err = matchChapter(tx, l.Address); err != nil {
+				return err
+			}
+		}
+		if err := assignChapter(tx, userBinId, slug); err != nil {
+			return err
+		}
+
+		location, err := getMemberLocation(tx, userBinId)
+		*l = location
+		return err
+	})
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+const maxCommentBodyChars = 2000
+
+// Replies may be nested this deep (top level comments have depth 0).
+const maxCommentDepth = 5
+
+// How long after posting a comment its author may edit it.
+const commentEditWindow = 15 * time.Minute
+
+var errCommentNotFound = errors.New("comment does not exist")
+var errCommentNotPermitted = errors.New("user is not permitted to modify this comment")
+var errCommentEditWindow = errors.New("comment can no longer be edited")
+var errCommentDeleted = errors.New("comment has been deleted")
+var errCommentTooDeep = errors.New("replies cannot be nested any deeper")
+var errEximNotCommentable = errors.New("exim cannot be commented on in its current state")
+
+// A comment on an exim, or a reply to another comment (ParentId). Deleted
+// comments keep their place in the thread, but not their body.
+type Comment struct {
+	CommentId ulid.ULID `json:"commentId"`
+	ParentId  string    `json:"parentId,omitempty"`
+	Depth     int       `json:"depth"`
+	AuthorId  string    `json:"authorId"`
+	// AuthorName is only set on read, see participant.
+	AuthorName string     `json:"authorName,omitempty"`
+	Body       string     `json:"body"`
+	CreatedTs  time.Time  `json:"createdTs"`
+	EditedTs   *time.Time `json:"editedTs,omitempty"`
+	IsDeleted  bool       `json:"isDeleted"`
+	// Replies are assembled on read, see getEximCommentsTx.
+	Replies Comments `json:"replies,omitempty"`
+}
+
+type Comments []Comment
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (c *Comment) normalize() {
+	c.Body = normalizeText(c.Body, true)
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (c *Comment) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	if isBlank(c.Body) {
+		fieldErrors["body"] = "This field cannot be blank."
+	} else if !maxChars(c.Body, maxCommentBodyChars) {
+		fieldErrors["body"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxCommentBodyChars)
+	}
+
+	return fieldErrors
+}
+
+// Writes the receiver as a new comment on the exim, or as a reply if ParentId
+// is set. Drafts and withdrawn or removed exims cannot be commented on, and
+// deleted comments cannot be replied to.
+func (c *Comment) createCommentTx(eximBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		// Retrieve buckets.
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		cb := tx.Bucket([]byte("MOD_EXIM_COMMENT"))
+
+		// Retrieve exim.
+		eximBytes := eb.Get(eximBinId)
+		if eximBytes == nil {
+			return errEximNotFound
+		}
+		var exim Exim
+		if err := json.Unmarshal(eximBytes, &exim); err != nil {
+			return err
+		}
+		if exim.Removal != nil {
+			return errEximRemoved
+		}
+		if exim.State == eximStateDraft {
+			return errEximNotCommentable
+		}
+
+		// Replies go one level deeper than their parent.
+		if c.ParentId != "" {
+			parentId, err := ulid.ParseStrict(c.ParentId)
+			if err != nil {
+				return errCommentNotFound
+			}
+			parentBinId, _ := parentId.MarshalBinary()
+			parentBytes := cb.Get(compositeKey(eximBinId, parentBinId))
+			if parentBytes == nil {
+				return errCommentNotFound
+			}
+			var parent Comment
+			if err := json.Unmarshal(parentBytes, &parent); err != nil {
+				return err
+			}
+			if parent.IsDeleted {
+				return errCommentDeleted
+			}
+			if parent.Depth >= maxCommentDepth {
+				return errCommentTooDeep
+			}
+			c.Depth = parent.Depth + 1
+			// Replies are threaded by the canonical form of the id.
+			c.ParentId = parentId.String()
+		}
+
+		id, binId := createUlid()
+		c.CommentId = id
+		c.CreatedTs = time.Now()
+
+		// Marshal Comment to be stored.
+		commentJs, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return cb.Put(compositeKey(eximBinId, binId), commentJs)
+	})
+}
+
+// Replaces the body of a comment. Only its author may edit it, and only within
+// commentEditWindow of posting. Comments on withdrawn or removed exims cannot
+// be edited. On success, the receiver is set to the updated comment.
+func (c *Comment) editCommentTx(eximBinId []byte, commentBinId []byte, editorId string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		cb := tx.Bucket([]byte("MOD_EXIM_COMMENT"))
+		key := compositeKey(eximBinId, commentBinId)
+
+		// Retrieve exim.
+		var exim Exim
+		if err := getExim(tx, eximBinId, &exim); err != nil {
+			return err
+		}
+		if exim.Removal != nil {
+			return errEximRemoved
+		}
+
+		// Retrieve current comment.
+		commentBytes := cb.Get(key)
+		if commentBytes == nil {
+			return errCommentNotFound
+		}
+		var current Comment
+		if err := json.Unmarshal(commentBytes, &current); err != nil {
+			return err
+		}
+
+		if current.AuthorId != editorId {
+			return errCommentNotPermitted
+		}
+		if current.IsDeleted {
+			return errCommentDeleted
+		}
+		now := time.Now()
+		if now.After(current.CreatedTs.Add(commentEditWindow)) {
+			return errCommentEditWindow
+		}
+
+		current.Body = c.Body
+		current.EditedTs = &now
+		commentJs, err := json.Marshal(current)
+		if err != nil {
+			return err
+		}
+		if err := cb.Put(key, commentJs); err != nil {
+			return err
+		}
+
+		*c = current
+		return nil
+	})
+}
+
+// Deletes the body of a comment, leaving its replies in place. Only its author
+// or a moderator may delete it. On success, the receiver is set to the comment.
+func (c *Comment) deleteCommentTx(eximBinId []byte, commentBinId []byte, userId string, isModerator bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return c.applyDeletion(tx, eximBinId, commentBinId, userId, isModerator, "")
+	})
+}
+
+// Deletes a comment within an existing db transaction, see deleteCommentTx.
+// Deletions by moderators of someone else's comment are audited, along with
+// the report (if any) which prompted them.
+func (c *Comment) applyDeletion(tx *bolt.Tx, eximBinId []byte, commentBinId []byte, userId string, isModerator bool, reportId string) error {
+	cb := tx.Bucket([]byte("MOD_EXIM_COMMENT"))
+	key := compositeKey(eximBinId, commentBinId)
+
+	// Retrieve current comment.
+	commentBytes := cb.Get(key)
+	if commentBytes == nil {
+		return errCommentNotFound
+	}
+	if err := json.Unmarshal(commentBytes, c); err != nil {
+		return err
+	}
+
+	if c.AuthorId != userId && !isModerator {
+		return errCommentNotPermitted
+	}
+	if c.IsDeleted {
+		return errCommentDeleted
+	}
+
+	if c.AuthorId != userId {
+		var eximId ulid.ULID
+		if err := eximId.UnmarshalBinary(eximBinId); err != nil {
+			return err
+		}
+		err := writeAudit(tx, AuditEntry{
+			ActorId:     userId,
+			Action:      auditActionCommentDelete,
+			SubjectKind: "comment",
+			SubjectId:   c.CommentId.String(),
+			EximId:      eximId.String(),
+			ReportId:    reportId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Body = ""
+	c.IsDeleted = true
+	commentJs, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return cb.Put(key, commentJs)
+}
+
+// Reads a single comment, without its replies.
+func (c *Comment) getCommentTx(eximBinId []byte, commentBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		cb := tx.Bucket([]byte("MOD_EXIM_COMMENT"))
+
+		commentBytes := cb.Get(compositeKey(eximBinId, commentBinId))
+		if commentBytes == nil {
+			return errCommentNotFound
+		}
+		return json.Unmarshal(commentBytes, c)
+	})
+}
+
+// Reads an exim's comments as threads: top level comments oldest first, each
+// with its replies (recursively) oldest first. The comments of withdrawn or
+// removed exims are not found.
+func (cs *Comments) getEximCommentsTx(eximBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		cb := tx.Bucket([]byte("MOD_EXIM_COMMENT"))
+
+		eximBytes := eb.Get(eximBinId)
+		if eximBytes == nil {
+			return errEximNotFound
+		}
+		var exim Exim
+		if err := json.Unmarshal(eximBytes, &exim); err != nil {
+			return err
+		}
+		// Comments are hidden along with the content of a withdrawn or
+		// removed exim.
+		if exim.Removal != nil {
+			return errEximNotFound
+		}
+
+		// Iterate over keys prefixed with eximId (i.e. in creation order),
+		// grouping replies by parent.
+		replies := map[string]Comments{}
+		c := cb.Cursor()
+		for k, v := c.Seek(eximBinId); k != nil && bytes.HasPrefix(k, eximBinId); k, v = c.Next() {
+			var comment Comment
+			if err := json.Unmarshal(v, &comment); err != nil {
+				return err
+			}
+			var err error
+			comment.AuthorId, comment.AuthorName, err = exim.participant(tx, comment.AuthorId)
+			if err != nil {
+				return err
+			}
+			replies[comment.ParentId] = append(replies[comment.ParentId], comment)
+		}
+
+		// Assemble threads from the top.
+		*cs = threadComments(replies, "")
+		return nil
+	})
+}
+
+// Assembles the replies to parentId (or the top level comments, if empty),
+// each with its own replies.
+func threadComments(replies map[string]Comments, parentId string) Comments {
+	thread := replies[parentId]
+	for i := range thread {
+		thread[i].Replies = threadComments(replies, thread[i].CommentId.String())
+	}
+	return thread
+}
+
+// Returns the comment with the provided id from the threads, or nil.
+func (cs Comments) find(commentId string) *Comment {
+	for i := range cs {
+		if cs[i].CommentId.String() == commentId {
+			return &cs[i]
+		}
+		if found := cs[i].Replies.find(commentId); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Returns true if the comment may be replied to. Used by templates.
+func (c *Comment) CanReply() bool {
+	return !c.IsDeleted && c.Depth < maxCommentDepth
+}
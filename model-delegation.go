@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+var errDelegationNotFound = errors.New("delegation does not exist")
+var errDelegateSelf = errors.New("support cannot be delegated to oneself")
+
+// A member's delegation of their support weight to another member, either
+// globally (Tag is empty) or for exims with Tag. Delegations are keyed by
+// delegatorId + tag, so a member has at most one per tag, plus a global one.
+//
+// A member who delegates supports every exim their delegate supports, unless
+// they vote on it directly (by supporting or abstaining). Delegations are
+// transitive: if the delegate has not voted either, their own delegation is
+// followed, and so on (see resolveDelegate).
+type Delegation struct {
+	DelegateId string    `json:"delegateId"`
+	Tag        string    `json:"tag,omitempty"`
+	CreatedTs  time.Time `json:"createdTs"`
+	// The following are only set on read, see getDelegationsTx. HolderId is
+	// the member at the end of the chain of delegations, who votes with the
+	// delegator's weight, and is empty if the chain is a cycle.
+	DelegateName string `json:"delegateName,omitempty"`
+	HolderId     string `json:"holderId,omitempty"`
+	HolderName   string `json:"holderName,omitempty"`
+	IsCycle      bool   `json:"isCycle"`
+}
+
+type Delegations []Delegation
+
+// Every member's delegations, read once per transaction (see
+// getDelegationMap). Delegates holds each delegator's delegates by tag ("" for
+// global), and delegators the reverse: everyone who delegates to a member, for
+// any tag.
+type delegationMap struct {
+	delegates  map[string]map[string]string
+	delegators map[string][]string
+}
+
+// Reads every delegation within an existing db transaction.
+func getDelegationMap(tx *bolt.Tx) (*delegationMap, error) {
+	dm := &delegationMap{delegates: map[string]map[string]string{}, delegators: map[string][]string{}}
+	err := tx.Bucket([]byte("USER_DELEGATION")).ForEach(func(k, v []byte) error {
+		var delegatorId ulid.ULID
+		if err := delegatorId.UnmarshalBinary(k[:16]); err != nil {
+			return err
+		}
+		var delegation Delegation
+		if err := json.Unmarshal(v, &delegation); err != nil {
+			return err
+		}
+		dm.add(delegatorId.String(), delegation.Tag, delegation.DelegateId)
+		return nil
+	})
+	return dm, err
+}
+
+func (dm *delegationMap) add(delegatorId string, tag string, delegateId string) {
+	if dm.delegates[delegatorId] == nil {
+		dm.delegates[delegatorId] = map[string]string{}
+	}
+	dm.delegates[delegatorId][tag] = delegateId
+	if !slices.Contains(dm.delegators[delegateId], delegatorId) {
+		dm.delegators[delegateId] = append(dm.delegators[delegateId], delegatorId)
+	}
+}
+
+// Returns whom userId delegates to for an exim with the provided (sorted)
+// tags: the delegate for the first tag they delegate, or else their global
+// delegate. Returns "" if they do not delegate.
+func (dm *delegationMap) delegateFor(userId string, tags []string) string {
+	for _, tag := range tags {
+		if delegateId, ok := dm.delegates[userId][tag]; ok {
+			return delegateId
+		}
+	}
+	return dm.delegates[userId][""]
+}
+
+// Follows userId's chain of delegations for the provided tags until stop
+// returns true for a member, returning that member. Returns "" if the chain
+// ends first, or if it is a cycle.
+func (dm *delegationMap) resolveDelegate(userId string, tags []string, stop func(string) bool) string {
+	visited := map[string]bool{userId: true}
+	for current := userId; ; {
+		next := dm.delegateFor(current, tags)
+		if next == "" || visited[next] {
+			return ""
+		}
+		if stop(next) {
+			return next
+		}
+		visited[next] = true
+		current = next
+	}
+}
+
+// Returns the members who have not voted (see hasVoted) and whose chain of
+// delegations for the provided tags resolves to one of supporters, i.e. who
+// support an exim with those tags by delegation.
+//
+// Chains are followed backwards from the supporters, so only members who
+// delegate towards them are visited. A chain which forms a cycle never reaches
+// a supporter, so members in (or delegating into) a cycle are not returned.
+func (dm *delegationMap) delegatedSupporters(supporters map[string]bool, tags []string, hasVoted func(string) bool) []string {
+	var found []string
+	visited := map[string]bool{}
+	queue := make([]string, 0, len(supporters))
+	for userId := range supporters {
+		queue = append(queue, userId)
+		visited[userId] = true
+	}
+	for len(queue) > 0 {
+		holderId := queue[0]
+		queue = queue[1:]
+		for _, delegatorId := range dm.delegators[holderId] {
+			if visited[delegatorId] || hasVoted(delegatorId) || dm.delegateFor(delegatorId, tags) != holderId {
+				continue
+			}
+			visited[delegatorId] = true
+			found = append(found, delegatorId)
+			queue = append(queue, delegatorId)
+		}
+	}
+	return found
+}
+
+// Collects the userIds keyed under a prefix (e.g. an exim's supporters) within
+// an existing db transaction.
+func getKeyedUserIds(b *bolt.Bucket, prefix []byte) (map[string]bool, error) {
+	userIds := map[string]bool{}
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		var userId ulid.ULID
+		if err := userId.UnmarshalBinary(k[len(prefix):]); err != nil {
+			return nil, err
+		}
+		userIds[userId.String()] = true
+	}
+	return userIds, nil
+}
+
+// Builds the USER_DELEGATION key of a delegation.
+func delegationKey(delegatorBinId []byte, tag string) []byte {
+	return compositeKey(delegatorBinId, []byte(tag))
+}
+
+// Delegates the member's support to the receiver's DelegateId, for the
+// receiver's Tag (or globally), replacing any previous delegation for it.
+func (d *Delegation) setDelegationTx(delegatorBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		var delegateId ulid.ULID
+		if err := delegateId.UnmarshalText([]byte(d.DelegateId)); err != nil {
+			return errUserNotFound
+		}
+		delegateBinId, err := delegateId.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(delegateBinId, delegatorBinId) {
+			return errDelegateSelf
+		}
+		if tx.Bucket([]byte("USER_AUTH")).Get(delegateBinId) == nil {
+			return errUserNotFound
+		}
+		if d.Tag != "" && tx.Bucket([]byte("TAG")).Get([]byte(d.Tag)) == nil {
+			return errTagNotFound
+		}
+
+		d.CreatedTs = time.Now()
+		delegationJs, err := json.Marshal(Delegation{DelegateId: d.DelegateId, Tag: d.Tag, CreatedTs: d.CreatedTs})
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte("USER_DELEGATION")).Put(delegationKey(delegatorBinId, d.Tag), delegationJs); err != nil {
+			return err
+		}
+		return reindexSupport(tx)
+	})
+}
+
+// Removes the member's delegation for the receiver's Tag (or their global
+// delegation).
+func (d *Delegation) removeDelegationTx(delegatorBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("USER_DELEGATION"))
+		key := delegationKey(delegatorBinId, d.Tag)
+		if b.Get(key) == nil {
+			return errDelegationNotFound
+		}
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+		return reindexSupport(tx)
+	})
+}
+
+// Reads the member's delegations, global first and then by tag, each with the
+// member who currently holds it at the end of the chain.
+func (ds *Delegations) getDelegationsTx(delegatorBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		var delegatorId ulid.ULID
+		if err := delegatorId.UnmarshalBinary(delegatorBinId); err != nil {
+			return err
+		}
+		dm, err := getDelegationMap(tx)
+		if err != nil {
+			return err
+		}
+
+		c := tx.Bucket([]byte("USER_DELEGATION")).Cursor()
+		for k, v := c.Seek(delegatorBinId); k != nil && bytes.HasPrefix(k, delegatorBinId); k, v = c.Next() {
+			var d Delegation
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if d.DelegateName, err = memberName(tx, d.DelegateId); err != nil {
+				return err
+			}
+
+			// The holder is the first member in the chain who does not
+			// delegate further.
+			var tags []string
+			if d.Tag != "" {
+				tags = []string{d.Tag}
+			}
+			d.HolderId = dm.resolveDelegate(delegatorId.String(), tags, func(userId string) bool {
+				return dm.delegateFor(userId, tags) == ""
+			})
+			d.IsCycle = d.HolderId == ""
+			if !d.IsCycle {
+				if d.HolderName, err = memberName(tx, d.HolderId); err != nil {
+					return err
+				}
+			}
+			*ds = append(*ds, d)
+		}
+		return nil
+	})
+}
+
+// Removes every delegation made by or to a user within an existing db
+// transaction, see deleteUser.
+func removeUserDelegations(tx *bolt.Tx, userBinId []byte) error {
+	var userId ulid.ULID
+	if err := userId.UnmarshalBinary(userBinId); err != nil {
+		return err
+	}
+
+	// Collect keys first, since deleting while iterating is not permitted.
+	b := tx.Bucket([]byte("USER_DELEGATION"))
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var d Delegation
+		if err := json.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		if bytes.HasPrefix(k, userBinId) || d.DelegateId == userId.String() {
+			keys = append(keys, bytes.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes every delegation for a tag within an existing db transaction, see
+// deleteTagTx.
+func removeTagDelegations(tx *bolt.Tx, slug string) error {
+	// Collect keys first, since deleting while iterating is not permitted.
+	b := tx.Bucket([]byte("USER_DELEGATION"))
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var d Delegation
+		if err := json.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		if d.Tag == slug {
+			keys = append(keys, bytes.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns an exim's tags, which decide whose delegations apply to it, within
+// an existing db transaction.
+func getEximTags(tx *bolt.Tx, eximBinId []byte) ([]string, error) {
+	eximBytes := tx.Bucket([]byte("MOD_EXIM")).Get(eximBinId)
+	if eximBytes == nil {
+		return nil, errEximNotFound
+	}
+	var exim Exim
+	if err := json.Unmarshal(eximBytes, &exim); err != nil {
+		return nil, err
+	}
+	return exim.Tags, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+type testDelegation struct {
+	delegator, tag, delegate string
+}
+
+func newTestDelegationMap(delegations []testDelegation) *delegationMap {
+	dm := &delegationMap{delegates: map[string]map[string]string{}, delegators: map[string][]string{}}
+	for _, d := range delegations {
+		dm.add(d.delegator, d.tag, d.delegate)
+	}
+	return dm
+}
+
+func TestDelegatedSupporters(t *testing.T) {
+	tests := []struct {
+		name        string
+		delegations []testDelegation
+		tags        []string
+		supporters  []string
+		abstainers  []string
+		want        []string
+	}{
+		{
+			name:       "no delegations",
+			supporters: []string{"a"},
+			want:       nil,
+		},
+		{
+			name:        "transitive chain",
+			delegations: []testDelegation{{"a", "", "b"}, {"b", "", "c"}},
+			supporters:  []string{"c"},
+			want:        []string{"a", "b"},
+		},
+		{
+			name:        "chain stops at an abstainer",
+			delegations: []testDelegation{{"a", "", "b"}, {"b", "", "c"}},
+			supporters:  []string{"c"},
+			abstainers:  []string{"b"},
+			want:        nil,
+		},
+		{
+			name:        "direct vote overrides delegation",
+			delegations: []testDelegation{{"a", "", "b"}},
+			supporters:  []string{"b"},
+			abstainers:  []string{"a"},
+			want:        nil,
+		},
+		{
+			name:        "delegating supporter is counted once",
+			delegations: []testDelegation{{"a", "", "b"}},
+			supporters:  []string{"a", "b"},
+			want:        nil,
+		},
+		{
+			name:        "cycle counts for nothing",
+			delegations: []testDelegation{{"a", "", "b"}, {"b", "", "a"}},
+			supporters:  []string{"c"},
+			want:        nil,
+		},
+		{
+			name:        "delegating into a cycle counts for nothing",
+			delegations: []testDelegation{{"a", "", "b"}, {"b", "", "c"}, {"c", "", "b"}},
+			supporters:  []string{"d"},
+			want:        nil,
+		},
+		{
+			name:        "cycle broken by a supporter",
+			delegations: []testDelegation{{"a", "", "b"}, {"b", "", "a"}},
+			supporters:  []string{"b"},
+			want:        []string{"a"},
+		},
+		{
+			name:        "tag delegation overrides global delegation",
+			delegations: []testDelegation{{"a", "", "b"}, {"a", "housing", "c"}},
+			tags:        []string{"housing"},
+			supporters:  []string{"b"},
+			want:        nil,
+		},
+		{
+			name:        "global delegation applies to other tags",
+			delegations: []testDelegation{{"a", "", "b"}, {"a", "housing", "c"}},
+			tags:        []string{"energy"},
+			supporters:  []string{"b"},
+			want:        []string{"a"},
+		},
+		{
+			name:        "first delegated tag applies",
+			delegations: []testDelegation{{"a", "energy", "b"}, {"a", "housing", "c"}},
+			tags:        []string{"energy", "housing"},
+			supporters:  []string{"b"},
+			want:        []string{"a"},
+		},
+		{
+			name:        "tag delegation midway through a chain",
+			delegations: []testDelegation{{"a", "", "b"}, {"b", "", "c"}, {"b", "housing", "d"}},
+			tags:        []string{"housing"},
+			supporters:  []string{"c"},
+			want:        nil,
+		},
+		{
+			name:        "several chains into one supporter",
+			delegations: []testDelegation{{"a", "", "c"}, {"b", "", "c"}, {"d", "", "a"}},
+			supporters:  []string{"c"},
+			want:        []string{"a", "b", "d"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := newTestDelegationMap(tt.delegations)
+			supporters := map[string]bool{}
+			for _, userId := range tt.supporters {
+				supporters[userId] = true
+			}
+			hasVoted := func(userId string) bool {
+				return supporters[userId] || slices.Contains(tt.abstainers, userId)
+			}
+
+			got := dm.delegatedSupporters(supporters, tt.tags, hasVoted)
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("delegatedSupporters() = %v, want %v", got, tt.want)
+			}
+
+			// Must agree with following each member's chain forwards.
+			var forward []string
+			for delegatorId := range dm.delegates {
+				if hasVoted(delegatorId) {
+					continue
+				}
+				if supporters[dm.resolveDelegate(delegatorId, tt.tags, hasVoted)] {
+					forward = append(forward, delegatorId)
+				}
+			}
+			slices.Sort(forward)
+			if !slices.Equal(got, forward) {
+				t.Errorf("delegatedSupporters() = %v, but resolveDelegate gives %v", got, forward)
+			}
+		})
+	}
+}
+
+func TestResolveDelegate(t *testing.T) {
+	tests := []struct {
+		name        string
+		delegations []testDelegation
+		userId      string
+		want        string
+	}{
+		{"no delegation", nil, "a", ""},
+		{"direct", []testDelegation{{"a", "", "b"}}, "a", "b"},
+		{"transitive", []testDelegation{{"a", "", "b"}, {"b", "", "c"}}, "a", "c"},
+		{"cycle", []testDelegation{{"a", "", "b"}, {"b", "", "c"}, {"c", "", "a"}}, "a", ""},
+		{"into a cycle", []testDelegation{{"a", "", "b"}, {"b", "", "c"}, {"c", "", "b"}}, "a", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := newTestDelegationMap(tt.delegations)
+			// Stop at the holder, who does not delegate further.
+			got := dm.resolveDelegate(tt.userId, nil, func(userId string) bool {
+				return dm.delegateFor(userId, nil) == ""
+			})
+			if got != tt.want {
+				t.Errorf("resolveDelegate(%q) = %q, want %q", tt.userId, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// How often upcoming events are checked for reminders to send, and how long
+// before an event starts its reminder is sent.
+const eventReminderInterval = 5 * time.Minute
+const eventReminderLead = 24 * time.Hour
+
+// How long after they end events remain in calendar feeds.
+const eventFeedPastDays = 90
+
+const maxEventTitleChars = 200
+const maxEventDescriptionChars = 2000
+const maxEventLocationChars = 200
+const maxEventExims = 10
+
+var errEventNotFound = errors.New("event does not exist")
+var errEventNotPermitted = errors.New("user is not permitted to organize events for this chapter")
+var errEventStarted = errors.New("event has already started")
+var errEventCancelled = errors.New("event has been cancelled")
+var errEventFull = errors.New("event is full")
+var errEventCapacity = errors.New("capacity cannot be less than the number of RSVPs")
+var errRSVPExists = errors.New("you have already RSVP'd to this event")
+var errRSVPNotFound = errors.New("you have not RSVP'd to this event")
+
+// A meeting held by a chapter, in person (at Location) and/or online (at
+// OnlineLink), optionally about some exims. Events are organized by members
+// of the chapter or by moderators.
+//
+// Members RSVP until the event starts, up to Capacity (0 for unlimited); each
+// RSVP is keyed by eventId + userId in EVENT_RSVP, and the reminder sent for it
+// under the same key in EVENT_REMINDED. Events are cancelled rather than
+// deleted, so that calendar feeds can tell subscribers.
+type Event struct {
+	EventId     ulid.ULID `json:"eventId"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	StartTs     time.Time `json:"startTs"`
+	EndTs       time.Time `json:"endTs"`
+	Location    string    `json:"location,omitempty"`
+	OnlineLink  string    `json:"onlineLink,omitempty"`
+	Chapter     string    `json:"chapter"`
+	EximIds     []string  `json:"eximIds"`
+	Capacity    int       `json:"capacity"`
+	OrganizerId string    `json:"organizerId"`
+	// Sequence counts revisions (edits and cancellation), for calendar feeds.
+	Sequence    int       `json:"sequence"`
+	IsCancelled bool      `json:"isCancelled"`
+	CreatedTs   time.Time `json:"createdTs"`
+	UpdatedTs   time.Time `json:"updatedTs"`
+	// RSVPCount is computed from EVENT_RSVP, and only set on read.
+	RSVPCount int `json:"rsvpCount"`
+}
+
+type Events []Event
+
+// A reminder of an event, to be emailed to a member who RSVP'd.
+type EventReminder struct {
+	Email     string
+	UserBinId []byte
+	Event     Event
+}
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (e *Event) normalize() {
+	e.Title = normalizeText(e.Title, false)
+	e.Description = normalizeText(e.Description, true)
+	e.Location = normalizeText(e.Location, false)
+	e.OnlineLink = normalizeText(e.OnlineLink, false)
+	e.Chapter = strings.ToLower(normalizeText(e.Chapter, false))
+	for i := range e.EximIds {
+		e.EximIds[i] = normalizeText(e.EximIds[i], false)
+	}
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (e *Event) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	if isBlank(e.Title) {
+		fieldErrors["title"] = "This field cannot be blank."
+	} else if !maxChars(e.Title, maxEventTitleChars) {
+		fieldErrors["title"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxEventTitleChars)
+	}
+	if !maxChars(e.Description, maxEventDescriptionChars) {
+		fieldErrors["description"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxEventDescriptionChars)
+	}
+	if !e.StartTs.After(time.Now()) {
+		fieldErrors["startTs"] = "This field must be in the future."
+	}
+	if !e.EndTs.After(e.StartTs) {
+		fieldErrors["endTs"] = "This field must be after startTs."
+	}
+	if isBlank(e.Location) && isBlank(e.OnlineLink) {
+		fieldErrors["location"] = "At least one of location and onlineLink must be set."
+	} else if !maxChars(e.Location, maxEventLocationChars) {
+		fieldErrors["location"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxEventLocationChars)
+	}
+	if e.OnlineLink != "" && !isAllowedLink(e.OnlineLink) {
+		fieldErrors["onlineLink"] = "This field must be a valid link."
+	}
+	if !isTagSlug(e.Chapter) {
+		fieldErrors["chapter"] = "This field must be the slug of an existing chapter."
+	}
+	if len(e.EximIds) > maxEventExims {
+		fieldErrors["eximIds"] = fmt.Sprintf("This field cannot have more than %d exims.", maxEventExims)
+	}
+	for i, eximId := range e.EximIds {
+		if _, err := ulid.ParseStrict(eximId); err != nil {
+			fieldErrors[fmt.Sprintf("eximIds.%d", i)] = "This field must be a valid eximId."
+		} else if slices.Index(e.EximIds, eximId) != i {
+			fieldErrors[fmt.Sprintf("eximIds.%d", i)] = "This field cannot repeat an exim."
+		}
+	}
+	if e.Capacity < 0 {
+		fieldErrors["capacity"] = "This field cannot be negative."
+	}
+
+	return fieldErrors
+}
+
+// Checks that the receiver's exims exist, within an existing db transaction.
+func (e *Event) checkExims(tx *bolt.Tx) error {
+	eb := tx.Bucket([]byte("MOD_EXIM"))
+	for _, eximId := range e.EximIds {
+		_, eximBinId, err := parseUlidString(eximId)
+		if err != nil {
+			return err
+		}
+		if eb.Get(eximBinId) == nil {
+			return fmt.Errorf("%w (%s)", errEximNotFound, eximId)
+		}
+	}
+	return nil
+}
+
+// Reads an event, including its RSVP count, within an existing db transaction.
+func getEvent(tx *bolt.Tx, eventBinId []byte) (Event, error) {
+	var event Event
+	eventJs := tx.Bucket([]byte("EVENT")).Get(eventBinId)
+	if eventJs == nil {
+		return event, errEventNotFound
+	}
+	if err := json.Unmarshal(eventJs, &event); err != nil {
+		return event, err
+	}
+	event.RSVPCount = 0
+	c := tx.Bucket([]byte("EVENT_RSVP")).Cursor()
+	for k, _ := c.Seek(eventBinId); k != nil && bytes.HasPrefix(k, eventBinId); k, _ = c.Next() {
+		event.RSVPCount++
+	}
+	return event, nil
+}
+
+func putEvent(tx *bolt.Tx, eventBinId []byte, event *Event) error {
+	eventJs, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("EVENT")).Put(eventBinId, eventJs)
+}
+
+// Writes a new event to db, organized by the member, who must belong to the
+// event's chapter unless they are a moderator. Also checks that the chapter
+// and exims exist.
+func (e *Event) createEventTx(userBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if _, err := getChapter(tx, e.Chapter); err != nil {
+			return err
+		}
+		isModerator := tx.Bucket([]byte("USER_MODERATOR")).Get(userBinId) != nil
+		if !isModerator && string(tx.Bucket([]byte("USER_CHAPTER")).Get(userBinId)) != e.Chapter {
+			return errEventNotPermitted
+		}
+		if err := e.checkExims(tx); err != nil {
+			return err
+		}
+
+		id, binId := createUlid()
+		e.EventId = id
+		e.CreatedTs = time.Now()
+		e.UpdatedTs = e.CreatedTs
+		e.Sequence = 0
+		e.IsCancelled = false
+		e.RSVPCount = 0
+		return putEvent(tx, binId, e)
+	})
+}
+
+func (e *Event) getEventTx(eventBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		event, err := getEvent(tx, eventBinId)
+		if err != nil {
+			return err
+		}
+		*e = event
+		return nil
+	})
+}
+
+// Updates an event which has not started or been cancelled with the
+// receiver's details; its chapter and organizer cannot be changed, and its
+// capacity cannot be reduced below its RSVP count. If the event is moved, its
+// reminder will be sent again. Permissions are checked by the handler. On
+// success, the receiver is set to the stored event.
+func (e *Event) editEventTx(eventBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		event, err := getEvent(tx, eventBinId)
+		if err != nil {
+			return err
+		}
+		if event.IsCancelled {
+			return errEventCancelled
+		}
+		if !time.Now().Before(event.StartTs) {
+			return errEventStarted
+		}
+		if e.Capacity > 0 && e.Capacity < event.RSVPCount {
+			return fmt.Errorf("%w (%d)", errEventCapacity, event.RSVPCount)
+		}
+		if err := e.checkExims(tx); err != nil {
+			return err
+		}
+
+		if !e.StartTs.Equal(event.StartTs) {
+			if err := clearEventReminders(tx, eventBinId); err != nil {
+				return err
+			}
+		}
+		event.Title = e.Title
+		event.Description = e.Description
+		event.StartTs = e.StartTs
+		event.EndTs = e.EndTs
+		event.Location = e.Location
+		event.OnlineLink = e.OnlineLink
+		event.EximIds = e.EximIds
+		event.Capacity = e.Capacity
+		event.Sequence++
+		event.UpdatedTs = time.Now()
+		if err := putEvent(tx, eventBinId, &event); err != nil {
+			return err
+		}
+		*e = event
+		return nil
+	})
+}
+
+// Cancels an event which has not started. RSVPs are kept, so that members'
+// calendar feeds show the cancellation. Permissions are checked by the
+// handler.
+func (e *Event) cancelEventTx(eventBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		event, err := getEvent(tx, eventBinId)
+		if err != nil {
+			return err
+		}
+		if event.IsCancelled {
+			return errEventCancelled
+		}
+		if !time.Now().Before(event.StartTs) {
+			return errEventStarted
+		}
+
+		event.IsCancelled = true
+		event.Sequence++
+		event.UpdatedTs = time.Now()
+		if err := putEvent(tx, eventBinId, &event); err != nil {
+			return err
+		}
+		*e = event
+		return nil
+	})
+}
+
+// Reads the events which have not ended, soonest first, optionally only those
+// of a chapter (which must exist).
+func (es *Events) getUpcomingEventsTx(chapter string) error {
+	return db.View(func(tx *bolt.Tx) error {
+		if chapter != "" {
+			if _, err := getChapter(tx, chapter); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		events, err := getEvents(tx, func(k []byte, e *Event) bool {
+			return e.EndTs.After(now) && (chapter == "" || e.Chapter == chapter)
+		})
+		*es = events
+		return err
+	})
+}
+
+// Reads the events which have not ended and which the member organizes or
+// has RSVP'd to, soonest first.
+func (es *Events) getMemberEventsTx(userBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		events, err := getMemberEvents(tx, userBinId, func(e *Event) bool { return e.EndTs.After(now) })
+		*es = events
+		return err
+	})
+}
+
+// Reads the events matching fn within an existing db transaction, soonest
+// first.
+func getEvents(tx *bolt.Tx, fn func(k []byte, e *Event) bool) (Events, error) {
+	events := Events{}
+	c := tx.Bucket([]byte("EVENT")).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var event Event
+		if err := json.Unmarshal(v, &event); err != nil {
+			return nil, err
+		}
+		if !fn(k, &event) {
+			continue
+		}
+		event, err := getEvent(tx, k)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	slices.SortStableFunc(events, func(a, b Event) int { return a.StartTs.Compare(b.StartTs) })
+	return events, nil
+}
+
+// Reads the events matching fn which the member organizes or has RSVP'd to,
+// within an existing db transaction, soonest first.
+func getMemberEvents(tx *bolt.Tx, userBinId []byte, fn func(e *Event) bool) (Events, error) {
+	var userId ulid.ULID
+	if err := userId.UnmarshalBinary(userBinId); err != nil {
+		return nil, err
+	}
+	rb := tx.Bucket([]byte("EVENT_RSVP"))
+	return getEvents(tx, func(k []byte, e *Event) bool {
+		isMember := e.OrganizerId == userId.String() || rb.Get(compositeKey(k, userBinId)) != nil
+		return isMember && fn(e)
+	})
+}
+
+// Reads the events of a chapter (which must exist) for its calendar feed,
+// including those which ended in the last eventFeedPastDays.
+func (es *Events) getChapterFeedTx(chapter string) error {
+	return db.View(func(tx *bolt.Tx) error {
+		if _, err := getChapter(tx, chapter); err != nil {
+			return err
+		}
+		since := time.Now().AddDate(0, 0, -eventFeedPastDays)
+		events, err := getEvents(tx, func(k []byte, e *Event) bool {
+			return e.Chapter == chapter && e.EndTs.After(since)
+		})
+		*es = events
+		return err
+	})
+}
+
+// Reads the events the member organizes or has RSVP'd to for their calendar
+// feed, including those which ended in the last eventFeedPastDays.
+func (es *Events) getMemberFeedTx(userBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		since := time.Now().AddDate(0, 0, -eventFeedPastDays)
+		events, err := getMemberEvents(tx, userBinId, func(e *Event) bool { return e.EndTs.After(since) })
+		*es = events
+		return err
+	})
+}
+
+// RSVPs the member to an event which has not started or been cancelled, if it
+// has room.
+func rsvpEventTx(eventBinId []byte, userBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		rb := tx.Bucket([]byte("EVENT_RSVP"))
+
+		event, err := getEvent(tx, eventBinId)
+		if err != nil {
+			return err
+		}
+		if event.IsCancelled {
+			return errEventCancelled
+		}
+		if !time.Now().Before(event.StartTs) {
+			return errEventStarted
+		}
+		key := compositeKey(eventBinId, userBinId)
+		if rb.Get(key) != nil {
+			return errRSVPExists
+		}
+		if event.Capacity > 0 && event.RSVPCount >= event.Capacity {
+			return errEventFull
+		}
+		return rb.Put(key, []byte(time.Now().Format(time.RFC3339)))
+	})
+}
+
+// Withdraws the member's RSVP to an event which has not started.
+func withdrawRSVPTx(eventBinId []byte, userBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		rb := tx.Bucket([]byte("EVENT_RSVP"))
+
+		event, err := getEvent(tx, eventBinId)
+		if err != nil {
+			return err
+		}
+		if !time.Now().Before(event.StartTs) {
+			return errEventStarted
+		}
+		key := compositeKey(eventBinId, userBinId)
+		if rb.Get(key) == nil {
+			return errRSVPNotFound
+		}
+		if err := tx.Bucket([]byte("EVENT_REMINDED")).Delete(key); err != nil {
+			return err
+		}
+		return rb.Delete(key)
+	})
+}
+
+// Returns when the member RSVP'd to an event, or "" if they have not.
+func getRSVPTx(eventBinId []byte, userBinId []byte) (string, error) {
+	var rsvpTs string
+	err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("EVENT")).Get(eventBinId) == nil {
+			return errEventNotFound
+		}
+		rsvpTs = string(tx.Bucket([]byte("EVENT_RSVP")).Get(compositeKey(eventBinId, userBinId)))
+		return nil
+	})
+	return rsvpTs, err
+}
+
+// Returns a reminder for each RSVP to the events starting within
+// eventReminderLead which has not been reminded. Reminders are returned rather
+// than sent, so that email is not sent from within a transaction; each is
+// recorded by markEventRemindedTx once sent.
+func collectEventRemindersTx() ([]EventReminder, error) {
+	var reminders []EventReminder
+	err := db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		due, err := getEvents(tx, func(k []byte, e *Event) bool {
+			return !e.IsCancelled && e.StartTs.After(now) && !e.StartTs.After(now.Add(eventReminderLead))
+		})
+		if err != nil {
+			return err
+		}
+
+		sb := tx.Bucket([]byte("EVENT_REMINDED"))
+		for _, event := range due {
+			eventBinId, err := event.EventId.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			c := tx.Bucket([]byte("EVENT_RSVP")).Cursor()
+			for k, _ := c.Seek(eventBinId); k != nil && bytes.HasPrefix(k, eventBinId); k, _ = c.Next() {
+				if sb.Get(k) != nil {
+					continue
+				}
+				userBinId := bytes.Clone(k[len(eventBinId):])
+				email, err := getUserEmail(tx, userBinId)
+				if err != nil {
+					return err
+				}
+				reminders = append(reminders, EventReminder{Email: email, UserBinId: userBinId, Event: event})
+			}
+		}
+		return nil
+	})
+	return reminders, err
+}
+
+// Records that the reminder has been sent, unless the RSVP has since been
+// withdrawn or the event moved, in which case it will be sent again.
+func (r *EventReminder) markEventRemindedTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		eventBinId, err := r.Event.EventId.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		event, err := getEvent(tx, eventBinId)
+		if err != nil {
+			return err
+		}
+		key := compositeKey(eventBinId, r.UserBinId)
+		if !event.StartTs.Equal(r.Event.StartTs) || tx.Bucket([]byte("EVENT_RSVP")).Get(key) == nil {
+			return nil
+		}
+		return tx.Bucket([]byte("EVENT_REMINDED")).Put(key, []byte(time.Now().Format(time.RFC3339)))
+	})
+}
+
+// Removes the records of reminders sent for an event, within an existing db
+// transaction, so that they are sent again.
+func clearEventReminders(tx *bolt.Tx, eventBinId []byte) error {
+	sb := tx.Bucket([]byte("EVENT_REMINDED"))
+	// Collect keys first, since deleting while iterating is not permitted.
+	var keys [][]byte
+	c := sb.Cursor()
+	for k, _ := c.Seek(eventBinId); k != nil && bytes.HasPrefix(k, eventBinId); k, _ = c.Next() {
+		keys = append(keys, bytes.Clone(k))
+	}
+	for _, k := range keys {
+		if err := sb.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Emails the reminder in production environment.
+func (r *EventReminder) sendEventReminderEmail() error {
+	if env == nil || *env != "prod" {
+		return nil
+	}
+	subject := fmt.Sprintf("Reminder: %s", r.Event.Title)
+	body := fmt.Sprintf("You have RSVP'd to %s, which starts at %s.", r.Event.Title, r.Event.StartTs.UTC().Format(time.RFC1123))
+	if r.Event.Location != "" {
+		body += fmt.Sprintf("\r\n\r\nLocation: %s", r.Event.Location)
+	}
+	if r.Event.OnlineLink != "" {
+		body += fmt.Sprintf("\r\n\r\nJoin online: %s", r.Event.OnlineLink)
+	}
+	return sendEmail(r.Email, subject, body)
+}
+
+// Sends event reminders every eventReminderInterval, for as long as the
+// server runs. Errors are logged, and reminders which were not sent or
+// recorded are retried on the next tick.
+func runEventReminder() {
+	ticker := time.NewTicker(eventReminderInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reminders, err := collectEventRemindersTx()
+		if err != nil {
+			fmt.Printf("[err][api] collecting event reminders: %v [%s]\n", err, cts())
+			continue
+		}
+		sent := 0
+		for _, reminder := range reminders {
+			if err := reminder.sendEventReminderEmail(); err != nil {
+				fmt.Printf("[err][api] sending email to user: %v [%s]\n", err, cts())
+				continue
+			}
+			if err := reminder.markEventRemindedTx(); err != nil {
+				fmt.Printf("[err][api] recording event reminder: %v [%s]\n", err, cts())
+				continue
+			}
+			sent++
+		}
+		if sent > 0 {
+			fmt.Printf("[api] reminded %d RSVPs of events [%s]\n", sent, cts())
+		}
+	}
+}
+
+// Computes the token which authorizes reading a member's calendar feed, so
+// that calendar apps can subscribe without a session.
+func eventFeedToken(userId ulid.ULID) string {
+	mac := hmac.New(sha256.New, deriveKey("event-feed"))
+	mac.Write([]byte(userId.String()))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Reports whether token authorizes reading the member's calendar feed.
+func verifyEventFeedToken(userId ulid.ULID, token string) bool {
+	return hmac.Equal([]byte(token), []byte(eventFeedToken(userId)))
+}
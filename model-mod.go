@@ -1,28 +1,98 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/oklog/ulid"
 	bolt "go.etcd.io/bbolt"
 )
 
 type Exim struct {
-	EximId     ulid.ULID `json:"eximId"`
-	Author     string    `json:"author"`
-	IsApproved bool      `json:"isApproved"`
-	Target     string    `json:"target"`
-	Title      string    `json:"title"`
-	Summary    string    `json:"summary"`
-	Paragraph1 string    `json:"paragraph1"`
-	Paragraph2 string    `json:"paragraph2"`
-	Paragraph3 string    `json:"paragraph3"`
-	Link       string    `json:"link"`
+	EximId ulid.ULID `json:"eximId"`
+	Author string    `json:"author"`
+	// IsAnonymous hides the Author wherever the exim is shown, see present.
+	IsAnonymous bool `json:"isAnonymous"`
+	// AuthorName is computed from the author's profile, and only set on read.
+	AuthorName string `json:"authorName,omitempty"`
+	State      string `json:"state"`
+	Target     string `json:"target"`
+	// Chapter optionally scopes the Target to a chapter, by slug.
+	Chapter string `json:"chapter,omitempty"`
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+	// Sections make up the body of the exim, in order.
+	Sections EximSections `json:"sections"`
+	Link     string       `json:"link"`
+	// Tags are slugs of existing tags, sorted.
+	Tags []string `json:"tags"`
+	// Removal is set when an exim is withdrawn or removed (soft-deleted).
+	Removal *EximRemoval `json:"removal,omitempty"`
+	// Outcomes are stored separately (MOD_EXIM_OUTCOME), and only set on read.
+	Outcomes Outcomes `json:"outcomes,omitempty"`
+	// SupportCount is computed from MOD_EXIM_SUPPORT and delegations (see
+	// getEximSupport), indexed in MOD_EXIM_SUPPORT_COUNT, and only set on read.
+	SupportCount int `json:"supportCount"`
 }
 
 type Exims []Exim
 
+// A part of an exim's body. Body is Markdown, see renderMarkdown; Heading is
+// plain text and optional.
+type EximSection struct {
+	Heading string `json:"heading"`
+	Body    string `json:"body"`
+}
+
+type EximSections []EximSection
+
+// Records why, when and by whom an exim was soft-deleted. Kind is either
+// "withdrawn" (by its author) or "removed" (by a moderator).
+type EximRemoval struct {
+	Kind      string    `json:"kind"`
+	ByUserId  string    `json:"byUserId"`
+	Reason    string    `json:"reason"`
+	RemovedTs time.Time `json:"removedTs"`
+}
+
+// A prior version of an exim, as it was before the edit made by EditorId.
+type EximRevision struct {
+	RevisionId ulid.ULID `json:"revisionId"`
+	EditorId   string    `json:"editorId"`
+	// EditorName is only set on read, see participant.
+	EditorName string    `json:"editorName,omitempty"`
+	EditedTs   time.Time `json:"editedTs"`
+	Exim       Exim      `json:"exim"`
+}
+
+type EximRevisions []EximRevision
+
+var errEximNotFound = errors.New("exim does not exist")
+var errEximNotPermitted = errors.New("user is not permitted to modify this exim")
+var errEximRemoved = errors.New("exim has been withdrawn or removed")
+
+// Buckets whose keys are prefixed with an eximId, which must be cleaned up
+// when an exim is purged.
+var eximChildBuckets = []string{"MOD_EXIM_REV", "MOD_EXIM_STATE", "MOD_EXIM_OUTCOME", "MOD_EXIM_SUPPORT", "MOD_EXIM_ABSTAIN", "MOD_EXIM_COMMENT", "MOD_EXIM_PETITION", "PETITION_SIGNATURE"}
+
+const maxRemovalReasonChars = 500
+
+// Per-field length limits, in characters.
+const maxEximTargetChars = 100
+const maxEximTitleChars = 120
+const maxEximSummaryChars = 500
+const maxEximSectionHeadingChars = 120
+const maxEximSectionBodyChars = 5000
+const maxEximSections = 20
+const maxEximLinkChars = 2048
+
 // Writes Exim to db.
 func (e *Exim) createEximTx(binId []byte) error {
 	// Marshal Exim to be stored.
@@ -35,37 +105,440 @@ func (e *Exim) createEximTx(binId []byte) error {
 		// Retrieve buckets.
 		eb := tx.Bucket([]byte("MOD_EXIM"))
 
+		if err := e.checkTagsExist(tx); err != nil {
+			return err
+		}
+		if err := e.checkChapterExists(tx); err != nil {
+			return err
+		}
+
 		// Write key/value pair.
 		if err := eb.Put(binId, eximJs); err != nil {
 			return err
 		}
+		if err := indexExim(tx, binId, nil, e); err != nil {
+			return err
+		}
+		if err := indexEximTags(tx, binId, nil, e); err != nil {
+			return err
+		}
+		if err := putSupportIndex(tx, binId, 0); err != nil {
+			return err
+		}
 
-		return nil
+		// Log initial state.
+		return putEximTransition(tx, binId, EximTransition{
+			To:       e.State,
+			ByUserId: e.Author,
+		})
 	})
 }
 
-func (e *Exims) getEximsTx() error {
+// Assigns a new ULID and author to the receiver and writes it to db, in the
+// receiver's State (draft or submitted, defaults to submitted). Shared by
+// handleCreateExim and ssrCreateEximPost.
+func (e *Exim) create(author ulid.ULID) error {
+	// Create ULID and db key(s).
+	id, binId := createUlid()
+
+	e.EximId = id
+	e.Author = author.String()
+	if e.State != eximStateDraft {
+		e.State = eximStateSubmitted
+	}
+
+	// Execute db transaction.
+	return e.createEximTx(binId)
+}
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (e *Exim) normalize() {
+	e.Target = normalizeText(e.Target, false)
+	e.Title = normalizeText(e.Title, false)
+	e.Summary = normalizeText(e.Summary, true)
+	e.Sections = e.Sections.normalize()
+	e.Link = normalizeText(e.Link, false)
+	e.Tags = normalizeTags(e.Tags)
+	e.Chapter = strings.ToLower(strings.TrimSpace(e.Chapter))
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. An empty map means the exim is valid. Fields should
+// be normalized first.
+func (e *Exim) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	required := []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"target", e.Target, maxEximTargetChars},
+		{"title", e.Title, maxEximTitleChars},
+		{"summary", e.Summary, maxEximSummaryChars},
+	}
+	for _, f := range required {
+		if isBlank(f.value) {
+			fieldErrors[f.name] = "This field cannot be blank."
+		} else if !maxChars(f.value, f.max) {
+			fieldErrors[f.name] = fmt.Sprintf("This field cannot be more than %d characters long.", f.max)
+		}
+	}
+
+	// Sections are named by index, e.g. "sections.0.body".
+	if len(e.Sections) == 0 {
+		fieldErrors["sections"] = "This field must have at least one section."
+	} else if len(e.Sections) > maxEximSections {
+		fieldErrors["sections"] = fmt.Sprintf("This field cannot have more than %d sections.", maxEximSections)
+	}
+	for i, section := range e.Sections {
+		if !maxChars(section.Heading, maxEximSectionHeadingChars) {
+			fieldErrors[fmt.Sprintf("sections.%d.heading", i)] = fmt.Sprintf("This field cannot be more than %d characters long.", maxEximSectionHeadingChars)
+		}
+		if isBlank(section.Body) {
+			fieldErrors[fmt.Sprintf("sections.%d.body", i)] = "This field cannot be blank."
+		} else if !maxChars(section.Body, maxEximSectionBodyChars) {
+			fieldErrors[fmt.Sprintf("sections.%d.body", i)] = fmt.Sprintf("This field cannot be more than %d characters long.", maxEximSectionBodyChars)
+		}
+	}
+
+	// Link is optional.
+	if !isBlank(e.Link) {
+		if !maxChars(e.Link, maxEximLinkChars) {
+			fieldErrors["link"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxEximLinkChars)
+		} else if !isAllowedLink(e.Link) {
+			fieldErrors["link"] = "This field must be a full http(s) URL."
+		}
+	}
+
+	// Tags are optional; whether they exist is checked on write.
+	if len(e.Tags) > maxEximTags {
+		fieldErrors["tags"] = fmt.Sprintf("This field cannot have more than %d tags.", maxEximTags)
+	}
+	for _, slug := range e.Tags {
+		if !isTagSlug(slug) {
+			fieldErrors["tags"] = fmt.Sprintf("Unknown tag (%s).", slug)
+		}
+	}
+
+	return fieldErrors
+}
+
+// Normalizes each section (see normalizeText), dropping empty sections.
+func (ss EximSections) normalize() EximSections {
+	var normalized EximSections
+	for _, section := range ss {
+		section.Heading = normalizeText(section.Heading, false)
+		section.Body = normalizeText(section.Body, true)
+		if section.Heading != "" || section.Body != "" {
+			normalized = append(normalized, section)
+		}
+	}
+	return normalized
+}
+
+// Describes which page of exims to list, see getEximPageTx. After and Before
+// are mutually exclusive; without either, the first page is listed.
+type EximQuery struct {
+	Limit  int
+	After  *eximCursor
+	Before *eximCursor
+	Sort   string
+	Author string
+	Target string
+	Tag    string
+	States []string
+	// Chapter lists exims scoped to the chapter, or by its members.
+	Chapter string
+	// chapterMembers are the userIds of the Chapter's members, see
+	// getEximPageTx.
+	chapterMembers map[string]bool
+	// Viewer is the userId of the member listing exims (empty if not logged
+	// in). Drafts are only listed to their author and to moderators.
+	Viewer string
+	// isModerator is set from Viewer, see getEximPageTx.
+	isModerator bool
+}
+
+// A page of exims, with cursors to the adjacent pages (empty if none).
+type EximPage struct {
+	Exims      Exims  `json:"exims"`
+	NextCursor string `json:"nextCursor"`
+	PrevCursor string `json:"prevCursor"`
+}
+
+// Sort orders for listing exims.
+const eximSortNewest = "newest"
+const eximSortOldest = "oldest"
+const eximSortSupported = "supported"
+
+const defaultEximPageLimit = 20
+const maxEximPageLimit = 100
+
+// Position of an exim within a sort order. Support only matters when sorting
+// by most supported.
+type eximCursor struct {
+	Support int
+	EximId  ulid.ULID
+}
+
+// Encodes the cursor as an opaque string.
+func (c *eximCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%s", c.Support, c.EximId)))
+}
+
+// Reverses eximCursor.String.
+func parseEximCursor(s string) (*eximCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.Split(string(raw), ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	support, err := strconv.Atoi(parts[0])
+	if err != nil || support < 0 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	id, err := ulid.ParseStrict(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &eximCursor{Support: support, EximId: id}, nil
+}
+
+// Returns true if the exim should be listed for the query. Withdrawn and
+// removed exims are never listed, and drafts only if asked for by state (and
+// visible to the viewer, see checkVisibleTx).
+func (q *EximQuery) matches(exim *Exim) bool {
+	if exim.Removal != nil {
+		return false
+	}
+	if exim.State == eximStateDraft && (len(q.States) == 0 || exim.Author != q.Viewer && !q.isModerator) {
+		return false
+	}
+	if len(q.States) > 0 && !slices.Contains(q.States, exim.State) {
+		return false
+	}
+	// Anonymous exims are not listed by author, which would identify it.
+	if q.Author != "" && (exim.Author != q.Author || exim.IsAnonymous) {
+		return false
+	}
+	if q.Target != "" && !strings.EqualFold(exim.Target, q.Target) {
+		return false
+	}
+	if q.Tag != "" && !slices.Contains(exim.Tags, q.Tag) {
+		return false
+	}
+	// As with filtering by author, anonymous exims are not listed by their
+	// author's chapter.
+	if q.Chapter != "" && exim.Chapter != q.Chapter && (exim.IsAnonymous || !q.chapterMembers[exim.Author]) {
+		return false
+	}
+	return true
+}
+
+// Reads one page of exims matching the query, see pageByIndex.
+func (p *EximPage) getEximPageTx(q EximQuery) error {
 	return db.View(func(tx *bolt.Tx) error {
-		// Retrieve bucket.
-		eb := tx.Bucket([]byte("MOD_EXIM"))
+		var items Exims
+		var hasNext, hasPrev bool
+		var err error
 
-		// Iterate over exims.
-		return eb.ForEach(func(k, v []byte) error {
-			// Unmarshal value to Exim.
-			var exim Exim
-			err := json.Unmarshal(v, &exim)
+		if q.Chapter != "" {
+			if q.chapterMembers, err = getChapterMembers(tx, q.Chapter); err != nil {
+				return err
+			}
+		}
+		if q.Viewer != "" {
+			_, viewerBinId, err := parseUlidString(q.Viewer)
 			if err != nil {
 				return err
 			}
+			q.isModerator = isModerator(tx, viewerBinId)
+		}
 
-			// Append to slice.
-			*e = append(*e, exim)
+		items, hasNext, hasPrev, err = q.pageByIndex(tx)
+		if err != nil {
+			return err
+		}
 
-			return nil
-		})
+		// Include outcome reports and author names.
+		for i := range items {
+			binId, err := items[i].EximId.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			items[i].Outcomes, err = getEximOutcomes(tx, binId)
+			if err != nil {
+				return err
+			}
+			if err := items[i].present(tx); err != nil {
+				return err
+			}
+		}
+
+		p.Exims = items
+		if len(items) > 0 && hasNext {
+			p.NextCursor = q.cursorFor(&items[len(items)-1])
+		}
+		if len(items) > 0 && hasPrev {
+			p.PrevCursor = q.cursorFor(&items[0])
+		}
+		return nil
 	})
 }
 
+func (q *EximQuery) cursorFor(exim *Exim) string {
+	c := eximCursor{EximId: exim.EximId}
+	if q.Sort == eximSortSupported {
+		c.Support = exim.SupportCount
+	}
+	return c.String()
+}
+
+// Pages through an index of exims with a cursor, so only the page itself (and
+// any non-matching exims in between) is read:
+//   - by time, MOD_EXIM in key order (oldest) or reverse key order (newest).
+//     When filtering by tag, the tag's entries in MOD_EXIM_TAG (which share
+//     that order) are paged through instead.
+//   - by most supported, MOD_EXIM_SUPPORT_RANK in reverse key order, see
+//     supportRankKey.
+func (q *EximQuery) pageByIndex(tx *bolt.Tx) (Exims, bool, bool, error) {
+	forward := q.Sort == eximSortOldest
+	bySupport := q.Sort == eximSortSupported
+
+	eb := tx.Bucket([]byte("MOD_EXIM"))
+	b, prefix := eb, []byte{}
+	switch {
+	case bySupport:
+		b = tx.Bucket([]byte("MOD_EXIM_SUPPORT_RANK"))
+	case q.Tag != "":
+		b, prefix = tx.Bucket([]byte("MOD_EXIM_TAG")), tagPrefix(q.Tag)
+	}
+
+	// Scans up to n matching exims strictly after key (or from the start, if
+	// key is nil) in the provided direction.
+	scan := func(key []byte, ascending bool, n int) (Exims, error) {
+		var found Exims
+		c := b.Cursor()
+
+		var k []byte
+		switch {
+		case key == nil && ascending:
+			k, _ = c.Seek(prefix)
+		case key == nil:
+			// Seek past the last key with the prefix, then step back.
+			if end := prefixEnd(prefix); end == nil {
+				k, _ = c.Last()
+			} else if k, _ = c.Seek(end); k == nil {
+				k, _ = c.Last()
+			} else {
+				k, _ = c.Prev()
+			}
+		case ascending:
+			key = compositeKey(prefix, key)
+			k, _ = c.Seek(key)
+			if k != nil && bytes.Equal(k, key) {
+				k, _ = c.Next()
+			}
+		default:
+			// Seek finds the first key >= key, so step back from it.
+			if k, _ = c.Seek(compositeKey(prefix, key)); k == nil {
+				k, _ = c.Last()
+			} else {
+				k, _ = c.Prev()
+			}
+		}
+
+		for ; k != nil && bytes.HasPrefix(k, prefix) && len(found) < n; k, _ = stepCursor(c, ascending) {
+			eximBinId := k[len(prefix):]
+			if bySupport {
+				eximBinId = k[supportRankBytes:]
+			}
+			var exim Exim
+			if err := json.Unmarshal(eb.Get(eximBinId), &exim); err != nil {
+				return nil, err
+			}
+			if !q.matches(&exim) {
+				continue
+			}
+			exim.SupportCount = getIndexedSupport(tx, eximBinId)
+			found = append(found, exim)
+		}
+		return found, nil
+	}
+
+	keyOf := func(c *eximCursor) []byte {
+		if c == nil {
+			return nil
+		}
+		binId, _ := c.EximId.MarshalBinary()
+		if bySupport {
+			return supportRankKey(c.Support, binId)
+		}
+		return binId
+	}
+	exists := func(exim *Exim, ascending bool) (bool, error) {
+		found, err := scan(keyOf(&eximCursor{Support: exim.SupportCount, EximId: exim.EximId}), ascending, 1)
+		return len(found) > 0, err
+	}
+
+	if q.Before != nil {
+		// Scan backwards from the cursor, then restore sort order.
+		items, err := scan(keyOf(q.Before), !forward, q.Limit+1)
+		if err != nil {
+			return nil, false, false, err
+		}
+		hasPrev := len(items) > q.Limit
+		if hasPrev {
+			items = items[:q.Limit]
+		}
+		slices.Reverse(items)
+		hasNext := false
+		if len(items) > 0 {
+			hasNext, err = exists(&items[len(items)-1], forward)
+		}
+		return items, hasNext, hasPrev, err
+	}
+
+	items, err := scan(keyOf(q.After), forward, q.Limit+1)
+	if err != nil {
+		return nil, false, false, err
+	}
+	hasNext := len(items) > q.Limit
+	if hasNext {
+		items = items[:q.Limit]
+	}
+	hasPrev := false
+	if len(items) > 0 && q.After != nil {
+		hasPrev, err = exists(&items[0], !forward)
+	}
+	return items, hasNext, hasPrev, err
+}
+
+// Returns the first key after every key prefixed with prefix, or nil if there
+// is no such key (i.e. the prefix is empty or all 0xff bytes).
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Moves the cursor one key in the provided direction.
+func stepCursor(c *bolt.Cursor, ascending bool) ([]byte, []byte) {
+	if ascending {
+		return c.Next()
+	}
+	return c.Prev()
+}
+
 func (e *Exim) getEximDetailsTx(eximBinId []byte) error {
 	return db.View(func(tx *bolt.Tx) error {
 		// Retrieve bucket.
@@ -74,7 +547,7 @@ func (e *Exim) getEximDetailsTx(eximBinId []byte) error {
 		// Retrieve exim.
 		eximBytes := eb.Get(eximBinId)
 		if eximBytes == nil {
-			return fmt.Errorf("exim does not exist")
+			return errEximNotFound
 		}
 
 		// Unmarshal value to receiver.
@@ -83,6 +556,421 @@ func (e *Exim) getEximDetailsTx(eximBinId []byte) error {
 			return err
 		}
 
+		// Include outcome reports and support.
+		e.Outcomes, err = getEximOutcomes(tx, eximBinId)
+		if err != nil {
+			return err
+		}
+		e.SupportCount = getIndexedSupport(tx, eximBinId)
+		return nil
+	})
+}
+
+// Returns errEximNotFound if the receiver is a draft which the viewer (the zero
+// ULID if not logged in) may not see, so that drafts can't be discovered.
+// Drafts are only visible to their author and to moderators.
+func (e *Exim) checkVisibleTx(viewerId ulid.ULID) error {
+	if e.State != eximStateDraft || e.Author == viewerId.String() {
+		return nil
+	}
+	return db.View(func(tx *bolt.Tx) error {
+		viewerBinId, err := viewerId.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if viewerId == (ulid.ULID{}) || !isModerator(tx, viewerBinId) {
+			return errEximNotFound
+		}
+		return nil
+	})
+}
+
+// Returns true if the content of the two exims differs by more than whitespace.
+func (e *Exim) isSubstantivelyDifferent(other *Exim) bool {
+	fields := func(x *Exim) []string {
+		fields := []string{x.Target, x.Title, x.Summary, x.Link}
+		for _, section := range x.Sections {
+			fields = append(fields, section.Heading, section.Body)
+		}
+		return fields
+	}
+	a, b := fields(e), fields(other)
+	if len(a) != len(b) {
+		return true
+	}
+	for i := range a {
+		if strings.Join(strings.Fields(a[i]), " ") != strings.Join(strings.Fields(b[i]), " ") {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces the stored exim's content with the receiver's content, keeping the
+// prior version in MOD_EXIM_REV, but keeping the stored chapter if
+// keepChapter is set. Only the author or a moderator may edit. A substantive
+// change resets approval. On success, the receiver is set to the updated exim.
+func (e *Exim) editEximTx(eximBinId []byte, editorId ulid.ULID, isModerator bool, keepChapter bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		// Retrieve buckets.
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		rb := tx.Bucket([]byte("MOD_EXIM_REV"))
+
+		// Retrieve current exim.
+		eximBytes := eb.Get(eximBinId)
+		if eximBytes == nil {
+			return errEximNotFound
+		}
+		var current Exim
+		if err := json.Unmarshal(eximBytes, &current); err != nil {
+			return err
+		}
+
+		if current.Author != editorId.String() && !isModerator {
+			return errEximNotPermitted
+		}
+		if current.Removal != nil {
+			return errEximRemoved
+		}
+
+		// Tags are kept unless provided, and likewise the chapter.
+		if e.Tags == nil {
+			e.Tags = current.Tags
+		}
+		if keepChapter {
+			e.Chapter = current.Chapter
+		}
+
+		// Nothing to do if content is identical.
+		if current.Target == e.Target && current.Chapter == e.Chapter && current.Title == e.Title && current.Summary == e.Summary &&
+			slices.Equal(current.Sections, e.Sections) && current.Link == e.Link && slices.Equal(current.Tags, e.Tags) {
+			*e = current
+			return nil
+		}
+
+		// Write prior version as a revision.
+		revId, revBinId := createUlid()
+		rev := EximRevision{
+			RevisionId: revId,
+			EditorId:   editorId.String(),
+			EditedTs:   time.Now(),
+			Exim:       current,
+		}
+		revJs, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+		if err := rb.Put(compositeKey(eximBinId, revBinId), revJs); err != nil {
+			return err
+		}
+
+		// Update content, keeping identity and author.
+		updated := current
+		updated.Target = e.Target
+		updated.Title = e.Title
+		updated.Summary = e.Summary
+		updated.Sections = e.Sections
+		updated.Link = e.Link
+		updated.Tags = e.Tags
+		updated.Chapter = e.Chapter
+		if err := updated.checkTagsExist(tx); err != nil {
+			return err
+		}
+		if err := updated.checkChapterExists(tx); err != nil {
+			return err
+		}
+		// Approval was of the prior content, so must be given again.
+		if updated.State == eximStateApproved && current.isSubstantivelyDifferent(&updated) {
+			updated.State = eximStateSubmitted
+			err := putEximTransition(tx, eximBinId, EximTransition{
+				From:     eximStateApproved,
+				To:       eximStateSubmitted,
+				ByUserId: editorId.String(),
+				Note:     "approval reset by substantive edit",
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		eximJs, err := json.Marshal(updated)
+		if err != nil {
+			return err
+		}
+		if err := eb.Put(eximBinId, eximJs); err != nil {
+			return err
+		}
+		if err := indexExim(tx, eximBinId, &current, &updated); err != nil {
+			return err
+		}
+		if err := indexEximTags(tx, eximBinId, &current, &updated); err != nil {
+			return err
+		}
+		// Tags decide whose delegations apply.
+		if !slices.Equal(current.Tags, updated.Tags) {
+			if err := indexEximSupport(tx, eximBinId); err != nil {
+				return err
+			}
+		}
+
+		*e = updated
 		return nil
 	})
 }
+
+// Reads all revisions of an exim, oldest first.
+func (r *EximRevisions) getEximRevisionsTx(eximBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		// Retrieve buckets.
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		rb := tx.Bucket([]byte("MOD_EXIM_REV"))
+
+		eximBytes := eb.Get(eximBinId)
+		if eximBytes == nil {
+			return errEximNotFound
+		}
+		var exim Exim
+		if err := json.Unmarshal(eximBytes, &exim); err != nil {
+			return err
+		}
+
+		// Iterate over keys prefixed with eximId.
+		c := rb.Cursor()
+		for k, v := c.Seek(eximBinId); k != nil && bytes.HasPrefix(k, eximBinId); k, v = c.Next() {
+			var rev EximRevision
+			if err := json.Unmarshal(v, &rev); err != nil {
+				return err
+			}
+			var err error
+			rev.EditorId, rev.EditorName, err = exim.participant(tx, rev.EditorId)
+			if err != nil {
+				return err
+			}
+			if err := rev.Exim.present(tx); err != nil {
+				return err
+			}
+			*r = append(*r, rev)
+		}
+		return nil
+	})
+}
+
+// Clears the content of a withdrawn or removed exim, leaving a tombstone which
+// only identifies the exim and explains its removal.
+func (e *Exim) tombstone() {
+	if e.Removal == nil {
+		return
+	}
+	e.Target = ""
+	e.Title = ""
+	e.Summary = ""
+	e.Sections = nil
+	e.Link = ""
+	e.Tags = nil
+	e.Outcomes = nil
+}
+
+// Soft-deletes an exim by setting its Removal. If the removal is a withdrawal,
+// only the author may make it. On success, the receiver is set to the exim.
+func (e *Exim) removeEximTx(eximBinId []byte, removal EximRemoval) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return e.applyRemoval(tx, eximBinId, removal, "")
+	})
+}
+
+// Soft-deletes an exim within an existing db transaction, see removeEximTx.
+// Removals by moderators are audited, along with the report (if any) which
+// prompted them.
+func (e *Exim) applyRemoval(tx *bolt.Tx, eximBinId []byte, removal EximRemoval, reportId string) error {
+	// Retrieve bucket.
+	eb := tx.Bucket([]byte("MOD_EXIM"))
+
+	// Retrieve current exim.
+	eximBytes := eb.Get(eximBinId)
+	if eximBytes == nil {
+		return errEximNotFound
+	}
+	if err := json.Unmarshal(eximBytes, e); err != nil {
+		return err
+	}
+
+	if removal.Kind == "withdrawn" && e.Author != removal.ByUserId {
+		return errEximNotPermitted
+	}
+	if e.Removal != nil {
+		return errEximRemoved
+	}
+
+	// Removed content is no longer searchable or browsable by tag.
+	if err := indexExim(tx, eximBinId, e, nil); err != nil {
+		return err
+	}
+	if err := indexEximTags(tx, eximBinId, e, nil); err != nil {
+		return err
+	}
+
+	if removal.Kind == "removed" {
+		err := writeAudit(tx, AuditEntry{
+			ActorId:     removal.ByUserId,
+			Action:      auditActionEximRemove,
+			SubjectKind: "exim",
+			SubjectId:   e.EximId.String(),
+			ReportId:    reportId,
+			Note:        removal.Reason,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	e.Removal = &removal
+	eximJs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return eb.Put(eximBinId, eximJs)
+}
+
+// Permanently deletes an exim and everything keyed by its eximId, and audits
+// the purge by the admin.
+func (e *Exim) purgeEximTx(eximBinId []byte, adminId ulid.ULID, reason string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		// Retrieve bucket.
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+
+		eximBytes := eb.Get(eximBinId)
+		if eximBytes == nil {
+			return errEximNotFound
+		}
+		if err := json.Unmarshal(eximBytes, e); err != nil {
+			return err
+		}
+		if err := eb.Delete(eximBinId); err != nil {
+			return err
+		}
+		// Withdrawn or removed exims were unindexed on removal.
+		if e.Removal == nil {
+			if err := indexExim(tx, eximBinId, e, nil); err != nil {
+				return err
+			}
+			if err := indexEximTags(tx, eximBinId, e, nil); err != nil {
+				return err
+			}
+		}
+		if err := unindexEximSupport(tx, eximBinId); err != nil {
+			return err
+		}
+
+		// Delete children.
+		for _, bucket := range eximChildBuckets {
+			b := tx.Bucket([]byte(bucket))
+			// Collect keys first, since deleting while iterating is not permitted.
+			var keys [][]byte
+			c := b.Cursor()
+			for k, _ := c.Seek(eximBinId); k != nil && bytes.HasPrefix(k, eximBinId); k, _ = c.Next() {
+				keys = append(keys, bytes.Clone(k))
+			}
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+
+		return writeAudit(tx, AuditEntry{
+			ActorId:     adminId.String(),
+			Action:      auditActionEximPurge,
+			SubjectKind: "exim",
+			SubjectId:   e.EximId.String(),
+			Note:        reason,
+		})
+	})
+}
+
+// Replaces the three fixed paragraphs of exims (and their revisions) stored
+// before sections existed with one section per non-empty paragraph. Runs at
+// startup, before any other migration reads exims; records which already have
+// sections are untouched.
+func migrateEximSectionsTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{"MOD_EXIM", "MOD_EXIM_REV"} {
+			b := tx.Bucket([]byte(bucket))
+
+			// Collect updates first, since writing while iterating with
+			// ForEach is not permitted.
+			updates := map[string][]byte{}
+			err := b.ForEach(func(k, v []byte) error {
+				var record map[string]json.RawMessage
+				if err := json.Unmarshal(v, &record); err != nil {
+					return err
+				}
+
+				// Revisions hold the prior exim in their "exim" field.
+				exim := record
+				if bucket == "MOD_EXIM_REV" {
+					exim = nil
+					if err := json.Unmarshal(record["exim"], &exim); err != nil {
+						return err
+					}
+				}
+				changed, err := migrateLegacyParagraphs(exim)
+				if err != nil || !changed {
+					return err
+				}
+				if bucket == "MOD_EXIM_REV" {
+					if record["exim"], err = json.Marshal(exim); err != nil {
+						return err
+					}
+				}
+
+				recordJs, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+				updates[string(k)] = recordJs
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for k, v := range updates {
+				if err := b.Put([]byte(k), v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Converts the paragraph1..3 fields of a raw exim into sections, returning
+// true if the exim had paragraphs.
+func migrateLegacyParagraphs(exim map[string]json.RawMessage) (bool, error) {
+	if _, ok := exim["sections"]; ok {
+		return false, nil
+	}
+
+	var sections EximSections
+	for _, field := range []string{"paragraph1", "paragraph2", "paragraph3"} {
+		raw, ok := exim[field]
+		if !ok {
+			continue
+		}
+		var paragraph string
+		if err := json.Unmarshal(raw, &paragraph); err != nil {
+			return false, err
+		}
+		if !isBlank(paragraph) {
+			sections = append(sections, EximSection{Body: paragraph})
+		}
+		delete(exim, field)
+	}
+
+	sectionsJs, err := json.Marshal(sections)
+	if err != nil {
+		return false, err
+	}
+	exim["sections"] = sectionsJs
+	return true, nil
+}
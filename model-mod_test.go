@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"slices"
+	"testing"
+
+	"github.com/oklog/ulid"
+)
+
+func TestEximCursor(t *testing.T) {
+	id := ulid.MustParse("01HQZ0000000000000000000AB")
+
+	tests := []struct {
+		name   string
+		cursor eximCursor
+	}{
+		{"by time", eximCursor{EximId: id}},
+		{"by support", eximCursor{Support: 42, EximId: id}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEximCursor(tt.cursor.String())
+			if err != nil {
+				t.Fatalf("parseEximCursor(%q) error: %v", tt.cursor.String(), err)
+			}
+			if *got != tt.cursor {
+				t.Errorf("parseEximCursor(%q) = %+v, want %+v", tt.cursor.String(), *got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestParseEximCursorInvalid(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name string
+		s    string
+	}{
+		{"not base64", "!!"},
+		{"no separator", encode("01HQZ0000000000000000000AB")},
+		{"too many parts", encode("1.01HQZ0000000000000000000AB.2")},
+		{"support not a number", encode("x.01HQZ0000000000000000000AB")},
+		{"negative support", encode("-1.01HQZ0000000000000000000AB")},
+		{"invalid id", encode("1.not-an-id")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c, err := parseEximCursor(tt.s); err == nil {
+				t.Errorf("parseEximCursor(%q) = %+v, want error", tt.s, *c)
+			}
+		})
+	}
+}
+
+func TestSupportRankKeyOrder(t *testing.T) {
+	older, _ := ulid.MustParse("01HQZ0000000000000000000AA").MarshalBinary()
+	newer, _ := ulid.MustParse("01HQZ0000000000000000000AB").MarshalBinary()
+
+	// Listed by most supported, then newest, in reverse key order.
+	want := [][]byte{
+		supportRankKey(256, older),
+		supportRankKey(2, newer),
+		supportRankKey(2, older),
+		supportRankKey(1, newer),
+		supportRankKey(0, newer),
+		supportRankKey(0, older),
+	}
+	got := slices.Clone(want)
+	slices.SortFunc(got, func(a, b []byte) int { return bytes.Compare(b, a) })
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("reverse key order = %x, want %x", got, want)
+		}
+	}
+
+	if !bytes.Equal(supportRankKey(2, newer)[supportRankBytes:], newer) {
+		t.Errorf("supportRankKey does not end with the eximId")
+	}
+}
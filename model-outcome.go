@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Outcome verdicts.
+var outcomeVerdicts = []string{"succeeded", "failed", "inconclusive"}
+
+// Per-field length limits, in characters, and list size limits.
+const maxOutcomeTextChars = 5000
+const maxOutcomeMetricChars = 200
+const maxOutcomeMetrics = 20
+const maxOutcomeEvidenceLinks = 10
+
+var errEximNotConcluded = errors.New("outcomes may only be reported for concluded exims")
+
+// A structured report of whether an exim (an experiment) worked.
+type Outcome struct {
+	OutcomeId     ulid.ULID       `json:"outcomeId"`
+	AuthorId      string          `json:"authorId"`
+	Hypothesis    string          `json:"hypothesis"`
+	Metrics       []OutcomeMetric `json:"metrics"`
+	Results       string          `json:"results"`
+	Verdict       string          `json:"verdict"`
+	Conclusion    string          `json:"conclusion"`
+	EvidenceLinks []string        `json:"evidenceLinks"`
+	CreatedTs     time.Time       `json:"createdTs"`
+}
+
+// A metric measured during the trial, with its value before and after.
+type OutcomeMetric struct {
+	Name     string `json:"name"`
+	Baseline string `json:"baseline"`
+	Result   string `json:"result"`
+}
+
+type Outcomes []Outcome
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (o *Outcome) normalize() {
+	o.Hypothesis = normalizeText(o.Hypothesis, true)
+	o.Results = normalizeText(o.Results, true)
+	o.Verdict = normalizeText(o.Verdict, false)
+	o.Conclusion = normalizeText(o.Conclusion, true)
+	for i := range o.Metrics {
+		o.Metrics[i].Name = normalizeText(o.Metrics[i].Name, false)
+		o.Metrics[i].Baseline = normalizeText(o.Metrics[i].Baseline, false)
+		o.Metrics[i].Result = normalizeText(o.Metrics[i].Result, false)
+	}
+	for i := range o.EvidenceLinks {
+		o.EvidenceLinks[i] = normalizeText(o.EvidenceLinks[i], false)
+	}
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (o *Outcome) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"hypothesis", o.Hypothesis},
+		{"results", o.Results},
+		{"conclusion", o.Conclusion},
+	}
+	for _, f := range required {
+		if isBlank(f.value) {
+			fieldErrors[f.name] = "This field cannot be blank."
+		} else if !maxChars(f.value, maxOutcomeTextChars) {
+			fieldErrors[f.name] = fmt.Sprintf("This field cannot be more than %d characters long.", maxOutcomeTextChars)
+		}
+	}
+
+	if !slices.Contains(outcomeVerdicts, o.Verdict) {
+		fieldErrors["verdict"] = "This field must be one of succeeded, failed or inconclusive."
+	}
+
+	if len(o.Metrics) > maxOutcomeMetrics {
+		fieldErrors["metrics"] = fmt.Sprintf("This field cannot have more than %d metrics.", maxOutcomeMetrics)
+	}
+	for i, m := range o.Metrics {
+		name := fmt.Sprintf("metrics[%d]", i)
+		if isBlank(m.Name) {
+			fieldErrors[name] = "Metric name cannot be blank."
+		} else if !maxChars(m.Name, maxOutcomeMetricChars) || !maxChars(m.Baseline, maxOutcomeMetricChars) || !maxChars(m.Result, maxOutcomeMetricChars) {
+			fieldErrors[name] = fmt.Sprintf("Metric values cannot be more than %d characters long.", maxOutcomeMetricChars)
+		}
+	}
+
+	if len(o.EvidenceLinks) > maxOutcomeEvidenceLinks {
+		fieldErrors["evidenceLinks"] = fmt.Sprintf("This field cannot have more than %d links.", maxOutcomeEvidenceLinks)
+	}
+	for i, link := range o.EvidenceLinks {
+		if !maxChars(link, maxEximLinkChars) || !isAllowedLink(link) {
+			fieldErrors[fmt.Sprintf("evidenceLinks[%d]", i)] = "Each link must be a full http(s) URL."
+		}
+	}
+
+	return fieldErrors
+}
+
+// Writes the receiver as an outcome report of the exim, which must be in the
+// concluded state. Only the author or a moderator may report.
+func (o *Outcome) createOutcomeTx(eximBinId []byte, isModerator bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		// Retrieve buckets.
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		ob := tx.Bucket([]byte("MOD_EXIM_OUTCOME"))
+
+		// Retrieve exim.
+		eximBytes := eb.Get(eximBinId)
+		if eximBytes == nil {
+			return errEximNotFound
+		}
+		var exim Exim
+		if err := json.Unmarshal(eximBytes, &exim); err != nil {
+			return err
+		}
+
+		if exim.Author != o.AuthorId && !isModerator {
+			return errEximNotPermitted
+		}
+		if exim.Removal != nil {
+			return errEximRemoved
+		}
+		if exim.State != eximStateConcluded {
+			return errEximNotConcluded
+		}
+
+		id, binId := createUlid()
+		o.OutcomeId = id
+		o.CreatedTs = time.Now()
+
+		// Marshal Outcome to be stored.
+		outcomeJs, err := json.Marshal(o)
+		if err != nil {
+			return err
+		}
+		return ob.Put(compositeKey(eximBinId, binId), outcomeJs)
+	})
+}
+
+// Reads an exim's outcome reports within an existing db transaction, oldest first.
+func getEximOutcomes(tx *bolt.Tx, eximBinId []byte) (Outcomes, error) {
+	var outcomes Outcomes
+	ob := tx.Bucket([]byte("MOD_EXIM_OUTCOME"))
+
+	// Iterate over keys prefixed with eximId.
+	c := ob.Cursor()
+	for k, v := c.Seek(eximBinId); k != nil && bytes.HasPrefix(k, eximBinId); k, v = c.Next() {
+		var outcome Outcome
+		if err := json.Unmarshal(v, &outcome); err != nil {
+			return nil, err
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Petition statuses, which follow from ClosesTs.
+const petitionStatusOpen = "open"
+const petitionStatusClosed = "closed"
+
+const maxPetitionRecipientChars = 200
+
+var errPetitionNotFound = errors.New("exim has no petition")
+var errPetitionClosed = errors.New("petition is closed")
+var errPetitionSigned = errors.New("you have already signed this petition")
+var errSignatureNotFound = errors.New("you have not signed this petition")
+var errSignerNotVerified = errors.New("only verified members may sign petitions")
+var errSignerNoAddress = errors.New("an address is required to sign petitions")
+
+// A formal petition to an external body (Recipient) in support of an exim,
+// which verified members sign until ClosesTs. Petitions are keyed by eximId,
+// and set up by the exim's author or a moderator.
+type Petition struct {
+	EximId    string    `json:"eximId"`
+	Recipient string    `json:"recipient"`
+	ClosesTs  time.Time `json:"closesTs"`
+	CreatedTs time.Time `json:"createdTs"`
+	// The following are only set on read, see getPetition.
+	Status         string             `json:"status"`
+	SignatureCount int                `json:"signatureCount"`
+	Districts      []PetitionDistrict `json:"districts"`
+}
+
+// The number of signatures from a district, i.e. from the members of a
+// chapter. District is empty for signers who were in no chapter.
+type PetitionDistrict struct {
+	District string `json:"district"`
+	Count    int    `json:"count"`
+}
+
+// A member's signature, keyed by eximId + userId. FullName is the legal name
+// the member was verified under (see setVerifiedTx), and Address and District
+// are copied from the member's address and chapter. All are copied when
+// signing, so the list does not change if they move.
+type PetitionSignature struct {
+	FullName string    `json:"fullName"`
+	Address  Address   `json:"address"`
+	District string    `json:"district,omitempty"`
+	SignedTs time.Time `json:"signedTs"`
+}
+
+type PetitionSignatures []PetitionSignature
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (p *Petition) normalize() {
+	p.Recipient = normalizeText(p.Recipient, false)
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (p *Petition) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	if isBlank(p.Recipient) {
+		fieldErrors["recipient"] = "This field cannot be blank."
+	} else if !maxChars(p.Recipient, maxPetitionRecipientChars) {
+		fieldErrors["recipient"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxPetitionRecipientChars)
+	}
+	if !p.ClosesTs.After(time.Now()) {
+		fieldErrors["closesTs"] = "This field must be in the future."
+	}
+
+	return fieldErrors
+}
+
+// Reads an exim's petition, including its status and signature counts, within
+// an existing db transaction.
+func getPetition(tx *bolt.Tx, eximBinId []byte) (Petition, error) {
+	var petition Petition
+	petitionJs := tx.Bucket([]byte("MOD_EXIM_PETITION")).Get(eximBinId)
+	if petitionJs == nil {
+		return petition, errPetitionNotFound
+	}
+	if err := json.Unmarshal(petitionJs, &petition); err != nil {
+		return petition, err
+	}
+	petition.Status = petitionStatusOpen
+	if !time.Now().Before(petition.ClosesTs) {
+		petition.Status = petitionStatusClosed
+	}
+
+	// Count signatures by district in a single pass over the exim's keys,
+	// reading only their district.
+	petition.Districts = []PetitionDistrict{}
+	c := tx.Bucket([]byte("PETITION_SIGNATURE")).Cursor()
+	for k, v := c.Seek(eximBinId); k != nil && bytes.HasPrefix(k, eximBinId); k, v = c.Next() {
+		var signature struct {
+			District string `json:"district"`
+		}
+		if err := json.Unmarshal(v, &signature); err != nil {
+			return petition, err
+		}
+		petition.SignatureCount++
+		i := slices.IndexFunc(petition.Districts, func(d PetitionDistrict) bool { return d.District == signature.District })
+		if i < 0 {
+			petition.Districts = append(petition.Districts, PetitionDistrict{District: signature.District})
+			i = len(petition.Districts) - 1
+		}
+		petition.Districts[i].Count++
+	}
+	slices.SortFunc(petition.Districts, func(a, b PetitionDistrict) int {
+		return strings.Compare(a.District, b.District)
+	})
+	return petition, nil
+}
+
+// Reads an exim's signatures within an existing db transaction, by district
+// and then oldest first.
+func getPetitionSignatures(tx *bolt.Tx, eximBinId []byte) (PetitionSignatures, error) {
+	signatures := PetitionSignatures{}
+	c := tx.Bucket([]byte("PETITION_SIGNATURE")).Cursor()
+	for k, v := c.Seek(eximBinId); k != nil && bytes.HasPrefix(k, eximBinId); k, v = c.Next() {
+		var signature PetitionSignature
+		if err := json.Unmarshal(v, &signature); err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, signature)
+	}
+	slices.SortStableFunc(signatures, func(a, b PetitionSignature) int {
+		if c := strings.Compare(a.District, b.District); c != 0 {
+			return c
+		}
+		return a.SignedTs.Compare(b.SignedTs)
+	})
+	return signatures, nil
+}
+
+func (p *Petition) getPetitionTx(eximBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		petition, err := getPetition(tx, eximBinId)
+		if err != nil {
+			return err
+		}
+		*p = petition
+		return nil
+	})
+}
+
+// Sets up (or updates the recipient and closing time of) an exim's petition,
+// which may not be done once it has closed. Permissions are checked by the
+// handler. On success, the receiver is set to the stored petition.
+func (p *Petition) setPetitionTx(eximBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		var exim Exim
+		if err := getExim(tx, eximBinId, &exim); err != nil {
+			return err
+		}
+		if exim.Removal != nil {
+			return errEximRemoved
+		}
+
+		stored := Petition{
+			EximId:    exim.EximId.String(),
+			Recipient: p.Recipient,
+			ClosesTs:  p.ClosesTs,
+			CreatedTs: time.Now(),
+		}
+		current, err := getPetition(tx, eximBinId)
+		switch {
+		case err == nil && current.Status == petitionStatusClosed:
+			return errPetitionClosed
+		case err == nil:
+			stored.CreatedTs = current.CreatedTs
+		case !errors.Is(err, errPetitionNotFound):
+			return err
+		}
+
+		petitionJs, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte("MOD_EXIM_PETITION")).Put(eximBinId, petitionJs); err != nil {
+			return err
+		}
+
+		petition, err := getPetition(tx, eximBinId)
+		*p = petition
+		return err
+	})
+}
+
+// Signs an open petition as a verified member, with the legal name they were
+// verified under and the address they have set (see updateAddressTx).
+func (s *PetitionSignature) signPetitionTx(eximBinId []byte, userBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		sb := tx.Bucket([]byte("PETITION_SIGNATURE"))
+
+		var exim Exim
+		if err := getExim(tx, eximBinId, &exim); err != nil {
+			return err
+		}
+		if exim.Removal != nil {
+			return errEximRemoved
+		}
+		petition, err := getPetition(tx, eximBinId)
+		if err != nil {
+			return err
+		}
+		if petition.Status == petitionStatusClosed {
+			return errPetitionClosed
+		}
+		if !isVerified(tx, userBinId) {
+			return errSignerNotVerified
+		}
+		legalName, err := getLegalName(tx, userBinId)
+		if err != nil {
+			return err
+		}
+		location, err := getMemberLocation(tx, userBinId)
+		if err != nil {
+			return err
+		}
+		if location.Address == nil {
+			return errSignerNoAddress
+		}
+		key := compositeKey(eximBinId, userBinId)
+		if sb.Get(key) != nil {
+			return errPetitionSigned
+		}
+
+		s.FullName = legalName
+		s.Address = *location.Address
+		s.District = ""
+		if location.Chapter != nil {
+			s.District = location.Chapter.Slug
+		}
+		s.SignedTs = time.Now()
+		signatureJs, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		return sb.Put(key, signatureJs)
+	})
+}
+
+// Withdraws the member's signature from a petition which has not closed.
+func withdrawSignatureTx(eximBinId []byte, userBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		sb := tx.Bucket([]byte("PETITION_SIGNATURE"))
+
+		petition, err := getPetition(tx, eximBinId)
+		if err != nil {
+			return err
+		}
+		if petition.Status == petitionStatusClosed {
+			return errPetitionClosed
+		}
+		key := compositeKey(eximBinId, userBinId)
+		if sb.Get(key) == nil {
+			return errSignatureNotFound
+		}
+		return sb.Delete(key)
+	})
+}
+
+// Reads a petition and every signature on it, for submission to the
+// recipient, and audits the export by the moderator, since signatures hold
+// signers' legal names and addresses. Permissions are checked by the handler.
+func (p *Petition) exportPetitionSignaturesTx(eximBinId []byte, moderatorId ulid.ULID, format string) (PetitionSignatures, error) {
+	var signatures PetitionSignatures
+	err := db.Update(func(tx *bolt.Tx) error {
+		petition, err := getPetition(tx, eximBinId)
+		if err != nil {
+			return err
+		}
+		*p = petition
+		signatures, err = getPetitionSignatures(tx, eximBinId)
+		if err != nil {
+			return err
+		}
+		return writeAudit(tx, AuditEntry{
+			ActorId:     moderatorId.String(),
+			Action:      auditActionPetitionExport,
+			SubjectKind: "exim",
+			SubjectId:   petition.EximId,
+			Detail:      format,
+		})
+	})
+	return signatures, err
+}
+
+// Writes the signatures as CSV, one row per signature after a header row.
+func (ss PetitionSignatures) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	rows := [][]string{{"full_name", "address_line1", "address_line2", "city", "region", "postal_code", "country", "district", "signed_ts"}}
+	for _, s := range ss {
+		rows = append(rows, []string{
+			csvCell(s.FullName),
+			csvCell(s.Address.Line1),
+			csvCell(s.Address.Line2),
+			csvCell(s.Address.City),
+			csvCell(s.Address.Region),
+			csvCell(s.Address.PostalCode),
+			csvCell(s.Address.Country),
+			s.District,
+			s.SignedTs.UTC().Format(time.RFC3339),
+		})
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Escapes user-provided text which spreadsheets would read as a formula.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	cryptoRand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Poll methods, see PollResults.
+const pollMethodApproval = "approval"
+const pollMethodInstantRunoff = "instant-runoff"
+const pollMethodSchulze = "schulze"
+
+var pollMethods = []string{pollMethodApproval, pollMethodInstantRunoff, pollMethodSchulze}
+
+// Poll statuses, which follow from OpensTs and ClosesTs.
+const pollStatusScheduled = "scheduled"
+const pollStatusOpen = "open"
+const pollStatusClosed = "closed"
+
+const maxPollTitleChars = 200
+const maxPollDescriptionChars = 2000
+const maxPollOptionLabelChars = 100
+const minPollOptions = 2
+const maxPollOptions = 20
+
+var errPollNotFound = errors.New("poll does not exist")
+var errPollNotOpen = errors.New("poll is not open for voting")
+var errPollNotClosed = errors.New("poll results are available once the poll closes")
+var errPollNotEligible = errors.New("you are not eligible to vote in this poll")
+var errPollVoted = errors.New("you have already voted in this poll")
+var errBallotInvalid = errors.New("ballot is invalid")
+
+// A vote between options (e.g. competing exims, or candidates for an office),
+// created by an administrator. Members vote between OpensTs and ClosesTs, once
+// each, if they meet every eligibility rule which is set.
+//
+// Ballots are secret: each is stored under a random key in POLL_BALLOT, with
+// nothing which identifies the voter, while POLL_VOTER records who has voted
+// (keyed by pollId + userId). So a ballot cannot be changed once cast.
+type Poll struct {
+	PollId      ulid.ULID   `json:"pollId"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Method      string      `json:"method"`
+	Options     PollOptions `json:"options"`
+	OpensTs     time.Time   `json:"opensTs"`
+	ClosesTs    time.Time   `json:"closesTs"`
+	// Eligibility rules. Chapter restricts voting to the chapter's members; if
+	// the chapter is deleted, no one is eligible.
+	VerifiedOnly bool      `json:"verifiedOnly"`
+	Chapter      string    `json:"chapter,omitempty"`
+	CreatedTs    time.Time `json:"createdTs"`
+	// Status and BallotCount are only set on read.
+	Status      string `json:"status"`
+	BallotCount int    `json:"ballotCount"`
+}
+
+type Polls []Poll
+
+// An option of a poll, identified on ballots and in results by its index.
+type PollOption struct {
+	Label string `json:"label"`
+	// Optional, the exim the option stands for.
+	EximId string `json:"eximId,omitempty"`
+}
+
+type PollOptions []PollOption
+
+// A member's vote. For approval polls, Choices are the options approved of (in
+// any order); otherwise they are the options in order of preference, and
+// options left out are ranked below every option included.
+type Ballot struct {
+	Choices []int `json:"choices"`
+}
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (p *Poll) normalize() {
+	p.Title = normalizeText(p.Title, false)
+	p.Description = normalizeText(p.Description, true)
+	p.Method = strings.ToLower(normalizeText(p.Method, false))
+	p.Chapter = strings.ToLower(normalizeText(p.Chapter, false))
+	for i := range p.Options {
+		p.Options[i].Label = normalizeText(p.Options[i].Label, false)
+		p.Options[i].EximId = normalizeText(p.Options[i].EximId, false)
+	}
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (p *Poll) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	if isBlank(p.Title) {
+		fieldErrors["title"] = "This field cannot be blank."
+	} else if !maxChars(p.Title, maxPollTitleChars) {
+		fieldErrors["title"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxPollTitleChars)
+	}
+	if !maxChars(p.Description, maxPollDescriptionChars) {
+		fieldErrors["description"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxPollDescriptionChars)
+	}
+	if !slices.Contains(pollMethods, p.Method) {
+		fieldErrors["method"] = fmt.Sprintf("This field must be one of: %v.", pollMethods)
+	}
+	if len(p.Options) < minPollOptions || len(p.Options) > maxPollOptions {
+		fieldErrors["options"] = fmt.Sprintf("This field must have between %d and %d options.", minPollOptions, maxPollOptions)
+	}
+	for i, option := range p.Options {
+		if isBlank(option.Label) {
+			fieldErrors[fmt.Sprintf("options.%d.label", i)] = "This field cannot be blank."
+		} else if !maxChars(option.Label, maxPollOptionLabelChars) {
+			fieldErrors[fmt.Sprintf("options.%d.label", i)] = fmt.Sprintf("This field cannot be more than %d characters long.", maxPollOptionLabelChars)
+		}
+		if _, err := ulid.ParseStrict(option.EximId); option.EximId != "" && err != nil {
+			fieldErrors[fmt.Sprintf("options.%d.eximId", i)] = "This field must be a valid eximId."
+		}
+	}
+	if !p.ClosesTs.After(p.OpensTs) {
+		fieldErrors["closesTs"] = "This field must be after opensTs."
+	} else if !p.ClosesTs.After(time.Now()) {
+		fieldErrors["closesTs"] = "This field must be in the future."
+	}
+	if p.Chapter != "" && !isTagSlug(p.Chapter) {
+		fieldErrors["chapter"] = "This field must be the slug of an existing chapter."
+	}
+
+	return fieldErrors
+}
+
+// Returns the poll's status at the provided time.
+func (p *Poll) status(now time.Time) string {
+	switch {
+	case now.Before(p.OpensTs):
+		return pollStatusScheduled
+	case now.Before(p.ClosesTs):
+		return pollStatusOpen
+	default:
+		return pollStatusClosed
+	}
+}
+
+// Checks that the ballot's choices are valid for the poll: each must be an
+// option, at most once, and there must be at least one.
+func (p *Poll) checkBallot(b *Ballot) error {
+	if len(b.Choices) == 0 {
+		return fmt.Errorf("%w: choose at least one option", errBallotInvalid)
+	}
+	seen := map[int]bool{}
+	for _, choice := range b.Choices {
+		if choice < 0 || choice >= len(p.Options) {
+			return fmt.Errorf("%w: option %d does not exist", errBallotInvalid, choice)
+		}
+		if seen[choice] {
+			return fmt.Errorf("%w: option %d is chosen more than once", errBallotInvalid, choice)
+		}
+		seen[choice] = true
+	}
+	return nil
+}
+
+// Checks that a member meets the poll's eligibility rules within an existing
+// db transaction.
+func (p *Poll) checkEligible(tx *bolt.Tx, userBinId []byte) error {
+	if p.VerifiedOnly && !isVerified(tx, userBinId) {
+		return errPollNotEligible
+	}
+	if p.Chapter != "" && string(tx.Bucket([]byte("USER_CHAPTER")).Get(userBinId)) != p.Chapter {
+		return errPollNotEligible
+	}
+	return nil
+}
+
+// Reads a poll, including its status and ballot count, within an existing db
+// transaction.
+func getPoll(tx *bolt.Tx, pollBinId []byte) (Poll, error) {
+	var poll Poll
+	pollBytes := tx.Bucket([]byte("POLL")).Get(pollBinId)
+	if pollBytes == nil {
+		return poll, errPollNotFound
+	}
+	if err := json.Unmarshal(pollBytes, &poll); err != nil {
+		return poll, err
+	}
+	poll.Status = poll.status(time.Now())
+
+	c := tx.Bucket([]byte("POLL_BALLOT")).Cursor()
+	for k, _ := c.Seek(pollBinId); k != nil && bytes.HasPrefix(k, pollBinId); k, _ = c.Next() {
+		poll.BallotCount++
+	}
+	return poll, nil
+}
+
+// Writes a new poll to db, checking that its exims and chapter exist. A poll
+// without OpensTs opens immediately.
+func (p *Poll) createPollTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		for _, option := range p.Options {
+			if option.EximId == "" {
+				continue
+			}
+			_, eximBinId, err := parseUlidString(option.EximId)
+			if err != nil {
+				return err
+			}
+			if eb.Get(eximBinId) == nil {
+				return fmt.Errorf("%w (%s)", errEximNotFound, option.EximId)
+			}
+		}
+		if p.Chapter != "" {
+			if _, err := getChapter(tx, p.Chapter); err != nil {
+				return err
+			}
+		}
+
+		id, binId := createUlid()
+		p.PollId = id
+		p.CreatedTs = time.Now()
+		if p.OpensTs.IsZero() {
+			p.OpensTs = p.CreatedTs
+		}
+		p.Status = p.status(p.CreatedTs)
+
+		pollJs, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("POLL")).Put(binId, pollJs)
+	})
+}
+
+// Reads every poll, newest first.
+func (ps *Polls) getPollsTx() error {
+	return db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("POLL")).Cursor()
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			poll, err := getPoll(tx, k)
+			if err != nil {
+				return err
+			}
+			*ps = append(*ps, poll)
+		}
+		return nil
+	})
+}
+
+func (p *Poll) getPollTx(pollBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		poll, err := getPoll(tx, pollBinId)
+		if err != nil {
+			return err
+		}
+		*p = poll
+		return nil
+	})
+}
+
+// Deletes a poll, along with its ballots and record of voters.
+func (p *Poll) deletePollTx(pollBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		pb := tx.Bucket([]byte("POLL"))
+		if pb.Get(pollBinId) == nil {
+			return errPollNotFound
+		}
+
+		// Collect keys first, since deleting while iterating is not permitted.
+		for _, name := range []string{"POLL_BALLOT", "POLL_VOTER"} {
+			b := tx.Bucket([]byte(name))
+			var keys [][]byte
+			c := b.Cursor()
+			for k, _ := c.Seek(pollBinId); k != nil && bytes.HasPrefix(k, pollBinId); k, _ = c.Next() {
+				keys = append(keys, bytes.Clone(k))
+			}
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return pb.Delete(pollBinId)
+	})
+}
+
+// Casts the receiver as the member's ballot in an open poll they are eligible
+// for. The ballot is keyed by pollId + random bytes, so that it cannot be
+// linked to the member (not even by the order of keys).
+func (b *Ballot) castBallotTx(pollBinId []byte, userBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		vb := tx.Bucket([]byte("POLL_VOTER"))
+
+		poll, err := getPoll(tx, pollBinId)
+		if err != nil {
+			return err
+		}
+		if poll.Status != pollStatusOpen {
+			return errPollNotOpen
+		}
+		if err := poll.checkEligible(tx, userBinId); err != nil {
+			return err
+		}
+		voterKey := compositeKey(pollBinId, userBinId)
+		if vb.Get(voterKey) != nil {
+			return errPollVoted
+		}
+		if err := poll.checkBallot(b); err != nil {
+			return err
+		}
+
+		ballotId := make([]byte, 16)
+		if _, err := cryptoRand.Read(ballotId); err != nil {
+			return err
+		}
+		ballotJs, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte("POLL_BALLOT")).Put(compositeKey(pollBinId, ballotId), ballotJs); err != nil {
+			return err
+		}
+		return vb.Put(voterKey, []byte(time.Now().Format(time.RFC3339)))
+	})
+}
+
+// Reports whether the member has voted in a poll, and whether they are
+// eligible to.
+func getPollVoterTx(pollBinId []byte, userBinId []byte) (hasVoted bool, isEligible bool, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		poll, err := getPoll(tx, pollBinId)
+		if err != nil {
+			return err
+		}
+		hasVoted = tx.Bucket([]byte("POLL_VOTER")).Get(compositeKey(pollBinId, userBinId)) != nil
+		isEligible = poll.checkEligible(tx, userBinId) == nil
+		return nil
+	})
+	return hasVoted, isEligible, err
+}
+
+// Tallies a closed poll's ballots into the receiver, see tallyBallots.
+func (r *PollResults) getPollResultsTx(pollBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		poll, err := getPoll(tx, pollBinId)
+		if err != nil {
+			return err
+		}
+		if poll.Status != pollStatusClosed {
+			return errPollNotClosed
+		}
+
+		var ballots []Ballot
+		c := tx.Bucket([]byte("POLL_BALLOT")).Cursor()
+		for k, v := c.Seek(pollBinId); k != nil && bytes.HasPrefix(k, pollBinId); k, v = c.Next() {
+			var ballot Ballot
+			if err := json.Unmarshal(v, &ballot); err != nil {
+				return err
+			}
+			ballots = append(ballots, ballot)
+		}
+
+		*r = tallyBallots(poll.Method, len(poll.Options), ballots)
+		r.PollId = poll.PollId.String()
+		return nil
+	})
+}
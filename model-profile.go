@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Per-field length limits, in characters.
+const maxProfileDisplayNameChars = 50
+const maxProfileBioChars = 500
+const maxProfilePronounsChars = 30
+
+// Names shown in place of a member's display name.
+const anonymousName = "Anonymous"
+const anonymousAuthorName = "Anonymous (author)"
+const deletedMemberName = "Deleted member"
+
+// What a member chooses to show about themselves. Every field is optional.
+type Profile struct {
+	UserId      string    `json:"userId"`
+	DisplayName string    `json:"displayName"`
+	Bio         string    `json:"bio"`
+	Pronouns    string    `json:"pronouns"`
+	UpdatedTs   time.Time `json:"updatedTs"`
+	// Name is the DisplayName, or a placeholder if none is set. Only set on
+	// read, see memberName.
+	Name string `json:"name"`
+}
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (p *Profile) normalize() {
+	p.DisplayName = normalizeText(p.DisplayName, false)
+	p.Bio = normalizeText(p.Bio, true)
+	p.Pronouns = normalizeText(p.Pronouns, false)
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (p *Profile) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	// Names shown in place of display names may not be taken, so that no one
+	// can pass themselves off as the anonymous author of an exim.
+	reserved := false
+	for _, name := range []string{anonymousName, anonymousAuthorName, deletedMemberName} {
+		reserved = reserved || strings.EqualFold(p.DisplayName, name)
+	}
+	if reserved || strings.HasPrefix(strings.ToLower(p.DisplayName), "member ") {
+		fieldErrors["displayName"] = "This display name is reserved, please choose another."
+	} else if !maxChars(p.DisplayName, maxProfileDisplayNameChars) {
+		fieldErrors["displayName"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxProfileDisplayNameChars)
+	}
+	if !maxChars(p.Bio, maxProfileBioChars) {
+		fieldErrors["bio"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxProfileBioChars)
+	}
+	if !maxChars(p.Pronouns, maxProfilePronounsChars) {
+		fieldErrors["pronouns"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxProfilePronounsChars)
+	}
+
+	return fieldErrors
+}
+
+// Reads a member's profile within an existing db transaction. Members who
+// have not set up a profile have an empty one.
+func getProfile(tx *bolt.Tx, userBinId []byte) (Profile, error) {
+	var profile Profile
+	var userId ulid.ULID
+	if err := userId.UnmarshalBinary(userBinId); err != nil {
+		return profile, err
+	}
+	if tx.Bucket([]byte("USER_AUTH")).Get(userBinId) == nil {
+		return profile, errUserNotFound
+	}
+
+	if profileJs := tx.Bucket([]byte("USER_PROFILE")).Get(userBinId); profileJs != nil {
+		if err := json.Unmarshal(profileJs, &profile); err != nil {
+			return profile, err
+		}
+	}
+	profile.UserId = userId.String()
+	profile.Name = profile.DisplayName
+	if profile.Name == "" {
+		profile.Name = placeholderName(profile.UserId)
+	}
+	return profile, nil
+}
+
+// Reads the member's profile into the receiver.
+func (p *Profile) getProfileTx(userBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		profile, err := getProfile(tx, userBinId)
+		if err != nil {
+			return err
+		}
+		*p = profile
+		return nil
+	})
+}
+
+// Replaces the member's profile with the receiver. On success, the receiver
+// is set to the stored profile.
+func (p *Profile) updateProfileTx(userBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("USER_AUTH")).Get(userBinId) == nil {
+			return errUserNotFound
+		}
+
+		stored := Profile{
+			DisplayName: p.DisplayName,
+			Bio:         p.Bio,
+			Pronouns:    p.Pronouns,
+			UpdatedTs:   time.Now(),
+		}
+		profileJs, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte("USER_PROFILE")).Put(userBinId, profileJs); err != nil {
+			return err
+		}
+
+		profile, err := getProfile(tx, userBinId)
+		if err != nil {
+			return err
+		}
+		*p = profile
+		return nil
+	})
+}
+
+// Names members who have not chosen a display name after the end of their
+// userId, e.g. "Member 4XK2QZ".
+func placeholderName(userId string) string {
+	return "Member " + userId[max(len(userId)-6, 0):]
+}
+
+// Returns the name to show for a userId: the member's display name, or a
+// placeholder if they have none or their account was deleted.
+func memberName(tx *bolt.Tx, userId string) (string, error) {
+	var id ulid.ULID
+	if err := id.UnmarshalText([]byte(userId)); err != nil {
+		return deletedMemberName, nil
+	}
+	binId, err := id.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	profile, err := getProfile(tx, binId)
+	if errors.Is(err, errUserNotFound) {
+		return deletedMemberName, nil
+	}
+	return profile.Name, err
+}
+
+// Returns the id and name to show for a userId appearing on an exim (as an
+// editor, commenter and so on). If the exim is anonymous, its author is not
+// identified anywhere on it.
+func (e *Exim) participant(tx *bolt.Tx, userId string) (string, string, error) {
+	if e.IsAnonymous && userId == e.Author {
+		return "", anonymousAuthorName, nil
+	}
+	name, err := memberName(tx, userId)
+	return userId, name, err
+}
+
+// Prepares an exim for showing: sets AuthorName, and hides the author of an
+// anonymous exim. Author is still stored (so that the author may edit and
+// withdraw it), so only call this on exims which are about to be shown.
+func (e *Exim) present(tx *bolt.Tx) error {
+	if e.IsAnonymous {
+		for i := range e.Outcomes {
+			if e.Outcomes[i].AuthorId == e.Author {
+				e.Outcomes[i].AuthorId = ""
+			}
+		}
+		if e.Removal != nil && e.Removal.ByUserId == e.Author {
+			e.Removal.ByUserId = ""
+		}
+		e.Author = ""
+		e.AuthorName = anonymousName
+		return nil
+	}
+
+	var err error
+	e.AuthorName, err = memberName(tx, e.Author)
+	return err
+}
+
+func (e *Exim) presentTx() error {
+	return db.View(func(tx *bolt.Tx) error {
+		return e.present(tx)
+	})
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Kinds of content which may be reported.
+const reportKindExim = "exim"
+const reportKindComment = "comment"
+
+// Reasons a member may give when reporting content. "other" requires details.
+var reportReasons = []string{"spam", "harassment", "hate", "misinformation", "off-topic", "other"}
+
+const reportStatusOpen = "open"
+const reportStatusResolved = "resolved"
+
+// Actions a moderator may take to resolve a report. Every action but dismiss
+// requires a note, which is shown to the author of the reported content.
+const reportActionDismiss = "dismiss"
+const reportActionHide = "hide"
+const reportActionWarn = "warn"
+const reportActionSuspend = "suspend"
+
+var reportActions = []string{reportActionDismiss, reportActionHide, reportActionWarn, reportActionSuspend}
+
+const maxReportDetailsChars = 1000
+const maxReportNoteChars = 500
+const defaultReportLimit = 50
+const maxReportLimit = 200
+
+var errReportNotFound = errors.New("report does not exist")
+var errReportDuplicate = errors.New("user has already reported this content")
+var errReportResolved = errors.New("report has already been resolved")
+
+// A member's report of an exim or comment. While open, a report is listed in
+// the moderation queue (REPORT_OPEN).
+type Report struct {
+	ReportId ulid.ULID `json:"reportId"`
+	Kind     string    `json:"kind"`
+	EximId   string    `json:"eximId"`
+	// CommentId is set if Kind is "comment".
+	CommentId string `json:"commentId,omitempty"`
+	// AuthorId is the author of the reported content.
+	AuthorId   string            `json:"authorId"`
+	ReporterId string            `json:"reporterId"`
+	Reason     string            `json:"reason"`
+	Details    string            `json:"details"`
+	CreatedTs  time.Time         `json:"createdTs"`
+	Status     string            `json:"status"`
+	Resolution *ReportResolution `json:"resolution,omitempty"`
+	// The reported content, only set on read, see getReportsTx.
+	Exim    *Exim    `json:"exim,omitempty"`
+	Comment *Comment `json:"comment,omitempty"`
+}
+
+// Records how, when and by whom a report was resolved.
+type ReportResolution struct {
+	Action   string `json:"action"`
+	ByUserId string `json:"byUserId"`
+	Note     string `json:"note"`
+	// SuspendDays is the length of the suspension, if Action is "suspend".
+	SuspendDays int       `json:"suspendDays,omitempty"`
+	ResolvedTs  time.Time `json:"resolvedTs"`
+}
+
+type Reports []Report
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (r *Report) normalize() {
+	r.Reason = normalizeText(r.Reason, false)
+	r.Details = normalizeText(r.Details, true)
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (r *Report) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	if !slices.Contains(reportReasons, r.Reason) {
+		fieldErrors["reason"] = fmt.Sprintf("This field must be one of: %v.", reportReasons)
+	}
+	if r.Reason == "other" && isBlank(r.Details) {
+		fieldErrors["details"] = "This field cannot be blank when the reason is other."
+	} else if !maxChars(r.Details, maxReportDetailsChars) {
+		fieldErrors["details"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxReportDetailsChars)
+	}
+
+	return fieldErrors
+}
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (rr *ReportResolution) normalize() {
+	rr.Action = normalizeText(rr.Action, false)
+	rr.Note = normalizeText(rr.Note, true)
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (rr *ReportResolution) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	if !slices.Contains(reportActions, rr.Action) {
+		fieldErrors["action"] = fmt.Sprintf("This field must be one of: %v.", reportActions)
+	}
+	if rr.Action != reportActionDismiss && isBlank(rr.Note) {
+		fieldErrors["note"] = "This field cannot be blank."
+	} else if !maxChars(rr.Note, maxReportNoteChars) {
+		fieldErrors["note"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxReportNoteChars)
+	}
+	if rr.Action == reportActionSuspend && (rr.SuspendDays < 1 || rr.SuspendDays > maxSuspensionDays) {
+		fieldErrors["suspendDays"] = fmt.Sprintf("This field must be between 1 and %d.", maxSuspensionDays)
+	} else if rr.Action != reportActionSuspend && rr.SuspendDays != 0 {
+		fieldErrors["suspendDays"] = "This field may only be set when suspending."
+	}
+
+	return fieldErrors
+}
+
+// Reports whether the receiver and other report the same content.
+func (r *Report) sameContent(other *Report) bool {
+	return r.Kind == other.Kind && r.EximId == other.EximId && r.CommentId == other.CommentId
+}
+
+// Writes the receiver as a new open report of an exim (or of one of its
+// comments, if commentBinId is not nil). A member may only have one open
+// report of the same content.
+func (r *Report) createReportTx(eximBinId []byte, commentBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		// Retrieve buckets.
+		rb := tx.Bucket([]byte("REPORT"))
+		ob := tx.Bucket([]byte("REPORT_OPEN"))
+
+		// Read the reported content, which must not have been removed already.
+		var exim Exim
+		if err := getExim(tx, eximBinId, &exim); err != nil {
+			return err
+		}
+		if exim.Removal != nil {
+			return errEximRemoved
+		}
+		r.Kind = reportKindExim
+		r.EximId = exim.EximId.String()
+		r.AuthorId = exim.Author
+		if commentBinId != nil {
+			var comment Comment
+			if err := getComment(tx, eximBinId, commentBinId, &comment); err != nil {
+				return err
+			}
+			if comment.IsDeleted {
+				return errCommentDeleted
+			}
+			r.Kind = reportKindComment
+			r.CommentId = comment.CommentId.String()
+			r.AuthorId = comment.AuthorId
+		}
+
+		// Check for a duplicate among the open reports.
+		c := ob.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			var open Report
+			if err := json.Unmarshal(rb.Get(k), &open); err != nil {
+				return err
+			}
+			if open.ReporterId == r.ReporterId && open.sameContent(r) {
+				return errReportDuplicate
+			}
+		}
+
+		id, binId := createUlid()
+		r.ReportId = id
+		r.CreatedTs = time.Now()
+		r.Status = reportStatusOpen
+
+		// Marshal Report to be stored.
+		reportJs, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if err := rb.Put(binId, reportJs); err != nil {
+			return err
+		}
+		return ob.Put(binId, []byte{})
+	})
+}
+
+// Resolves an open report by taking the resolution's action against the
+// reported content or its author. Every other open report of the same content
+// is resolved along with it. On success, the receiver is set to the report,
+// and sanction (if any) to the sanction applied to the author (none if the
+// author has deleted their account).
+func (r *Report) resolveReportTx(reportBinId []byte, resolution ReportResolution, sanction *Sanction) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		// Retrieve buckets.
+		rb := tx.Bucket([]byte("REPORT"))
+		ob := tx.Bucket([]byte("REPORT_OPEN"))
+
+		// Retrieve report.
+		reportBytes := rb.Get(reportBinId)
+		if reportBytes == nil {
+			return errReportNotFound
+		}
+		if err := json.Unmarshal(reportBytes, r); err != nil {
+			return err
+		}
+		if r.Status != reportStatusOpen {
+			return errReportResolved
+		}
+
+		reportId := r.ReportId.String()
+		resolution.ResolvedTs = time.Now()
+
+		// Take action.
+		switch resolution.Action {
+		case reportActionHide:
+			eximBinId, commentBinId, err := r.contentBinIds()
+			if err != nil {
+				return err
+			}
+			if r.Kind == reportKindComment {
+				var comment Comment
+				err = comment.applyDeletion(tx, eximBinId, commentBinId, resolution.ByUserId, true, reportId)
+			} else {
+				var exim Exim
+				err = exim.applyRemoval(tx, eximBinId, EximRemoval{
+					Kind:      "removed",
+					ByUserId:  resolution.ByUserId,
+					Reason:    resolution.Note,
+					RemovedTs: resolution.ResolvedTs,
+				}, reportId)
+			}
+			if err != nil {
+				return err
+			}
+		case reportActionWarn, reportActionSuspend:
+			// An author who has since deleted their account can't be
+			// sanctioned, but the report is still resolved.
+			if r.AuthorId == deletedUserId {
+				break
+			}
+			authorId, err := ulid.ParseStrict(r.AuthorId)
+			if err != nil {
+				return err
+			}
+			authorBinId, err := authorId.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			*sanction = Sanction{
+				UserId:   r.AuthorId,
+				Kind:     sanctionKindWarning,
+				Reason:   resolution.Note,
+				ByUserId: resolution.ByUserId,
+				ReportId: reportId,
+			}
+			if resolution.Action == reportActionSuspend {
+				expiresTs := resolution.ResolvedTs.AddDate(0, 0, resolution.SuspendDays)
+				sanction.Kind = sanctionKindSuspension
+				sanction.ExpiresTs = &expiresTs
+			}
+			if err := sanction.applySanction(tx, authorBinId); err != nil {
+				return err
+			}
+		}
+
+		// Collect the open reports of the same content first, since writing
+		// while iterating is not permitted.
+		resolved := map[string]Report{}
+		c := ob.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			var open Report
+			if err := json.Unmarshal(rb.Get(k), &open); err != nil {
+				return err
+			}
+			if open.sameContent(r) {
+				resolved[string(k)] = open
+			}
+		}
+		for binId, open := range resolved {
+			open.Status = reportStatusResolved
+			open.Resolution = &resolution
+			reportJs, err := json.Marshal(open)
+			if err != nil {
+				return err
+			}
+			if err := rb.Put([]byte(binId), reportJs); err != nil {
+				return err
+			}
+			if err := ob.Delete([]byte(binId)); err != nil {
+				return err
+			}
+		}
+		r.Status = reportStatusResolved
+		r.Resolution = &resolution
+
+		detail := resolution.Action
+		if resolution.Action == reportActionSuspend {
+			detail = fmt.Sprintf("%s %dd", resolution.Action, resolution.SuspendDays)
+		}
+		subjectId := r.EximId
+		if r.Kind == reportKindComment {
+			subjectId = r.CommentId
+		}
+		return writeAudit(tx, AuditEntry{
+			ActorId:     resolution.ByUserId,
+			Action:      auditActionReportResolve,
+			SubjectKind: r.Kind,
+			SubjectId:   subjectId,
+			EximId:      r.EximId,
+			ReportId:    reportId,
+			Detail:      detail,
+			Note:        resolution.Note,
+		})
+	})
+}
+
+// Reads up to limit reports with the provided status, along with the content
+// they report: open reports oldest first (i.e. in queue order), resolved
+// reports newest first.
+func (rs *Reports) getReportsTx(status string, limit int) error {
+	return db.View(func(tx *bolt.Tx) error {
+		// Retrieve buckets.
+		rb := tx.Bucket([]byte("REPORT"))
+		ob := tx.Bucket([]byte("REPORT_OPEN"))
+
+		appendReport := func(v []byte) error {
+			var report Report
+			if err := json.Unmarshal(v, &report); err != nil {
+				return err
+			}
+			if report.Status != status {
+				return nil
+			}
+			if err := report.readContent(tx); err != nil {
+				return err
+			}
+			*rs = append(*rs, report)
+			return nil
+		}
+
+		if status == reportStatusOpen {
+			c := ob.Cursor()
+			for k, _ := c.First(); k != nil && len(*rs) < limit; k, _ = c.Next() {
+				if err := appendReport(rb.Get(k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		c := rb.Cursor()
+		for k, v := c.Last(); k != nil && len(*rs) < limit; k, v = c.Prev() {
+			if err := appendReport(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Sets the receiver's Exim (and Comment) to the reported content, if it still
+// exists. Moderators see the content in full, even if it has been removed.
+func (r *Report) readContent(tx *bolt.Tx) error {
+	eximBinId, commentBinId, err := r.contentBinIds()
+	if err != nil {
+		return err
+	}
+	var exim Exim
+	if err := getExim(tx, eximBinId, &exim); err != nil {
+		if errors.Is(err, errEximNotFound) {
+			return nil
+		}
+		return err
+	}
+	r.Exim = &exim
+	if commentBinId != nil {
+		var comment Comment
+		if err := getComment(tx, eximBinId, commentBinId, &comment); err != nil {
+			if errors.Is(err, errCommentNotFound) {
+				return nil
+			}
+			return err
+		}
+		r.Comment = &comment
+	}
+	return nil
+}
+
+// Returns the db keys of the reported exim, and of the reported comment (nil
+// unless Kind is "comment").
+func (r *Report) contentBinIds() ([]byte, []byte, error) {
+	eximId, err := ulid.ParseStrict(r.EximId)
+	if err != nil {
+		return nil, nil, err
+	}
+	eximBinId, err := eximId.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.Kind != reportKindComment {
+		return eximBinId, nil, nil
+	}
+	commentId, err := ulid.ParseStrict(r.CommentId)
+	if err != nil {
+		return nil, nil, err
+	}
+	commentBinId, err := commentId.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+	return eximBinId, commentBinId, nil
+}
+
+// Reads an exim within an existing db transaction.
+func getExim(tx *bolt.Tx, eximBinId []byte, exim *Exim) error {
+	eximBytes := tx.Bucket([]byte("MOD_EXIM")).Get(eximBinId)
+	if eximBytes == nil {
+		return errEximNotFound
+	}
+	return json.Unmarshal(eximBytes, exim)
+}
+
+// Reads a comment within an existing db transaction.
+func getComment(tx *bolt.Tx, eximBinId []byte, commentBinId []byte, comment *Comment) error {
+	commentBytes := tx.Bucket([]byte("MOD_EXIM_COMMENT")).Get(compositeKey(eximBinId, commentBinId))
+	if commentBytes == nil {
+		return errCommentNotFound
+	}
+	return json.Unmarshal(commentBytes, comment)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Sanction kinds. A warning has no effect beyond notifying the user. A
+// suspension prevents the user from logging in or using their sessions until
+// ExpiresTs; so does a ban, which is permanent unless ExpiresTs is set.
+const sanctionKindWarning = "warning"
+const sanctionKindSuspension = "suspension"
+const sanctionKindBan = "ban"
+
+var sanctionKinds = []string{sanctionKindWarning, sanctionKindSuspension, sanctionKindBan}
+
+const maxSuspensionDays = 365
+const maxSanctionReasonChars = 500
+
+var errUserSuspended = errors.New("account is suspended")
+var errUserBanned = errors.New("account is banned")
+var errSanctionNotFound = errors.New("sanction does not exist")
+var errSanctionLifted = errors.New("sanction has already been lifted or has expired")
+
+// A moderation measure taken against a user, and why. Sanctions are applied by
+// moderators when resolving reports (ByUserId), or by admins (ByAdminId).
+type Sanction struct {
+	SanctionId ulid.ULID `json:"sanctionId"`
+	UserId     string    `json:"userId"`
+	Kind       string    `json:"kind"`
+	Reason     string    `json:"reason"`
+	ByUserId   string    `json:"byUserId,omitempty"`
+	ByAdminId  string    `json:"byAdminId,omitempty"`
+	// ReportId is set if the sanction was prompted by a report.
+	ReportId  string     `json:"reportId,omitempty"`
+	CreatedTs time.Time  `json:"createdTs"`
+	ExpiresTs *time.Time `json:"expiresTs,omitempty"`
+	// Lift is set if the sanction was lifted before it expired.
+	Lift *SanctionLift `json:"lift,omitempty"`
+}
+
+// Records why, when and by whom a sanction was lifted.
+type SanctionLift struct {
+	ByAdminId string    `json:"byAdminId"`
+	Reason    string    `json:"reason"`
+	LiftedTs  time.Time `json:"liftedTs"`
+}
+
+type Sanctions []Sanction
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (s *Sanction) normalize() {
+	s.Kind = normalizeText(s.Kind, false)
+	s.Reason = normalizeText(s.Reason, true)
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first. Days is the
+// length of the sanction: required for suspensions, optional for bans.
+func (s *Sanction) validate(days int) map[string]string {
+	fieldErrors := map[string]string{}
+
+	if !slices.Contains(sanctionKinds, s.Kind) {
+		fieldErrors["kind"] = fmt.Sprintf("This field must be one of: %v.", sanctionKinds)
+	}
+	if isBlank(s.Reason) {
+		fieldErrors["reason"] = "This field cannot be blank."
+	} else if !maxChars(s.Reason, maxSanctionReasonChars) {
+		fieldErrors["reason"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxSanctionReasonChars)
+	}
+	switch {
+	case s.Kind == sanctionKindSuspension && (days < 1 || days > maxSuspensionDays):
+		fieldErrors["days"] = fmt.Sprintf("This field must be between 1 and %d.", maxSuspensionDays)
+	case s.Kind == sanctionKindBan && days < 0:
+		fieldErrors["days"] = "This field cannot be negative."
+	case s.Kind == sanctionKindWarning && days != 0:
+		fieldErrors["days"] = "This field cannot be set for a warning."
+	}
+
+	return fieldErrors
+}
+
+// Reports whether the sanction currently restricts the user.
+func (s *Sanction) isActive(now time.Time) bool {
+	if s.Kind == sanctionKindWarning || s.Lift != nil {
+		return false
+	}
+	return s.ExpiresTs == nil || now.Before(*s.ExpiresTs)
+}
+
+// Returns the error shown to a user restricted by the sanction.
+func (s *Sanction) restriction() error {
+	err := errUserSuspended
+	if s.Kind == sanctionKindBan {
+		err = errUserBanned
+	}
+	if s.ExpiresTs == nil {
+		return fmt.Errorf("%w: %s", err, s.Reason)
+	}
+	return fmt.Errorf("%w until %s: %s", err, s.ExpiresTs.Format(time.RFC3339), s.Reason)
+}
+
+// Writes the receiver as a new sanction within an existing db transaction.
+// Sanctions are keyed by userId + sanctionId.
+func (s *Sanction) applySanction(tx *bolt.Tx, userBinId []byte) error {
+	sb := tx.Bucket([]byte("USER_SANCTION"))
+
+	id, binId := createUlid()
+	s.SanctionId = id
+	s.CreatedTs = time.Now()
+
+	sanctionJs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return sb.Put(compositeKey(userBinId, binId), sanctionJs)
+}
+
+// Notifies the sanctioned user by email in production environment, either of
+// the sanction or, if it has been lifted, of its lifting.
+func (s *Sanction) sendSanctionEmail(email string) {
+	if env == nil || *env != "prod" {
+		return
+	}
+	var subject, body string
+	switch {
+	case s.Lift != nil:
+		subject = "Your Cooperative Party account is no longer restricted"
+		body = fmt.Sprintf("An administrator has lifted the %s on your account for the following reason: %s", s.Kind, s.Lift.Reason)
+	case s.Kind == sanctionKindWarning:
+		subject = "A warning from the Cooperative Party moderators"
+		body = fmt.Sprintf("A moderator has issued you a warning for the following reason: %s", s.Reason)
+	case s.Kind == sanctionKindSuspension:
+		subject = "Your Cooperative Party account has been suspended"
+		body = fmt.Sprintf("Your account has been suspended until %s for the following reason: %s", s.ExpiresTs.Format(time.RFC1123), s.Reason)
+	case s.Kind == sanctionKindBan && s.ExpiresTs != nil:
+		subject = "Your Cooperative Party account has been banned"
+		body = fmt.Sprintf("Your account has been banned until %s for the following reason: %s", s.ExpiresTs.Format(time.RFC1123), s.Reason)
+	case s.Kind == sanctionKindBan:
+		subject = "Your Cooperative Party account has been banned"
+		body = fmt.Sprintf("Your account has been permanently banned for the following reason: %s", s.Reason)
+	}
+	err := sendEmail(email, subject, body)
+	if err != nil {
+		fmt.Printf("[err][api] sending email to user: %v [%s]\n", err, cts())
+	}
+}
+
+// Writes the receiver as a new sanction applied by an admin, and audits it.
+func (s *Sanction) createSanctionTx(userBinId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		ab := tx.Bucket([]byte("USER_AUTH"))
+
+		if ab.Get(userBinId) == nil {
+			return errUserNotFound
+		}
+		if err := s.applySanction(tx, userBinId); err != nil {
+			return err
+		}
+		detail := s.Kind
+		if s.ExpiresTs != nil {
+			detail = fmt.Sprintf("%s until %s", s.Kind, s.ExpiresTs.Format(time.RFC3339))
+		}
+		return writeAudit(tx, AuditEntry{
+			ActorId:     s.ByAdminId,
+			Action:      auditActionUserSanction,
+			SubjectKind: "user",
+			SubjectId:   s.UserId,
+			Detail:      detail,
+			Note:        s.Reason,
+		})
+	})
+}
+
+// Lifts an active sanction, and audits it. On success, the receiver is set to
+// the lifted sanction.
+func (s *Sanction) liftSanctionTx(userBinId []byte, sanctionBinId []byte, lift SanctionLift) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		sb := tx.Bucket([]byte("USER_SANCTION"))
+		key := compositeKey(userBinId, sanctionBinId)
+
+		// Retrieve current sanction.
+		sanctionBytes := sb.Get(key)
+		if sanctionBytes == nil {
+			return errSanctionNotFound
+		}
+		if err := json.Unmarshal(sanctionBytes, s); err != nil {
+			return err
+		}
+		lift.LiftedTs = time.Now()
+		if !s.isActive(lift.LiftedTs) {
+			return errSanctionLifted
+		}
+
+		s.Lift = &lift
+		sanctionJs, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		if err := sb.Put(key, sanctionJs); err != nil {
+			return err
+		}
+		return writeAudit(tx, AuditEntry{
+			ActorId:     lift.ByAdminId,
+			Action:      auditActionUserLift,
+			SubjectKind: "user",
+			SubjectId:   s.UserId,
+			Detail:      s.Kind,
+			Note:        lift.Reason,
+		})
+	})
+}
+
+// Reads every sanction of a user (including expired and lifted ones), oldest
+// first.
+func (ss *Sanctions) getSanctionsTx(userBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		ab := tx.Bucket([]byte("USER_AUTH"))
+		sb := tx.Bucket([]byte("USER_SANCTION"))
+
+		if ab.Get(userBinId) == nil {
+			return errUserNotFound
+		}
+		c := sb.Cursor()
+		for k, v := c.Seek(userBinId); k != nil && bytes.HasPrefix(k, userBinId); k, v = c.Next() {
+			var sanction Sanction
+			if err := json.Unmarshal(v, &sanction); err != nil {
+				return err
+			}
+			*ss = append(*ss, sanction)
+		}
+		return nil
+	})
+}
+
+// Checks whether the user is restricted by an active sanction, returning the
+// restriction (wrapping errUserSuspended or errUserBanned) if so. A ban takes
+// precedence over a suspension, and a permanent or later-ending restriction
+// over an earlier-ending one.
+func (u *User) checkSanctionsTx(binId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		sb := tx.Bucket([]byte("USER_SANCTION"))
+
+		now := time.Now()
+		var active *Sanction
+		c := sb.Cursor()
+		for k, v := c.Seek(binId); k != nil && bytes.HasPrefix(k, binId); k, v = c.Next() {
+			var sanction Sanction
+			if err := json.Unmarshal(v, &sanction); err != nil {
+				return err
+			}
+			if !sanction.isActive(now) {
+				continue
+			}
+			if active == nil || sanction.outranks(active) {
+				active = &sanction
+			}
+		}
+		if active == nil {
+			return nil
+		}
+		return active.restriction()
+	})
+}
+
+// Reports whether the receiver (an active sanction) is more severe than other.
+func (s *Sanction) outranks(other *Sanction) bool {
+	if (s.Kind == sanctionKindBan) != (other.Kind == sanctionKindBan) {
+		return s.Kind == sanctionKindBan
+	}
+	if s.ExpiresTs == nil || other.ExpiresTs == nil {
+		return s.ExpiresTs == nil && other.ExpiresTs != nil
+	}
+	return s.ExpiresTs.After(*other.ExpiresTs)
+}
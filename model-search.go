@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// How much a term counts towards an exim's score, by the field it appears in.
+const searchWeightTitle = 3
+const searchWeightSummary = 2
+const searchWeightSection = 1
+
+const defaultSearchLimit = 20
+const maxSearchLimit = 100
+const maxSearchQueryChars = 200
+const maxSearchQueryTerms = 10
+
+// An exim found by search, with its score and a highlighted snippet of the
+// text that matched.
+type EximSearchResult struct {
+	Exim    Exim            `json:"exim"`
+	Score   float64         `json:"score"`
+	Snippet []searchSegment `json:"snippet"`
+}
+
+type EximSearchResults []EximSearchResult
+
+// Counts the weighted occurrences of each search term in an exim's content.
+func (e *Exim) searchTermCounts() map[string]int {
+	counts := map[string]int{}
+	type field struct {
+		text   string
+		weight int
+	}
+	fields := []field{
+		{e.Title, searchWeightTitle},
+		{e.Summary, searchWeightSummary},
+	}
+	for _, section := range e.Sections {
+		fields = append(fields, field{section.Heading, searchWeightSummary}, field{section.Body, searchWeightSection})
+	}
+	for _, f := range fields {
+		for _, term := range searchTerms(f.text) {
+			counts[term] += f.weight
+		}
+	}
+	return counts
+}
+
+// The MOD_EXIM_SEARCH key holding the number of indexed exims, which scales
+// the rarity of terms (see searchEximsTx). Terms are never empty, so it can't
+// be mistaken for a term's entry.
+var searchCountKey = []byte("\x00count")
+
+// Builds the MOD_EXIM_SEARCH key of a term's entry for an exim. The 0 byte
+// separates the term from the eximId, so a term's entries can be iterated by
+// seeking to term + 0.
+func searchKey(term string, eximBinId []byte) []byte {
+	key := make([]byte, 0, len(term)+1+len(eximBinId))
+	key = append(key, term...)
+	key = append(key, 0)
+	return append(key, eximBinId...)
+}
+
+// Updates the search index within an existing db transaction, replacing the
+// entries of the prior content (if any) with those of the current content (if
+// any). Pass nil as current to remove an exim from the index.
+func indexExim(tx *bolt.Tx, eximBinId []byte, prior *Exim, current *Exim) error {
+	b := tx.Bucket([]byte("MOD_EXIM_SEARCH"))
+
+	// Keep count of the indexed exims.
+	if (prior == nil) != (current == nil) {
+		count := getSearchCount(b)
+		if prior == nil {
+			count++
+		} else {
+			count--
+		}
+		if err := b.Put(searchCountKey, []byte(strconv.Itoa(count))); err != nil {
+			return err
+		}
+	}
+
+	if prior != nil {
+		for term := range prior.searchTermCounts() {
+			if err := b.Delete(searchKey(term, eximBinId)); err != nil {
+				return err
+			}
+		}
+	}
+	if current != nil {
+		for term, count := range current.searchTermCounts() {
+			if err := b.Put(searchKey(term, eximBinId), []byte(strconv.Itoa(count))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the number of indexed exims, see searchCountKey.
+func getSearchCount(b *bolt.Bucket) int {
+	count, _ := strconv.Atoi(string(b.Get(searchCountKey)))
+	return count
+}
+
+// Finds listed exims containing any of the query's terms, ranked by the
+// weighted count of each term scaled by its rarity (TF-IDF), best first.
+func (r *EximSearchResults) searchEximsTx(query string, limit int) error {
+	terms := map[string]bool{}
+	for _, term := range searchTerms(query) {
+		if len(terms) < maxSearchQueryTerms {
+			terms[term] = true
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	return db.View(func(tx *bolt.Tx) error {
+		// Retrieve buckets.
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		sb := tx.Bucket([]byte("MOD_EXIM_SEARCH"))
+
+		total := float64(getSearchCount(sb))
+
+		// Accumulate scores by eximId.
+		scores := map[string]float64{}
+		c := sb.Cursor()
+		for term := range terms {
+			prefix := append([]byte(term), 0)
+
+			var counts [][]byte
+			var eximBinIds [][]byte
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				eximBinIds = append(eximBinIds, k[len(prefix):])
+				counts = append(counts, v)
+			}
+
+			idf := math.Log(1 + total/float64(max(len(eximBinIds), 1)))
+			for i, eximBinId := range eximBinIds {
+				count, err := strconv.Atoi(string(counts[i]))
+				if err != nil {
+					return err
+				}
+				scores[string(eximBinId)] += float64(count) * idf
+			}
+		}
+
+		// Read each scored exim, skipping those which are not listed.
+		listed := EximQuery{}
+		for eximBinId, score := range scores {
+			eximBytes := eb.Get([]byte(eximBinId))
+			if eximBytes == nil {
+				continue
+			}
+			var exim Exim
+			if err := json.Unmarshal(eximBytes, &exim); err != nil {
+				return err
+			}
+			if !listed.matches(&exim) {
+				continue
+			}
+			*r = append(*r, EximSearchResult{Exim: exim, Score: score})
+		}
+
+		// Best first, ties broken by newest first.
+		slices.SortFunc(*r, func(a, b EximSearchResult) int {
+			if a.Score != b.Score {
+				if a.Score > b.Score {
+					return -1
+				}
+				return 1
+			}
+			return b.Exim.EximId.Compare(a.Exim.EximId)
+		})
+		if len(*r) > limit {
+			*r = (*r)[:limit]
+		}
+
+		// Include support, author name and a snippet of the first field which
+		// matched.
+		for i := range *r {
+			res := &(*r)[i]
+			eximBinId, err := res.Exim.EximId.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			res.Exim.SupportCount = getIndexedSupport(tx, eximBinId)
+			if err := res.Exim.present(tx); err != nil {
+				return err
+			}
+			texts := []string{res.Exim.Summary}
+			for _, section := range res.Exim.Sections {
+				texts = append(texts, section.Heading, section.Body)
+			}
+			for _, text := range append(texts, res.Exim.Title) {
+				if res.Snippet = searchSnippet(text, terms); res.Snippet != nil {
+					break
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Indexes every exim if the search index is empty, i.e. when it is first
+// introduced, or counts them if the index predates its count. Runs at startup.
+func buildSearchIndexTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		sb := tx.Bucket([]byte("MOD_EXIM_SEARCH"))
+
+		if k, _ := sb.Cursor().First(); k != nil {
+			if sb.Get(searchCountKey) != nil {
+				return nil
+			}
+			// Every exim which is not withdrawn or removed is indexed.
+			count := 0
+			err := eb.ForEach(func(k, v []byte) error {
+				var exim Exim
+				if err := json.Unmarshal(v, &exim); err != nil {
+					return err
+				}
+				if exim.Removal == nil {
+					count++
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			return sb.Put(searchCountKey, []byte(strconv.Itoa(count)))
+		}
+
+		// Collect exims first, since writing while iterating with ForEach is
+		// not permitted.
+		exims := map[ulid.ULID]Exim{}
+		err := eb.ForEach(func(k, v []byte) error {
+			var exim Exim
+			if err := json.Unmarshal(v, &exim); err != nil {
+				return err
+			}
+			if exim.Removal == nil {
+				exims[exim.EximId] = exim
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for id, exim := range exims {
+			binId, err := id.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			if err := indexExim(tx, binId, nil, &exim); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Exim lifecycle states.
+const eximStateDraft = "draft"
+const eximStateSubmitted = "submitted"
+const eximStateApproved = "approved"
+const eximStateTrialing = "trialing"
+const eximStateConcluded = "concluded"
+const eximStateAbandoned = "abandoned"
+const eximStateRejected = "rejected"
+
+// Roles which may be permitted to make a transition.
+const roleAuthor = "author"
+const roleModerator = "moderator"
+
+// Allowed transitions, from state to state, and the roles permitted to make
+// each of them. States absent as a key (concluded, abandoned) are final.
+var eximTransitions = map[string]map[string][]string{
+	eximStateDraft: {
+		eximStateSubmitted: {roleAuthor},
+		eximStateAbandoned: {roleAuthor},
+	},
+	eximStateSubmitted: {
+		eximStateDraft:     {roleAuthor},
+		eximStateApproved:  {roleModerator},
+		eximStateRejected:  {roleModerator},
+		eximStateAbandoned: {roleAuthor},
+	},
+	eximStateApproved: {
+		eximStateTrialing:  {roleAuthor, roleModerator},
+		eximStateAbandoned: {roleAuthor, roleModerator},
+	},
+	eximStateTrialing: {
+		eximStateConcluded: {roleAuthor, roleModerator},
+		eximStateAbandoned: {roleAuthor, roleModerator},
+	},
+	eximStateRejected: {
+		eximStateDraft: {roleAuthor},
+	},
+}
+
+var eximStates = []string{
+	eximStateDraft,
+	eximStateSubmitted,
+	eximStateApproved,
+	eximStateTrialing,
+	eximStateConcluded,
+	eximStateAbandoned,
+	eximStateRejected,
+}
+
+const maxTransitionNoteChars = 500
+
+var errTransitionNotAllowed = errors.New("transition is not allowed from the exim's current state")
+
+// A timestamped entry in an exim's transition log. From is empty for the
+// initial state. Transitions made automatically under an approval rule have
+// RuleId set instead of ByUserId.
+type EximTransition struct {
+	TransitionId ulid.ULID `json:"transitionId"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	ByUserId     string    `json:"byUserId"`
+	// ByUserName is only set on read, see participant.
+	ByUserName   string    `json:"byUserName,omitempty"`
+	RuleId       string    `json:"ruleId,omitempty"`
+	Note         string    `json:"note"`
+	TransitionTs time.Time `json:"transitionTs"`
+}
+
+type EximTransitions []EximTransition
+
+// Returns true if the string is a known exim state.
+func isEximState(state string) bool {
+	return slices.Contains(eximStates, state)
+}
+
+// Determines the role under which a user may move an exim from one state to
+// another, preferring the author role so that authors acting on their own
+// exims are not treated as acting with moderator privileges. Transitions
+// permitted only to moderators (approval and rejection) review the exim, so
+// authors cannot make them even if they are moderators.
+func eximTransitionRole(from string, to string, isAuthor bool, isModerator bool) (string, error) {
+	roles, ok := eximTransitions[from][to]
+	if !ok {
+		return "", errTransitionNotAllowed
+	}
+	if isAuthor {
+		if slices.Contains(roles, roleAuthor) {
+			return roleAuthor, nil
+		}
+		return "", errEximNotPermitted
+	}
+	for _, role := range roles {
+		if role == roleModerator && isModerator {
+			return roleModerator, nil
+		}
+	}
+	return "", errEximNotPermitted
+}
+
+// Writes a transition to the exim's log within an existing db transaction,
+// assigning its ULID and timestamp.
+func putEximTransition(tx *bolt.Tx, eximBinId []byte, t EximTransition) error {
+	sb := tx.Bucket([]byte("MOD_EXIM_STATE"))
+
+	id, binId := createUlid()
+	t.TransitionId = id
+	t.TransitionTs = time.Now()
+
+	tJs, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return sb.Put(compositeKey(eximBinId, binId), tJs)
+}
+
+// Moves the exim to the provided state if the transition is allowed from its
+// current state for the user, and logs the transition. On success, the
+// receiver is set to the updated exim.
+func (e *Exim) transitionEximTx(eximBinId []byte, to string, userId ulid.ULID, isModerator bool, note string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		// Retrieve bucket.
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+
+		// Retrieve current exim.
+		eximBytes := eb.Get(eximBinId)
+		if eximBytes == nil {
+			return errEximNotFound
+		}
+		if err := json.Unmarshal(eximBytes, e); err != nil {
+			return err
+		}
+		if e.Removal != nil {
+			return errEximRemoved
+		}
+
+		from := e.State
+		if _, err := eximTransitionRole(from, to, e.Author == userId.String(), isModerator); err != nil {
+			return err
+		}
+
+		prior := *e
+		e.State = to
+		eximJs, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := eb.Put(eximBinId, eximJs); err != nil {
+			return err
+		}
+		// Drafts are not counted as listed with their tags.
+		if from == eximStateDraft || to == eximStateDraft {
+			if err := indexEximTags(tx, eximBinId, &prior, e); err != nil {
+				return err
+			}
+		}
+
+		return putEximTransition(tx, eximBinId, EximTransition{
+			From:     from,
+			To:       to,
+			ByUserId: userId.String(),
+			Note:     note,
+		})
+	})
+}
+
+// Reads an exim's transition log, oldest first.
+func (t *EximTransitions) getEximTransitionsTx(eximBinId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		sb := tx.Bucket([]byte("MOD_EXIM_STATE"))
+
+		eximBytes := eb.Get(eximBinId)
+		if eximBytes == nil {
+			return errEximNotFound
+		}
+		var exim Exim
+		if err := json.Unmarshal(eximBytes, &exim); err != nil {
+			return err
+		}
+
+		// Iterate over keys prefixed with eximId.
+		c := sb.Cursor()
+		for k, v := c.Seek(eximBinId); k != nil && bytes.HasPrefix(k, eximBinId); k, v = c.Next() {
+			var transition EximTransition
+			if err := json.Unmarshal(v, &transition); err != nil {
+				return err
+			}
+			if transition.ByUserId != "" {
+				var err error
+				transition.ByUserId, transition.ByUserName, err = exim.participant(tx, transition.ByUserId)
+				if err != nil {
+					return err
+				}
+			}
+			*t = append(*t, transition)
+		}
+		return nil
+	})
+}
+
+// Sets State on exims stored before the lifecycle replaced IsApproved. Runs
+// at startup; exims which already have a state are untouched.
+func migrateEximStatesTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+
+		// Collect updates first, since writing while iterating with ForEach
+		// is not permitted.
+		updates := map[string]Exim{}
+		err := eb.ForEach(func(k, v []byte) error {
+			var legacy struct {
+				Exim
+				IsApproved bool `json:"isApproved"`
+			}
+			if err := json.Unmarshal(v, &legacy); err != nil {
+				return err
+			}
+			if legacy.State != "" {
+				return nil
+			}
+
+			exim := legacy.Exim
+			exim.State = eximStateSubmitted
+			if legacy.IsApproved {
+				exim.State = eximStateApproved
+			}
+			updates[string(k)] = exim
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, exim := range updates {
+			eximJs, err := json.Marshal(exim)
+			if err != nil {
+				return err
+			}
+			if err := eb.Put([]byte(k), eximJs); err != nil {
+				return err
+			}
+			err = putEximTransition(tx, []byte(k), EximTransition{
+				To:       exim.State,
+				ByUserId: exim.Author,
+				Note:     "migrated from isApproved",
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEximTransitionRole(t *testing.T) {
+	tests := []struct {
+		name        string
+		from, to    string
+		isAuthor    bool
+		isModerator bool
+		want        string
+		wantErr     error
+	}{
+		{"author submits draft", eximStateDraft, eximStateSubmitted, true, false, roleAuthor, nil},
+		{"moderator cannot submit draft", eximStateDraft, eximStateSubmitted, false, true, "", errEximNotPermitted},
+		{"moderator approves", eximStateSubmitted, eximStateApproved, false, true, roleModerator, nil},
+		{"author cannot approve", eximStateSubmitted, eximStateApproved, true, false, "", errEximNotPermitted},
+		{"moderator cannot approve own exim", eximStateSubmitted, eximStateApproved, true, true, "", errEximNotPermitted},
+		{"moderator cannot reject own exim", eximStateSubmitted, eximStateRejected, true, true, "", errEximNotPermitted},
+		{"author role preferred", eximStateApproved, eximStateTrialing, true, true, roleAuthor, nil},
+		{"moderator starts trial", eximStateApproved, eximStateTrialing, false, true, roleModerator, nil},
+		{"member cannot start trial", eximStateApproved, eximStateTrialing, false, false, "", errEximNotPermitted},
+		{"rejected returns to draft", eximStateRejected, eximStateDraft, true, false, roleAuthor, nil},
+		{"concluded is final", eximStateConcluded, eximStateTrialing, true, true, "", errTransitionNotAllowed},
+		{"abandoned is final", eximStateAbandoned, eximStateDraft, true, true, "", errTransitionNotAllowed},
+		{"no skipping approval", eximStateSubmitted, eximStateTrialing, true, true, "", errTransitionNotAllowed},
+		{"no transition to itself", eximStateDraft, eximStateDraft, true, true, "", errTransitionNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eximTransitionRole(tt.from, tt.to, tt.isAuthor, tt.isModerator)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("eximTransitionRole() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("eximTransitionRole() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
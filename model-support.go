@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// States in which an exim may gain or lose support.
+var supportableEximStates = []string{eximStateSubmitted, eximStateApproved, eximStateTrialing}
+
+var errEximNotSupportable = errors.New("exim cannot be supported in its current state")
+
+// Adds (or removes) the user's support for an exim. Support is keyed by
+// eximId + userId, and its value is the time support was given. Supporting
+// withdraws any abstention, see abstainEximTx.
+func (e *Exim) supportEximTx(eximBinId []byte, userBinId []byte, isSupporting bool) error {
+	return e.voteEximTx(eximBinId, userBinId, "MOD_EXIM_SUPPORT", "MOD_EXIM_ABSTAIN", isSupporting)
+}
+
+// Adds (or removes) the user's abstention on an exim. Abstaining is a direct
+// vote which overrides the user's delegation (see Delegation) without
+// supporting the exim, and withdraws any support. Abstentions are keyed and
+// valued like support.
+func (e *Exim) abstainEximTx(eximBinId []byte, userBinId []byte, isAbstaining bool) error {
+	return e.voteEximTx(eximBinId, userBinId, "MOD_EXIM_ABSTAIN", "MOD_EXIM_SUPPORT", isAbstaining)
+}
+
+// Adds (or removes) the user's vote in bucket, removing their opposite vote in
+// other when adding.
+func (e *Exim) voteEximTx(eximBinId []byte, userBinId []byte, bucket string, other string, isVoting bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		// Retrieve buckets.
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		vb := tx.Bucket([]byte(bucket))
+
+		// Retrieve exim.
+		eximBytes := eb.Get(eximBinId)
+		if eximBytes == nil {
+			return errEximNotFound
+		}
+		if err := json.Unmarshal(eximBytes, e); err != nil {
+			return err
+		}
+
+		key := compositeKey(eximBinId, userBinId)
+
+		// A vote may always be withdrawn.
+		if !isVoting {
+			if err := vb.Delete(key); err != nil {
+				return err
+			}
+			return indexEximSupport(tx, eximBinId)
+		}
+
+		if e.Removal != nil {
+			return errEximRemoved
+		}
+		if !slices.Contains(supportableEximStates, e.State) {
+			return errEximNotSupportable
+		}
+
+		if err := tx.Bucket([]byte(other)).Delete(key); err != nil {
+			return err
+		}
+
+		// Keep the time the vote was first given.
+		if vb.Get(key) == nil {
+			if err := vb.Put(key, []byte(time.Now().Format(time.RFC3339))); err != nil {
+				return err
+			}
+		}
+		return indexEximSupport(tx, eximBinId)
+	})
+}
+
+// Returns the userIds of an exim's supporters within an existing db
+// transaction: its direct supporters, plus every member whose delegations
+// resolve to one of them (see delegatedSupporters). Members who voted directly
+// (by supporting or abstaining) are counted by their own vote only, and
+// delegations which form a cycle count for nothing. The delegation map should
+// be read once per transaction, and shared by every exim counted in it.
+func getEximSupporters(tx *bolt.Tx, eximBinId []byte, dm *delegationMap) ([]string, error) {
+	supporters, err := getKeyedUserIds(tx.Bucket([]byte("MOD_EXIM_SUPPORT")), eximBinId)
+	if err != nil {
+		return nil, err
+	}
+	abstainers, err := getKeyedUserIds(tx.Bucket([]byte("MOD_EXIM_ABSTAIN")), eximBinId)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := getEximTags(tx, eximBinId)
+	if err != nil {
+		return nil, err
+	}
+
+	hasVoted := func(userId string) bool {
+		return supporters[userId] || abstainers[userId]
+	}
+	userIds := dm.delegatedSupporters(supporters, tags, hasVoted)
+	for userId := range supporters {
+		userIds = append(userIds, userId)
+	}
+	return userIds, nil
+}
+
+// Counts an exim's support within an existing db transaction, see
+// getEximSupporters.
+func getEximSupport(tx *bolt.Tx, eximBinId []byte, dm *delegationMap) (int, error) {
+	userIds, err := getEximSupporters(tx, eximBinId, dm)
+	return len(userIds), err
+}
+
+// The length of the support prefix of a MOD_EXIM_SUPPORT_RANK key.
+const supportRankBytes = 4
+
+// Support is indexed so that exims can be listed by most supported without
+// counting the support of every exim (see pageByIndex). MOD_EXIM_SUPPORT_COUNT
+// holds each exim's support, keyed by eximId, and MOD_EXIM_SUPPORT_RANK holds
+// a key per exim ordered by support, then eximId.
+func supportRankKey(support int, eximBinId []byte) []byte {
+	key := make([]byte, supportRankBytes, supportRankBytes+len(eximBinId))
+	binary.BigEndian.PutUint32(key, uint32(support))
+	return append(key, eximBinId...)
+}
+
+// Gets an exim's indexed support within an existing db transaction.
+func getIndexedSupport(tx *bolt.Tx, eximBinId []byte) int {
+	v := tx.Bucket([]byte("MOD_EXIM_SUPPORT_COUNT")).Get(eximBinId)
+	if v == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(v))
+}
+
+// Sets an exim's indexed support within an existing db transaction.
+func putSupportIndex(tx *bolt.Tx, eximBinId []byte, support int) error {
+	cb := tx.Bucket([]byte("MOD_EXIM_SUPPORT_COUNT"))
+	rb := tx.Bucket([]byte("MOD_EXIM_SUPPORT_RANK"))
+
+	if err := unindexEximSupport(tx, eximBinId); err != nil {
+		return err
+	}
+	rankKey := supportRankKey(support, eximBinId)
+	if err := cb.Put(eximBinId, rankKey[:supportRankBytes]); err != nil {
+		return err
+	}
+	return rb.Put(rankKey, []byte{})
+}
+
+// Removes an exim from the support index within an existing db transaction.
+func unindexEximSupport(tx *bolt.Tx, eximBinId []byte) error {
+	cb := tx.Bucket([]byte("MOD_EXIM_SUPPORT_COUNT"))
+	rb := tx.Bucket([]byte("MOD_EXIM_SUPPORT_RANK"))
+
+	if cb.Get(eximBinId) == nil {
+		return nil
+	}
+	if err := rb.Delete(supportRankKey(getIndexedSupport(tx, eximBinId), eximBinId)); err != nil {
+		return err
+	}
+	return cb.Delete(eximBinId)
+}
+
+// Recounts and indexes an exim's support within an existing db transaction,
+// after its votes or tags change.
+func indexEximSupport(tx *bolt.Tx, eximBinId []byte) error {
+	dm, err := getDelegationMap(tx)
+	if err != nil {
+		return err
+	}
+	support, err := getEximSupport(tx, eximBinId, dm)
+	if err != nil {
+		return err
+	}
+	return putSupportIndex(tx, eximBinId, support)
+}
+
+// Recounts and indexes the support of every exim whose support may have
+// changed within an existing db transaction, after delegations or members
+// change. Only exims with direct supporters can have any support, so these are
+// recounted, along with those indexed as having support (which may have lost
+// their supporters).
+func reindexSupport(tx *bolt.Tx) error {
+	eb := tx.Bucket([]byte("MOD_EXIM"))
+
+	eximBinIds := map[string]bool{}
+	c := tx.Bucket([]byte("MOD_EXIM_SUPPORT")).Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Seek(prefixEnd(k[:16])) {
+		eximBinIds[string(k[:16])] = true
+	}
+	zero := supportRankKey(0, nil)
+	c = tx.Bucket([]byte("MOD_EXIM_SUPPORT_RANK")).Cursor()
+	for k, _ := c.Last(); k != nil && !bytes.HasPrefix(k, zero); k, _ = c.Prev() {
+		eximBinIds[string(k[supportRankBytes:])] = true
+	}
+
+	dm, err := getDelegationMap(tx)
+	if err != nil {
+		return err
+	}
+	for id := range eximBinIds {
+		eximBinId := []byte(id)
+		// Votes may outlive their (purged) exim.
+		if eb.Get(eximBinId) == nil {
+			continue
+		}
+		support, err := getEximSupport(tx, eximBinId, dm)
+		if err != nil {
+			return err
+		}
+		if support == getIndexedSupport(tx, eximBinId) {
+			continue
+		}
+		if err := putSupportIndex(tx, eximBinId, support); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Indexes the support of every exim, if the index is empty (e.g. when it is
+// first introduced).
+func buildSupportIndexTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket([]byte("MOD_EXIM_SUPPORT_COUNT")).Cursor().First(); k != nil {
+			return nil
+		}
+
+		dm, err := getDelegationMap(tx)
+		if err != nil {
+			return err
+		}
+		// Collect ids first, since writing while iterating with ForEach is not
+		// permitted.
+		var eximBinIds [][]byte
+		err = tx.Bucket([]byte("MOD_EXIM")).ForEach(func(k, v []byte) error {
+			eximBinIds = append(eximBinIds, slices.Clone(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, eximBinId := range eximBinIds {
+			support, err := getEximSupport(tx, eximBinId, dm)
+			if err != nil {
+				return err
+			}
+			if err := putSupportIndex(tx, eximBinId, support); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
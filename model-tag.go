@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// An administrator-curated category which exims may be tagged with. The slug
+// identifies the tag in URLs and on exims, and cannot be changed.
+type Tag struct {
+	Slug        string    `json:"slug"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedTs   time.Time `json:"createdTs"`
+	// EximCount is kept in MOD_EXIM_TAG_COUNT (see indexEximTags), and only
+	// set on read.
+	EximCount int `json:"eximCount"`
+}
+
+type Tags []Tag
+
+var errTagNotFound = errors.New("tag does not exist")
+var errTagExists = errors.New("tag already exists")
+
+var tagSlugRX = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+const maxTagSlugChars = 50
+const maxTagNameChars = 50
+const maxTagDescriptionChars = 500
+const maxEximTags = 5
+
+// Normalizes the receiver's user-provided fields, see normalizeText.
+func (t *Tag) normalize() {
+	t.Slug = strings.ToLower(normalizeText(t.Slug, false))
+	t.Name = normalizeText(t.Name, false)
+	t.Description = normalizeText(t.Description, true)
+}
+
+// Checks the receiver's fields, returning a map of field name to error message
+// for every invalid field. Fields should be normalized first.
+func (t *Tag) validate() map[string]string {
+	fieldErrors := map[string]string{}
+
+	if !isTagSlug(t.Slug) {
+		fieldErrors["slug"] = fmt.Sprintf("This field must be at most %d lowercase letters, digits and single hyphens.", maxTagSlugChars)
+	}
+	if isBlank(t.Name) {
+		fieldErrors["name"] = "This field cannot be blank."
+	} else if !maxChars(t.Name, maxTagNameChars) {
+		fieldErrors["name"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxTagNameChars)
+	}
+	if !maxChars(t.Description, maxTagDescriptionChars) {
+		fieldErrors["description"] = fmt.Sprintf("This field cannot be more than %d characters long.", maxTagDescriptionChars)
+	}
+
+	return fieldErrors
+}
+
+// Returns true if s is a well-formed tag slug.
+func isTagSlug(s string) bool {
+	return maxChars(s, maxTagSlugChars) && tagSlugRX.MatchString(s)
+}
+
+// Lowercases, trims, sorts and de-duplicates tag slugs. A nil slice stays nil,
+// since editEximTx treats it as "unchanged".
+func normalizeTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+// Builds the MOD_EXIM_TAG key of a tagged exim. The 0 byte separates the slug
+// from the eximId, so a tag's exims can be iterated (oldest first) by seeking
+// to slug + 0.
+func tagKey(slug string, eximBinId []byte) []byte {
+	return compositeKey(tagPrefix(slug), eximBinId)
+}
+
+func tagPrefix(slug string) []byte {
+	return append([]byte(slug), 0)
+}
+
+// Checks that every one of the exim's tags exists, within an existing db
+// transaction.
+func (e *Exim) checkTagsExist(tx *bolt.Tx) error {
+	b := tx.Bucket([]byte("TAG"))
+	for _, slug := range e.Tags {
+		if b.Get([]byte(slug)) == nil {
+			return fmt.Errorf("%w (%s)", errTagNotFound, slug)
+		}
+	}
+	return nil
+}
+
+// Updates the tag index within an existing db transaction, replacing the
+// entries of the prior tags (if any) with those of the current tags (if any).
+// Pass nil as current to remove an exim from the index. Each tag's number of
+// listed exims is kept along with it, so it must also be updated when an exim
+// moves from or to draft.
+func indexEximTags(tx *bolt.Tx, eximBinId []byte, prior *Exim, current *Exim) error {
+	b := tx.Bucket([]byte("MOD_EXIM_TAG"))
+
+	listed := EximQuery{}
+	if prior != nil && listed.matches(prior) {
+		for _, slug := range prior.Tags {
+			if err := addTagCount(tx, slug, -1); err != nil {
+				return err
+			}
+		}
+	}
+	if current != nil && listed.matches(current) {
+		for _, slug := range current.Tags {
+			if err := addTagCount(tx, slug, 1); err != nil {
+				return err
+			}
+		}
+	}
+
+	if prior != nil {
+		for _, slug := range prior.Tags {
+			if err := b.Delete(tagKey(slug, eximBinId)); err != nil {
+				return err
+			}
+		}
+	}
+	if current != nil {
+		for _, slug := range current.Tags {
+			if err := b.Put(tagKey(slug, eximBinId), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the number of listed exims with a tag, within an existing db
+// transaction.
+func getTagCount(tx *bolt.Tx, slug string) int {
+	count, _ := strconv.Atoi(string(tx.Bucket([]byte("MOD_EXIM_TAG_COUNT")).Get([]byte(slug))))
+	return count
+}
+
+// Adds delta to the number of listed exims with a tag, within an existing db
+// transaction.
+func addTagCount(tx *bolt.Tx, slug string, delta int) error {
+	b := tx.Bucket([]byte("MOD_EXIM_TAG_COUNT"))
+	return b.Put([]byte(slug), []byte(strconv.Itoa(getTagCount(tx, slug)+delta)))
+}
+
+// Counts the listed exims of every tag, if the counts are empty (e.g. when
+// they are first introduced). Runs at startup.
+func buildTagCountsTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		cb := tx.Bucket([]byte("MOD_EXIM_TAG_COUNT"))
+
+		if k, _ := cb.Cursor().First(); k != nil {
+			return nil
+		}
+
+		counts := map[string]int{}
+		listed := EximQuery{}
+		err := eb.ForEach(func(k, v []byte) error {
+			var exim Exim
+			if err := json.Unmarshal(v, &exim); err != nil {
+				return err
+			}
+			if listed.matches(&exim) {
+				for _, slug := range exim.Tags {
+					counts[slug]++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for slug, count := range counts {
+			if err := cb.Put([]byte(slug), []byte(strconv.Itoa(count))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Writes a new tag to db.
+func (t *Tag) createTagTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("TAG"))
+
+		if b.Get([]byte(t.Slug)) != nil {
+			return errTagExists
+		}
+
+		t.CreatedTs = time.Now()
+		tagJs, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(t.Slug), tagJs)
+	})
+}
+
+// Updates an existing tag's name and description. On success, the receiver is
+// set to the updated tag.
+func (t *Tag) updateTagTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("TAG"))
+
+		tagBytes := b.Get([]byte(t.Slug))
+		if tagBytes == nil {
+			return errTagNotFound
+		}
+		var current Tag
+		if err := json.Unmarshal(tagBytes, &current); err != nil {
+			return err
+		}
+
+		current.Name = t.Name
+		current.Description = t.Description
+		tagJs, err := json.Marshal(current)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(t.Slug), tagJs); err != nil {
+			return err
+		}
+
+		*t = current
+		return nil
+	})
+}
+
+// Deletes a tag, removing it from every exim tagged with it, and removing
+// every delegation for it.
+func (t *Tag) deleteTagTx() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		// Retrieve buckets.
+		b := tx.Bucket([]byte("TAG"))
+		eb := tx.Bucket([]byte("MOD_EXIM"))
+		tb := tx.Bucket([]byte("MOD_EXIM_TAG"))
+
+		if b.Get([]byte(t.Slug)) == nil {
+			return errTagNotFound
+		}
+
+		// Collect keys first, since deleting while iterating with a cursor may
+		// skip keys.
+		prefix := tagPrefix(t.Slug)
+		var keys [][]byte
+		c := tb.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+
+		for _, k := range keys {
+			eximBinId := k[len(prefix):]
+			var exim Exim
+			if err := json.Unmarshal(eb.Get(eximBinId), &exim); err != nil {
+				return err
+			}
+			exim.Tags = slices.DeleteFunc(exim.Tags, func(slug string) bool {
+				return slug == t.Slug
+			})
+			eximJs, err := json.Marshal(exim)
+			if err != nil {
+				return err
+			}
+			if err := eb.Put(eximBinId, eximJs); err != nil {
+				return err
+			}
+			if err := tb.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		if err := removeTagDelegations(tx, t.Slug); err != nil {
+			return err
+		}
+		if err := reindexSupport(tx); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte("MOD_EXIM_TAG_COUNT")).Delete([]byte(t.Slug)); err != nil {
+			return err
+		}
+		return b.Delete([]byte(t.Slug))
+	})
+}
+
+// Reads a tag, including its number of listed exims.
+func (t *Tag) getTagTx() error {
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("TAG"))
+
+		tagBytes := b.Get([]byte(t.Slug))
+		if tagBytes == nil {
+			return errTagNotFound
+		}
+		if err := json.Unmarshal(tagBytes, t); err != nil {
+			return err
+		}
+
+		t.EximCount = getTagCount(tx, t.Slug)
+		return nil
+	})
+}
+
+// Reads all tags sorted by name, including their number of listed exims.
+func (ts *Tags) getTagsTx() error {
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("TAG"))
+
+		err := b.ForEach(func(k, v []byte) error {
+			var tag Tag
+			if err := json.Unmarshal(v, &tag); err != nil {
+				return err
+			}
+			tag.EximCount = getTagCount(tx, tag.Slug)
+			*ts = append(*ts, tag)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		slices.SortFunc(*ts, func(a, b Tag) int {
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		})
+		return nil
+	})
+}
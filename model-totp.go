@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/oklog/ulid"
+	bolt "go.etcd.io/bbolt"
+)
+
+const maxTotpAttempts = 5
+const totpLockoutDuration = 15 * time.Minute
+
+type TotpGrp struct {
+	// Secret is encrypted at rest, see encryptSecret.
+	Secret         string    `json:"secret"`
+	IsEnrolled     bool      `json:"isEnrolled"`
+	LastUsedStep   int64     `json:"lastUsedStep"`
+	FailedAttempts int       `json:"failedAttempts"`
+	LockedUntilTs  time.Time `json:"lockedUntilTs"`
+	// RecoveryCodes are stored as hashes, see hashRecoveryCode.
+	RecoveryCodes []string  `json:"recoveryCodes"`
+	EnrolledTs    time.Time `json:"enrolledTs"`
+}
+
+type Totp struct {
+	OwnerId ulid.ULID
+	TotpGrp TotpGrp
+}
+
+// Reads totpGrp from db and sets corresponding value on receiver. A missing
+// totpGrp is not an error; it simply means the owner has not enrolled.
+func (t *Totp) getTotpTx(binId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("TOTP"))
+
+		totpGrp := b.Get(binId)
+		if totpGrp == nil {
+			t.TotpGrp = TotpGrp{}
+			return nil
+		}
+		return json.Unmarshal(totpGrp, &t.TotpGrp)
+	})
+}
+
+// Writes a pending (not yet confirmed) totpGrp to db, replacing any previous
+// enrollment.
+func (t *Totp) enrollTotpTx(binId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("TOTP"))
+
+		// Marshal totpGrp to be stored.
+		tgJs, err := json.Marshal(t.TotpGrp)
+		if err != nil {
+			return err
+		}
+
+		// Write totpGrp to db.
+		return b.Put(binId, tgJs)
+	})
+}
+
+// Checks a TOTP code or a recovery code against the stored totpGrp, updating
+// the replay, attempt and recovery code state accordingly. When confirm is
+// true, a pending enrollment is activated (and only TOTP codes are accepted).
+func (t *Totp) verifyTotpTx(binId []byte, code string, confirm bool) error {
+	var verifyErr error
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("TOTP"))
+
+		// Retrieve totpGrp.
+		totpGrp := b.Get(binId)
+		if totpGrp == nil {
+			return fmt.Errorf("totp is not enrolled")
+		}
+		// Unmarshal totpGrp into t.
+		err := json.Unmarshal(totpGrp, &t.TotpGrp)
+		if err != nil {
+			return err
+		}
+
+		if confirm && t.TotpGrp.IsEnrolled {
+			return fmt.Errorf("totp is already confirmed")
+		}
+		if !confirm && !t.TotpGrp.IsEnrolled {
+			return fmt.Errorf("totp is not enrolled")
+		}
+
+		now := time.Now()
+		if now.Before(t.TotpGrp.LockedUntilTs) {
+			return fmt.Errorf("too many failed attempts, try again later")
+		}
+
+		secret, err := decryptSecret(t.TotpGrp.Secret)
+		if err != nil {
+			return err
+		}
+
+		// Check TOTP code, rejecting replays of an already used step.
+		step, ok := verifyTotpCode(secret, code, now)
+		if ok && step <= t.TotpGrp.LastUsedStep {
+			ok = false
+		}
+		if ok {
+			t.TotpGrp.LastUsedStep = step
+		}
+
+		// Fall back to single-use recovery codes.
+		if !ok && !confirm {
+			hashed := hashRecoveryCode(code)
+			for i, rc := range t.TotpGrp.RecoveryCodes {
+				if rc == hashed {
+					t.TotpGrp.RecoveryCodes = append(t.TotpGrp.RecoveryCodes[:i], t.TotpGrp.RecoveryCodes[i+1:]...)
+					ok = true
+					break
+				}
+			}
+		}
+
+		// Adjust attempts and enrollment as necessary. Note that the failure
+		// is recorded in db, so it is reported after the update commits.
+		if ok {
+			t.TotpGrp.FailedAttempts = 0
+			if confirm {
+				t.TotpGrp.IsEnrolled = true
+				t.TotpGrp.EnrolledTs = now
+			}
+		} else {
+			t.TotpGrp.FailedAttempts++
+			if t.TotpGrp.FailedAttempts >= maxTotpAttempts {
+				t.TotpGrp.FailedAttempts = 0
+				t.TotpGrp.LockedUntilTs = now.Add(totpLockoutDuration)
+			}
+			verifyErr = fmt.Errorf("invalid code")
+		}
+
+		// Marshal totpGrp to be stored.
+		tgJs, err := json.Marshal(t.TotpGrp)
+		if err != nil {
+			return err
+		}
+
+		// Write totpGrp back to db.
+		return b.Put(binId, tgJs)
+	})
+
+	if err != nil {
+		return err
+	}
+	return verifyErr
+}
+
+// Removes totpGrp from db.
+func (t *Totp) disableTotpTx(binId []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("TOTP"))
+		return b.Delete(binId)
+	})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,6 +10,8 @@ import (
 	bolt "go.etcd.io/bbolt"
 )
 
+var errUserNotFound = errors.New("user does not exist")
+
 type AuthGrp struct {
 	LoginCode     int       `json:"loginCode"`
 	LoginAttempts int       `json:"loginAttempts"`
@@ -41,6 +44,22 @@ func (u *User) authMiddlewareTx(binId []byte) error {
 	})
 }
 
+// Checks if userId exists in USER_MODERATOR bucket.
+func (u *User) moderatorTx(binId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		if !isModerator(tx, binId) {
+			return fmt.Errorf("user is not a moderator")
+		}
+		return nil
+	})
+}
+
+// Returns true if userId exists in USER_MODERATOR bucket, within an existing
+// db transaction.
+func isModerator(tx *bolt.Tx, binId []byte) bool {
+	return tx.Bucket([]byte("USER_MODERATOR")).Get(binId) != nil
+}
+
 // Writes email and authGrp to database.
 func (u *User) signupTx(binId []byte) error {
 	return db.Update(func(tx *bolt.Tx) error {
@@ -79,9 +98,12 @@ func (u *User) signupTx(binId []byte) error {
 	})
 }
 
-// Reads userId and authGrp from db and sets AuthGrp value on receiver.
+// Reads userId and authGrp from db and sets AuthGrp value on receiver. If the
+// user is locked out by too many incorrect login codes, a new code is
+// generated and their attempts reset, which also ends their existing sessions
+// since tokens are signed with the code.
 func (u *User) loginTx() error {
-	return db.View(func(tx *bolt.Tx) error {
+	return db.Update(func(tx *bolt.Tx) error {
 		eb := tx.Bucket([]byte("USER_EMAIL"))
 		ab := tx.Bucket([]byte("USER_AUTH"))
 		// Retrieve userId from db with email lookup.
@@ -104,7 +126,17 @@ func (u *User) loginTx() error {
 		if err != nil {
 			return err
 		}
-		return nil
+		if u.AuthGrp.LoginAttempts < maxLoginCodeAttempts {
+			return nil
+		}
+		// Issue a new code, and write it back to db.
+		u.AuthGrp.LoginCode = generateLoginCode()
+		u.AuthGrp.LoginAttempts = 0
+		agJs, err := json.Marshal(u.AuthGrp)
+		if err != nil {
+			return err
+		}
+		return ab.Put(binId, agJs)
 	})
 }
 
@@ -126,7 +158,7 @@ func (u *User) loginCodeTx(binId []byte, code int) error {
 		}
 		// If loginAttempts have been exceeded, return error.
 		if u.AuthGrp.LoginAttempts >= maxLoginCodeAttempts {
-			return fmt.Errorf("login attempts exceeded")
+			return fmt.Errorf("login attempts exceeded, please login again for a new code")
 		}
 		// Check loginCode and adust loginAttempts as necessary.
 		if u.AuthGrp.LoginCode == code {
@@ -171,3 +203,16 @@ func (u *User) logoutTx(binId []byte) error {
 		return nil
 	})
 }
+
+// Reads the email of the user with the provided binId, and sets it on the
+// receiver.
+func (u *User) getEmailTx(binId []byte) error {
+	return db.View(func(tx *bolt.Tx) error {
+		email, err := getUserEmail(tx, binId)
+		if err != nil {
+			return err
+		}
+		u.Email = email
+		return nil
+	})
+}
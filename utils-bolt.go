@@ -30,3 +30,12 @@ func createUlid() (ulid.ULID, []byte) {
 
 	return id, binId
 }
+
+// Concatenates a parent and child key (e.g. eximId + revisionId) for use as a
+// db key. Since ULIDs sort by creation time, seeking a cursor to the parent key
+// (as a prefix) iterates the parent's children in the order they were created.
+func compositeKey(parentBinId []byte, childBinId []byte) []byte {
+	key := make([]byte, 0, len(parentBinId)+len(childBinId))
+	key = append(key, parentBinId...)
+	return append(key, childBinId...)
+}
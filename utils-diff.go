@@ -0,0 +1,104 @@
+package main
+
+import (
+	"regexp"
+)
+
+// Above this many token comparisons a word-level diff is not worth computing;
+// a line-level diff is computed instead. Above this many line comparisons, the
+// whole text is shown as deleted and inserted.
+const maxDiffCells = 400000
+
+var diffTokenRX = regexp.MustCompile(`\s+|[^\s]+`)
+var diffLineRX = regexp.MustCompile(`[^\n]*\n|[^\n]+`)
+
+// A run of text that is unchanged ("equal"), or only present in the old
+// ("delete") or new ("insert") text.
+type diffSegment struct {
+	Op   string
+	Text string
+}
+
+// Computes a word-level diff from a to b, falling back to a line-level diff
+// for long texts (see maxDiffCells).
+func diffWords(a string, b string) []diffSegment {
+	if segments, ok := diffTokens(diffTokenRX.FindAllString(a, -1), diffTokenRX.FindAllString(b, -1)); ok {
+		return segments
+	}
+	if segments, ok := diffTokens(diffLineRX.FindAllString(a, -1), diffLineRX.FindAllString(b, -1)); ok {
+		return segments
+	}
+	return appendSegment(appendSegment(nil, "delete", a), "insert", b)
+}
+
+// Computes a diff from tokens at to bt using their longest common subsequence,
+// after skipping any common prefix and suffix. Returns false if the remaining
+// tokens would need more than maxDiffCells comparisons.
+func diffTokens(at []string, bt []string) ([]diffSegment, bool) {
+	var segments []diffSegment
+	for len(at) > 0 && len(bt) > 0 && at[0] == bt[0] {
+		segments = appendSegment(segments, "equal", at[0])
+		at, bt = at[1:], bt[1:]
+	}
+	var suffix []string
+	for len(at) > 0 && len(bt) > 0 && at[len(at)-1] == bt[len(bt)-1] {
+		suffix = append(suffix, at[len(at)-1])
+		at, bt = at[:len(at)-1], bt[:len(bt)-1]
+	}
+	if len(at)*len(bt) > maxDiffCells {
+		return nil, false
+	}
+
+	// lcs[i][j] is the length of the LCS of at[i:] and bt[j:].
+	lcs := make([][]int, len(at)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bt)+1)
+	}
+	for i := len(at) - 1; i >= 0; i-- {
+		for j := len(bt) - 1; j >= 0; j-- {
+			if at[i] == bt[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(at) && j < len(bt) {
+		switch {
+		case at[i] == bt[j]:
+			segments = appendSegment(segments, "equal", at[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			segments = appendSegment(segments, "delete", at[i])
+			i++
+		default:
+			segments = appendSegment(segments, "insert", bt[j])
+			j++
+		}
+	}
+	for ; i < len(at); i++ {
+		segments = appendSegment(segments, "delete", at[i])
+	}
+	for ; j < len(bt); j++ {
+		segments = appendSegment(segments, "insert", bt[j])
+	}
+	for k := len(suffix) - 1; k >= 0; k-- {
+		segments = appendSegment(segments, "equal", suffix[k])
+	}
+	return segments, true
+}
+
+// Appends text to the last segment if it has the same op, else a new segment.
+func appendSegment(segments []diffSegment, op string, text string) []diffSegment {
+	if text == "" {
+		return segments
+	}
+	if n := len(segments); n > 0 && segments[n-1].Op == op {
+		segments[n-1].Text += text
+		return segments
+	}
+	return append(segments, diffSegment{Op: op, Text: text})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiffWords(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []diffSegment
+	}{
+		{"unchanged", "a b", "a b", []diffSegment{{"equal", "a b"}}},
+		{"word replaced", "a b c", "a x c", []diffSegment{{"equal", "a "}, {"delete", "b"}, {"insert", "x"}, {"equal", " c"}}},
+		{"word inserted", "a c", "a b c", []diffSegment{{"equal", "a "}, {"insert", "b "}, {"equal", "c"}}},
+		{"from empty", "", "a", []diffSegment{{"insert", "a"}}},
+		{"to empty", "a", "", []diffSegment{{"delete", "a"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffWords(tt.a, tt.b); !slices.Equal(got, tt.want) {
+				t.Errorf("diffWords(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffWordsLong(t *testing.T) {
+	// Too many words to compare, but few enough lines.
+	var a, b []string
+	for i := 0; i < 200; i++ {
+		a = append(a, strings.Repeat("a ", 10)+"\n")
+		b = append(b, strings.Repeat("b ", 10)+"\n")
+	}
+	oldText, newText := strings.Join(a, ""), strings.Join(b, "")
+
+	got := diffWords(oldText, newText+oldText)
+	want := []diffSegment{{"insert", newText}, {"equal", oldText}}
+	if !slices.Equal(got, want) {
+		t.Errorf("diffWords() of long texts = %d segments, want a line-level insertion", len(got))
+	}
+
+	// The result must always rebuild both texts.
+	for _, pair := range [][2]string{{oldText, newText}, {oldText + newText, newText + oldText}} {
+		var rebuiltA, rebuiltB strings.Builder
+		for _, s := range diffWords(pair[0], pair[1]) {
+			if s.Op != "insert" {
+				rebuiltA.WriteString(s.Text)
+			}
+			if s.Op != "delete" {
+				rebuiltB.WriteString(s.Text)
+			}
+		}
+		if rebuiltA.String() != pair[0] || rebuiltB.String() != pair[1] {
+			t.Errorf("diffWords() segments do not rebuild the texts")
+		}
+	}
+}
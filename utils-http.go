@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/oklog/ulid"
 )
@@ -13,6 +17,11 @@ type errorResponse struct {
 	Error string `json:"error"`
 }
 
+type validationErrorResponse struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields"`
+}
+
 func sendErrorResponse(w http.ResponseWriter, err error, statusCode int) {
 	errRes := errorResponse{Error: err.Error()}
 	w.Header().Set("Content-Type", "application/json")
@@ -20,6 +29,16 @@ func sendErrorResponse(w http.ResponseWriter, err error, statusCode int) {
 	json.NewEncoder(w).Encode(errRes)
 }
 
+// Responds with 422 Unprocessable Entity, listing every invalid field (field
+// name to error message) so that clients can display them all at once.
+func sendValidationErrorResponse(w http.ResponseWriter, fieldErrors map[string]string) {
+	const statusUnprocessableEntity = 422
+	errRes := validationErrorResponse{Error: "validation failed", Fields: fieldErrors}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusUnprocessableEntity)
+	json.NewEncoder(w).Encode(errRes)
+}
+
 func verifyContentType(w http.ResponseWriter, req *http.Request) error {
 	contentType := req.Header.Get("Content-Type")
 	mediaType, _, err := mime.ParseMediaType(contentType)
@@ -106,3 +125,98 @@ func setUserIdFromContext(w http.ResponseWriter, dst *ulid.ULID, req *http.Reque
 	*dst = id
 	return nil
 }
+
+// Gets the userId provided by optionalAuthMiddleware or sessionMiddleware, or
+// the zero ULID if the request is anonymous.
+func getViewerId(req *http.Request) ulid.ULID {
+	id, _ := req.Context().Value(userIdContextKey).(ulid.ULID)
+	return id
+}
+
+// Gets context provided by adminIdentityMiddleware. Set the ULID value at
+// pointer destination. Send error response if required type assertion fails.
+func setAdminIdFromContext(w http.ResponseWriter, dst *ulid.ULID, req *http.Request) error {
+	id, ok := req.Context().Value(adminIdContextKey).(ulid.ULID)
+	if !ok {
+		err := fmt.Errorf("bad value for adminId provided by admin middleware")
+		fmt.Printf("[err][api] reading context: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return err
+	}
+	*dst = id
+	return nil
+}
+
+// Checks the Elevation-Authorization header for a valid elevation token, but
+// only if the provided admin or moderator has enrolled in TOTP. Send error
+// response if verification is required and fails.
+func verifyElevation(w http.ResponseWriter, req *http.Request, id ulid.ULID) error {
+	return checkElevation(w, req, id, false)
+}
+
+// Like verifyElevation, but also requires the moderator to have enrolled in
+// TOTP, for the endpoints which reveal members' personal data.
+func requireElevation(w http.ResponseWriter, req *http.Request, id ulid.ULID) error {
+	return checkElevation(w, req, id, true)
+}
+
+func checkElevation(w http.ResponseWriter, req *http.Request, id ulid.ULID, requireEnrolled bool) error {
+	var totp *Totp = new(Totp)
+
+	// Convert ulid to byte slice to use as db key.
+	binId, err := getBinId(w, id)
+	if err != nil {
+		return err
+	}
+
+	// Execute db transaction.
+	if err := totp.getTotpTx(binId); err != nil {
+		fmt.Printf("[err][api] retrieving totpGrp from db: %v [%s]\n", err, cts())
+		sendErrorResponse(w, err, http.StatusInternalServerError)
+		return err
+	}
+
+	if !totp.TotpGrp.IsEnrolled {
+		if requireEnrolled {
+			err := fmt.Errorf("totp enrollment required")
+			sendErrorResponse(w, err, http.StatusForbidden)
+			return err
+		}
+		// TOTP is optional; nothing to verify if not enrolled.
+		return nil
+	}
+
+	if !verifyElevationToken(id.String(), elevationSession(req, id), req.Header.Get("Elevation-Authorization")) {
+		err := fmt.Errorf("totp verification required")
+		sendErrorResponse(w, err, http.StatusUnauthorized)
+		return err
+	}
+	return nil
+}
+
+// Identifies the session in which the subject makes a request, by a hash of
+// their credential: the Admin-Authorization header if it names them, and their
+// Authorization (session) token otherwise. Elevation tokens are bound to it, so
+// that they stop working when the session ends, e.g. on logout, which changes
+// a user's session token.
+func elevationSession(req *http.Request, id ulid.ULID) string {
+	credential := req.Header.Get("Admin-Authorization")
+	if !strings.HasPrefix(credential, id.String()+".") {
+		credential = req.Header.Get("Authorization")
+	}
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:])
+}
+
+// Reads limit from the query string, returning def if it is not set.
+func parseLimit(req *http.Request, def int, max int) (int, error) {
+	s := strings.TrimSpace(req.URL.Query().Get("limit"))
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > max {
+		return 0, fmt.Errorf("limit should be between 1 and %d", max)
+	}
+	return n, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// The longest line, in octets, before it is folded (RFC 5545, section 3.1).
+const icalLineOctets = 75
+
+const icalTimeFormat = "20060102T150405Z"
+
+var icalTextReplacer = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", "")
+
+// Writes events as an iCalendar (RFC 5545) feed with the provided name, for
+// calendar apps to subscribe to. Times are in UTC.
+func (es Events) ical(name string) []byte {
+	var buf bytes.Buffer
+	line := func(format string, a ...any) { writeICalLine(&buf, fmt.Sprintf(format, a...)) }
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//Cooperative Party//Events//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:%s", icalText(name))
+	for _, e := range es {
+		line("BEGIN:VEVENT")
+		line("UID:%s@cooperativeparty.org", e.EventId)
+		line("DTSTAMP:%s", e.UpdatedTs.UTC().Format(icalTimeFormat))
+		line("DTSTART:%s", e.StartTs.UTC().Format(icalTimeFormat))
+		line("DTEND:%s", e.EndTs.UTC().Format(icalTimeFormat))
+		line("SEQUENCE:%d", e.Sequence)
+		line("SUMMARY:%s", icalText(e.Title))
+		if e.Description != "" {
+			line("DESCRIPTION:%s", icalText(e.Description))
+		}
+		switch {
+		case e.Location != "":
+			line("LOCATION:%s", icalText(e.Location))
+		case e.OnlineLink != "":
+			line("LOCATION:%s", icalText(e.OnlineLink))
+		}
+		if e.OnlineLink != "" {
+			line("URL:%s", e.OnlineLink)
+		}
+		if e.IsCancelled {
+			line("STATUS:CANCELLED")
+		} else {
+			line("STATUS:CONFIRMED")
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return buf.Bytes()
+}
+
+// Escapes text for use as an iCalendar property value.
+func icalText(s string) string {
+	return icalTextReplacer.Replace(s)
+}
+
+// Writes a content line, folding it into lines of at most icalLineOctets
+// octets without splitting a character, and ending each with CRLF.
+func writeICalLine(buf *bytes.Buffer, line string) {
+	n := 0
+	for _, r := range line {
+		size := len(string(r))
+		if n+size > icalLineOctets {
+			// Continuation lines begin with a space, which counts towards
+			// their length.
+			buf.WriteString("\r\n ")
+			n = 1
+		}
+		buf.WriteString(string(r))
+		n += size
+	}
+	buf.WriteString("\r\n")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWriteICalLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"short", "SUMMARY:Meeting", "SUMMARY:Meeting\r\n"},
+		{"exactly one line", strings.Repeat("a", 75), strings.Repeat("a", 75) + "\r\n"},
+		{"folded", strings.Repeat("a", 76), strings.Repeat("a", 75) + "\r\n a\r\n"},
+		{"continuations count the space", strings.Repeat("a", 75+74+1), strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 74) + "\r\n a\r\n"},
+		{"characters are not split", strings.Repeat("a", 74) + "é", strings.Repeat("a", 74) + "\r\n é\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeICalLine(&buf, tt.line)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("writeICalLine(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteICalLineUnfolds(t *testing.T) {
+	line := "DESCRIPTION:" + strings.Repeat("Coöperative 🌱 ", 40)
+	var buf bytes.Buffer
+	writeICalLine(&buf, line)
+
+	for _, l := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(l) > icalLineOctets {
+			t.Errorf("folded line is %d octets, want at most %d", len(l), icalLineOctets)
+		}
+		if !utf8.ValidString(l) {
+			t.Errorf("folded line %q splits a character", l)
+		}
+	}
+	if got := strings.ReplaceAll(buf.String(), "\r\n ", ""); got != line+"\r\n" {
+		t.Errorf("unfolded line = %q, want %q", got, line+"\r\n")
+	}
+}
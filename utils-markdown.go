@@ -0,0 +1,203 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// Supports the small subset of Markdown which exim sections need: headings,
+// paragraphs, unordered and ordered lists, emphasis, strong emphasis, inline
+// code and links. Raw HTML is not supported; everything the author writes is
+// escaped, and only http(s) links are kept (see isAllowedLink).
+
+var mdHeadingRX = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+var mdUnorderedItemRX = regexp.MustCompile(`^\s{0,3}[-*+]\s+(.*)$`)
+var mdOrderedItemRX = regexp.MustCompile(`^\s{0,3}\d{1,9}[.)]\s+(.*)$`)
+
+// Exim sections are rendered below their own heading (h3), so a top level
+// Markdown heading becomes an h4.
+const mdHeadingOffset = 3
+
+// Renders Markdown to sanitized HTML.
+func renderMarkdown(src string) template.HTML {
+	var b strings.Builder
+
+	var paragraph []string
+	var listTag string
+	var items []string
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>")
+			b.WriteString(renderInlineMarkdown(strings.Join(paragraph, " ")))
+			b.WriteString("</p>\n")
+			paragraph = nil
+		}
+	}
+	flushList := func() {
+		if listTag != "" {
+			b.WriteString("<" + listTag + ">\n")
+			for _, item := range items {
+				b.WriteString("<li>")
+				b.WriteString(renderInlineMarkdown(item))
+				b.WriteString("</li>\n")
+			}
+			b.WriteString("</" + listTag + ">\n")
+			listTag, items = "", nil
+		}
+	}
+	addItem := func(tag string, text string) {
+		flushParagraph()
+		if listTag != tag {
+			flushList()
+			listTag = tag
+		}
+		items = append(items, strings.TrimSpace(text))
+	}
+
+	for _, line := range strings.Split(src, "\n") {
+		if isBlank(line) {
+			flushParagraph()
+			flushList()
+			continue
+		}
+		if m := mdHeadingRX.FindStringSubmatch(line); m != nil {
+			flushParagraph()
+			flushList()
+			level := min(len(m[1])+mdHeadingOffset, 6)
+			tag := "h" + string(rune('0'+level))
+			b.WriteString("<" + tag + ">" + renderInlineMarkdown(m[2]) + "</" + tag + ">\n")
+			continue
+		}
+		if m := mdUnorderedItemRX.FindStringSubmatch(line); m != nil {
+			addItem("ul", m[1])
+			continue
+		}
+		if m := mdOrderedItemRX.FindStringSubmatch(line); m != nil {
+			addItem("ol", m[1])
+			continue
+		}
+		// An indented line continues the current list item.
+		if listTag != "" && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			items[len(items)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		flushList()
+		paragraph = append(paragraph, strings.TrimSpace(line))
+	}
+	flushParagraph()
+	flushList()
+
+	return template.HTML(b.String())
+}
+
+// Renders the inline Markdown of a single block, escaping everything else.
+func renderInlineMarkdown(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); {
+		rest := s[i:]
+
+		// Backslash escapes a punctuation character.
+		if rest[0] == '\\' && len(rest) > 1 && strings.ContainsRune("\\`*_[]()#+-.!", rune(rest[1])) {
+			b.WriteString(html.EscapeString(rest[1:2]))
+			i += 2
+			continue
+		}
+
+		// Inline code is not processed further.
+		if rest[0] == '`' {
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				b.WriteString("<code>" + html.EscapeString(rest[1:1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+		}
+
+		// Links, kept only if their URL is allowed.
+		if rest[0] == '[' {
+			if text, url, n, ok := parseMarkdownLink(rest); ok {
+				if isAllowedLink(url) {
+					b.WriteString(`<a href="` + html.EscapeString(url) + `" rel="nofollow ugc noopener">`)
+					b.WriteString(renderInlineMarkdown(text))
+					b.WriteString("</a>")
+				} else {
+					b.WriteString(renderInlineMarkdown(text))
+				}
+				i += n
+				continue
+			}
+		}
+
+		// Strong emphasis, then emphasis. An underscore within a word (e.g.
+		// snake_case) is not emphasis.
+		if rest[0] == '*' || (rest[0] == '_' && (i == 0 || !isWordByte(s[i-1]))) {
+			delim := rest[:1]
+			tag := "em"
+			if strings.HasPrefix(rest, delim+delim) {
+				delim, tag = delim+delim, "strong"
+			}
+			if inner, n, ok := parseMarkdownDelimited(rest, delim); ok {
+				b.WriteString("<" + tag + ">" + renderInlineMarkdown(inner) + "</" + tag + ">")
+				i += n
+				continue
+			}
+		}
+
+		b.WriteString(html.EscapeString(rest[:1]))
+		i++
+	}
+
+	return b.String()
+}
+
+// Parses "[text](url)" at the start of s, returning the text, url and the
+// number of bytes consumed. Parentheses within the url must be balanced (e.g.
+// https://en.wikipedia.org/wiki/Foo_(bar)).
+func parseMarkdownLink(s string) (string, string, int, bool) {
+	closeText := strings.Index(s, "](")
+	if closeText < 1 {
+		return "", "", 0, false
+	}
+	closeUrl, depth := -1, 0
+	for i := closeText + 2; i < len(s) && closeUrl < 0; i++ {
+		switch {
+		case s[i] == '(':
+			depth++
+		case s[i] == ')' && depth > 0:
+			depth--
+		case s[i] == ')':
+			closeUrl = i - closeText - 2
+		}
+	}
+	if closeUrl < 1 {
+		return "", "", 0, false
+	}
+	text := s[1:closeText]
+	url := strings.TrimSpace(s[closeText+2 : closeText+2+closeUrl])
+	if strings.ContainsAny(url, " \t") {
+		return "", "", 0, false
+	}
+	return text, url, closeText + 2 + closeUrl + 1, true
+}
+
+// Parses text enclosed by delim at the start of s, returning the enclosed text
+// and the number of bytes consumed. The enclosed text cannot be empty, nor
+// start or end with a space.
+func parseMarkdownDelimited(s string, delim string) (string, int, bool) {
+	end := strings.Index(s[len(delim):], delim)
+	if end < 1 {
+		return "", 0, false
+	}
+	inner := s[len(delim) : len(delim)+end]
+	if strings.TrimSpace(inner) != inner {
+		return "", 0, false
+	}
+	return inner, len(delim) + end + len(delim), true
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"empty", "", ""},
+		{"paragraphs", "one\ntwo\n\nthree", "<p>one two</p>\n<p>three</p>\n"},
+		{"headings are offset", "# Top\n### Third\n###### Sixth", "<h4>Top</h4>\n<h6>Third</h6>\n<h6>Sixth</h6>\n"},
+		{"closing hashes", "## Costs ##", "<h5>Costs</h5>\n"},
+		{"unordered list", "- a\n* b\n  continued", "<ul>\n<li>a</li>\n<li>b continued</li>\n</ul>\n"},
+		{"ordered list", "1. a\n2) b", "<ol>\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"list kinds are separate", "- a\n1. b", "<ul>\n<li>a</li>\n</ul>\n<ol>\n<li>b</li>\n</ol>\n"},
+		{"paragraph ends list", "- a\nb", "<ul>\n<li>a</li>\n</ul>\n<p>b</p>\n"},
+		{"emphasis", "*a* _b_ **c** __d__", "<p><em>a</em> <em>b</em> <strong>c</strong> <strong>d</strong></p>\n"},
+		{"nested emphasis", "**a _b_ c**", "<p><strong>a <em>b</em> c</strong></p>\n"},
+		{"snake_case is not emphasis", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"unclosed emphasis", "2 * 3 = 6", "<p>2 * 3 = 6</p>\n"},
+		{"inline code is escaped", "`<b>*x*</b>`", "<p><code>&lt;b&gt;*x*&lt;/b&gt;</code></p>\n"},
+		{"backslash escapes", `\*not emphasis\*`, "<p>*not emphasis*</p>\n"},
+		{"link", "[the *plan*](https://example.org/a?b=1&c=2)", `<p><a href="https://example.org/a?b=1&amp;c=2" rel="nofollow ugc noopener">the <em>plan</em></a></p>` + "\n"},
+		{"parentheses in link", "[Foo](https://en.wikipedia.org/wiki/Foo_(bar)).", `<p><a href="https://en.wikipedia.org/wiki/Foo_(bar)" rel="nofollow ugc noopener">Foo</a>.</p>` + "\n"},
+		{"unbalanced parentheses are not a link", "[a](https://x.org/(b)", "<p>[a](https://x.org/(b)</p>\n"},
+		{"disallowed link keeps its text", "[click](javascript:alert(1))", "<p>click</p>\n"},
+		{"raw html is escaped", `<script>alert("x")</script>`, "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(renderMarkdown(tt.src)); got != tt.want {
+				t.Errorf("renderMarkdown(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto"
+	"crypto/hmac"
 	cryptoRand "crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -95,6 +96,14 @@ func verifySignature(msg, signature string) bool {
 	return true
 }
 
+// Derives a 32-byte key for the provided purpose from the private key, so that
+// secrets used for different purposes are independent of each other.
+func deriveKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, x509.MarshalPKCS1PrivateKey(cpPrivateKey))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
 // Generates a 6 digit code for password-less login.
 func generateLoginCode() int {
 	return mathRand.Intn(900000) + 100000
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Words shorter than this are not indexed.
+const minSearchTermChars = 2
+
+// Number of words shown around the first match in a snippet.
+const snippetWordsBefore = 8
+const snippetWords = 30
+
+var searchTokenRX = regexp.MustCompile(`[\p{L}\p{N}]+|[^\p{L}\p{N}]+`)
+var searchWordRX = regexp.MustCompile(`^[\p{L}\p{N}]+$`)
+
+// Common English words which carry no meaning for search.
+var searchStopWords = map[string]bool{
+	"a": true, "about": true, "after": true, "all": true, "also": true, "an": true,
+	"and": true, "any": true, "are": true, "as": true, "at": true, "be": true,
+	"been": true, "but": true, "by": true, "can": true, "could": true, "do": true,
+	"does": true, "for": true, "from": true, "had": true, "has": true, "have": true,
+	"he": true, "her": true, "his": true, "how": true, "i": true, "if": true,
+	"in": true, "into": true, "is": true, "it": true, "its": true, "more": true,
+	"most": true, "no": true, "not": true, "of": true, "on": true, "one": true,
+	"or": true, "our": true, "she": true, "should": true, "so": true, "some": true,
+	"such": true, "than": true, "that": true, "the": true, "their": true,
+	"them": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "those": true, "to": true, "was": true, "we": true, "were": true,
+	"what": true, "when": true, "where": true, "which": true, "who": true,
+	"will": true, "with": true, "would": true, "you": true, "your": true,
+}
+
+// A run of snippet text, which either matched a search term or did not.
+type searchSegment struct {
+	Text    string `json:"text"`
+	IsMatch bool   `json:"isMatch"`
+}
+
+// Splits text into lowercase, stemmed search terms, skipping stop words.
+// Terms are returned in order of appearance, including repeats.
+func searchTerms(text string) []string {
+	var terms []string
+	for _, tok := range searchTokenRX.FindAllString(text, -1) {
+		if term, ok := searchTerm(tok); ok {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// Converts a single token to its search term, if it should be indexed.
+func searchTerm(tok string) (string, bool) {
+	if !searchWordRX.MatchString(tok) {
+		return "", false
+	}
+	word := strings.ToLower(tok)
+	if searchStopWords[word] {
+		return "", false
+	}
+	term := stemWord(word)
+	if len([]rune(term)) < minSearchTermChars {
+		return "", false
+	}
+	return term, true
+}
+
+// Reduces an English word to an approximate stem by removing common
+// inflectional and derivational suffixes, so that e.g. "housing", "houses" and
+// "housed" are all found by "house". This is a light variant of Porter's
+// algorithm; it need not produce real words, only be applied consistently to
+// indexed text and queries.
+func stemWord(w string) string {
+	if len(w) <= 3 || !isAsciiLower(w) {
+		return w
+	}
+
+	// Plurals.
+	switch {
+	case strings.HasSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ies"):
+		w = w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "ss"), strings.HasSuffix(w, "us"), strings.HasSuffix(w, "is"):
+	case strings.HasSuffix(w, "s"):
+		w = w[:len(w)-1]
+	}
+
+	// Past tense and participles, restoring a final "e" or undoubling a
+	// consonant where the suffix removed it (e.g. "hoping", "planned").
+	for _, suffix := range []string{"ingly", "edly", "ing", "ed"} {
+		stem, ok := strings.CutSuffix(w, suffix)
+		if !ok || len(stem) < 3 || !hasVowel(stem) {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(stem, "at"), strings.HasSuffix(stem, "bl"), strings.HasSuffix(stem, "iz"):
+			stem += "e"
+		case isDoubleConsonant(stem) && !strings.ContainsAny(stem[len(stem)-1:], "lsz"):
+			stem = stem[:len(stem)-1]
+		}
+		w = stem
+		break
+	}
+
+	// Derivational suffixes, longest first.
+	for _, r := range [][2]string{
+		{"ational", "ate"}, {"ization", "ize"}, {"fulness", "ful"}, {"ousness", "ous"},
+		{"iveness", "ive"}, {"tional", "tion"}, {"ement", ""}, {"ment", ""},
+		{"ness", ""}, {"ful", ""}, {"ly", ""},
+	} {
+		stem, ok := strings.CutSuffix(w, r[0])
+		if ok && len(stem) >= 3 {
+			w = stem + r[1]
+			break
+		}
+	}
+
+	// Final "e", so that "house" and "housing" share a stem.
+	if stem, ok := strings.CutSuffix(w, "e"); ok && len(stem) >= 3 {
+		w = stem
+	}
+	return w
+}
+
+func isAsciiLower(w string) bool {
+	for i := 0; i < len(w); i++ {
+		if w[i] < 'a' || w[i] > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func hasVowel(w string) bool {
+	return strings.ContainsAny(w, "aeiouy")
+}
+
+func isDoubleConsonant(w string) bool {
+	n := len(w)
+	return n >= 2 && w[n-1] == w[n-2] && !strings.ContainsAny(w[n-1:], "aeiou")
+}
+
+// Extracts a window of text around the first word matching one of the terms,
+// split into matching and non-matching segments. Returns nil if no word
+// matches.
+func searchSnippet(text string, terms map[string]bool) []searchSegment {
+	toks := searchTokenRX.FindAllString(text, -1)
+
+	// Find the first match, and the token index of each word.
+	var words []int
+	first := -1
+	for i, tok := range toks {
+		if !searchWordRX.MatchString(tok) {
+			continue
+		}
+		if term, ok := searchTerm(tok); ok && first < 0 && terms[term] {
+			first = len(words)
+		}
+		words = append(words, i)
+	}
+	if first < 0 {
+		return nil
+	}
+
+	// Select the window of words, then the tokens that span it.
+	startWord := max(first-snippetWordsBefore, 0)
+	endWord := min(startWord+snippetWords, len(words))
+	start, end := words[startWord], words[endWord-1]+1
+	if startWord == 0 {
+		start = 0
+	}
+	if endWord == len(words) {
+		end = len(toks)
+	}
+
+	var segments []searchSegment
+	if start > 0 {
+		segments = appendSearchSegment(segments, "… ", false)
+	}
+	for _, tok := range toks[start:end] {
+		term, ok := searchTerm(tok)
+		segments = appendSearchSegment(segments, tok, ok && terms[term])
+	}
+	if end < len(toks) {
+		segments = appendSearchSegment(segments, " …", false)
+	}
+	return segments
+}
+
+// Appends text to segments, merging it into the last segment if that has the
+// same match status.
+func appendSearchSegment(segments []searchSegment, text string, isMatch bool) []searchSegment {
+	if n := len(segments); n > 0 && segments[n-1].IsMatch == isMatch {
+		segments[n-1].Text += text
+		return segments
+	}
+	return append(segments, searchSegment{Text: text, IsMatch: isMatch})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStemWord(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []string
+	}{
+		{"plurals", []string{"house", "houses"}},
+		{"participles restore final e", []string{"hope", "hoping", "hoped"}},
+		{"participles undouble consonants", []string{"plan", "planned", "planning"}},
+		{"double l, s and z are kept", []string{"fall", "falling"}},
+		{"ies", []string{"policy", "policies"}},
+		{"sses", []string{"class", "classes"}},
+		{"derivational suffixes", []string{"hope", "hopeful"}},
+		{"ness", []string{"kind", "kindness"}},
+		{"adverbs", []string{"quick", "quickly"}},
+		{"ational", []string{"relate", "relational"}},
+		{"housing", []string{"house", "housing", "housed", "houses"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := stemWord(tt.words[0])
+			for _, word := range tt.words[1:] {
+				if got := stemWord(word); got != want {
+					t.Errorf("stemWord(%q) = %q, want %q (as for %q)", word, got, want, tt.words[0])
+				}
+			}
+		})
+	}
+}
+
+func TestStemWordUnchanged(t *testing.T) {
+	for _, word := range []string{"bus", "gas", "sing", "thesis", "status", "café", "co2"} {
+		if got := stemWord(word); got != word {
+			t.Errorf("stemWord(%q) = %q, want it unchanged", word, got)
+		}
+	}
+}
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"The houses and the housing", []string{"hous", "hous"}},
+		{"Plans, planned; PLANNING!", []string{"plan", "plan", "plan"}},
+	}
+
+	for _, tt := range tests {
+		if got := searchTerms(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("searchTerms(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
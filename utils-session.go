@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	cryptoRand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+const sessionCookieName = "cp_session"
+const csrfCookieName = "cp_csrf"
+const csrfFieldName = "csrf_token"
+const sessionCookieMaxAge = 30 * 24 * time.Hour
+
+const csrfTokenContextKey = contextKeyType("csrfToken")
+
+// Sets the session cookie to the provided token (as issued by handleLoginCode).
+// The cookie is not readable by scripts, and is not sent on cross-site POSTs.
+func setSessionCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Instructs the browser to delete the session cookie.
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Resolves a token to its userId, applying the same checks as authMiddleware.
+func resolveSessionToken(token string) (ulid.ULID, error) {
+	var user *User = new(User)
+
+	var parts = strings.Split(token, ".")
+	if len(parts) != 2 {
+		return user.UserId, fmt.Errorf("session token should consist of two parts")
+	}
+
+	userId, binId, err := parseUlidString(parts[0])
+	if err != nil {
+		return user.UserId, err
+	}
+
+	// Execute db transaction.
+	if err := user.authMiddlewareTx(binId); err != nil {
+		return user.UserId, err
+	}
+
+	// Verify signature against current session info (see authMiddleware).
+	currentSessionInfo := fmt.Sprintf("%s.%s", strconv.Itoa(user.AuthGrp.LoginCode), user.AuthGrp.LogoutTs)
+	if !verifySignature(currentSessionInfo, parts[1]) {
+		return user.UserId, fmt.Errorf("session token is no longer valid")
+	}
+
+	// Suspended and banned users may not use their sessions.
+	if err := user.checkSanctionsTx(binId); err != nil {
+		return user.UserId, err
+	}
+
+	return userId, nil
+}
+
+// Computes the CSRF token for the provided CSRF cookie value. Forms must echo
+// this token back, which a cross-site attacker can't compute without the key.
+func csrfTokenFor(cookieValue string) string {
+	mac := hmac.New(sha256.New, deriveKey("csrf"))
+	mac.Write([]byte(cookieValue))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Resolves the session cookie (if any) to the logged in user, and protects
+// form POSTs against cross-site request forgery. Unlike authMiddleware, a
+// missing or stale session is not an error; the page simply renders for an
+// anonymous visitor. Call next handler in chain with userId (if logged in)
+// and CSRF token in context.
+func sessionMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	// Return a closure that captures and calls the "next" handler in the call chain.
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+
+		// Set userId on context if session cookie is valid.
+		if c, err := req.Cookie(sessionCookieName); err == nil {
+			userId, err := resolveSessionToken(c.Value)
+			if err != nil {
+				fmt.Printf("[err][api] resolving session cookie: %v [%s]\n", err, cts())
+				clearSessionCookie(w)
+			} else {
+				ctx = context.WithValue(ctx, userIdContextKey, userId)
+			}
+		}
+
+		// Ensure a random CSRF cookie exists, which the CSRF token is derived from.
+		var csrfCookieValue string
+		if c, err := req.Cookie(csrfCookieName); err == nil && c.Value != "" {
+			csrfCookieValue = c.Value
+		} else {
+			buf := make([]byte, 32)
+			if _, err := io.ReadFull(cryptoRand.Reader, buf); err != nil {
+				fmt.Printf("[err][api] generating csrf cookie: %v [%s]\n", err, cts())
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			csrfCookieValue = base64.URLEncoding.EncodeToString(buf)
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    csrfCookieValue,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		csrfToken := csrfTokenFor(csrfCookieValue)
+		ctx = context.WithValue(ctx, csrfTokenContextKey, csrfToken)
+
+		// Every state-changing request must carry the CSRF token.
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			if !hmac.Equal([]byte(req.PostFormValue(csrfFieldName)), []byte(csrfToken)) {
+				fmt.Printf("[err][api] verifying csrf token for %s [%s]\n", req.URL.Path, cts())
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+
+		// Call the next handler in the chain with context.
+		next.ServeHTTP(w, req.WithContext(ctx))
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"slices"
+)
+
+// The outcome of a poll. Winners are option indexes; more than one means a
+// tie, and none means no ballots were cast.
+//
+// Approval polls have a single round, counting the ballots which approve of
+// each option. Instant-runoff polls have a round per count: each ballot counts
+// for its highest ranked option still in the running, and the options with the
+// fewest votes are eliminated until one has a majority. Schulze polls have no
+// rounds; Preferences[i][j] is the number of ballots ranking option i above
+// option j, and Strengths[i][j] the strength of the strongest path from i to j.
+type PollResults struct {
+	PollId      string       `json:"pollId"`
+	Method      string       `json:"method"`
+	BallotCount int          `json:"ballotCount"`
+	Winners     []int        `json:"winners"`
+	Rounds      []TallyRound `json:"rounds"`
+	Preferences [][]int      `json:"preferences,omitempty"`
+	Strengths   [][]int      `json:"strengths,omitempty"`
+}
+
+// A round of counting. Exhausted is the number of ballots which rank none of
+// the options still in the running.
+type TallyRound struct {
+	Tallies    []OptionTally `json:"tallies"`
+	Eliminated []int         `json:"eliminated,omitempty"`
+	Exhausted  int           `json:"exhausted"`
+}
+
+type OptionTally struct {
+	Option int `json:"option"`
+	Votes  int `json:"votes"`
+}
+
+// Tallies ballots for a poll with the provided method and number of options.
+// Ballots should have been checked against the poll, see checkBallot.
+func tallyBallots(method string, options int, ballots []Ballot) PollResults {
+	results := PollResults{Method: method, BallotCount: len(ballots), Winners: []int{}, Rounds: []TallyRound{}}
+	switch method {
+	case pollMethodApproval:
+		tallyApproval(&results, options, ballots)
+	case pollMethodInstantRunoff:
+		tallyInstantRunoff(&results, options, ballots)
+	case pollMethodSchulze:
+		tallySchulze(&results, options, ballots)
+	}
+	if len(ballots) == 0 {
+		results.Winners = []int{}
+	}
+	return results
+}
+
+func tallyApproval(results *PollResults, options int, ballots []Ballot) {
+	votes := make([]int, options)
+	for _, ballot := range ballots {
+		for _, choice := range ballot.Choices {
+			votes[choice]++
+		}
+	}
+
+	round := TallyRound{}
+	for option, n := range votes {
+		round.Tallies = append(round.Tallies, OptionTally{Option: option, Votes: n})
+		if n == slices.Max(votes) {
+			results.Winners = append(results.Winners, option)
+		}
+	}
+	results.Rounds = append(results.Rounds, round)
+}
+
+// Every option tied for fewest votes is eliminated in the same round. If all
+// options still in the running are tied, they are the winners.
+func tallyInstantRunoff(results *PollResults, options int, ballots []Ballot) {
+	running := make([]bool, options)
+	for option := range running {
+		running[option] = true
+	}
+
+	for {
+		votes := make([]int, options)
+		round := TallyRound{}
+		for _, ballot := range ballots {
+			i := slices.IndexFunc(ballot.Choices, func(choice int) bool { return running[choice] })
+			if i < 0 {
+				round.Exhausted++
+				continue
+			}
+			votes[ballot.Choices[i]]++
+		}
+
+		fewest := len(ballots)
+		var remaining []int
+		for option := range votes {
+			if running[option] {
+				round.Tallies = append(round.Tallies, OptionTally{Option: option, Votes: votes[option]})
+				remaining = append(remaining, option)
+				fewest = min(fewest, votes[option])
+			}
+		}
+
+		// A majority of the ballots which still count wins.
+		counted := len(ballots) - round.Exhausted
+		for _, option := range remaining {
+			if votes[option]*2 > counted {
+				results.Winners = []int{option}
+				results.Rounds = append(results.Rounds, round)
+				return
+			}
+		}
+
+		for _, option := range remaining {
+			if votes[option] == fewest {
+				round.Eliminated = append(round.Eliminated, option)
+			}
+		}
+		if len(round.Eliminated) == len(remaining) {
+			round.Eliminated = nil
+			results.Winners = remaining
+			results.Rounds = append(results.Rounds, round)
+			return
+		}
+		for _, option := range round.Eliminated {
+			running[option] = false
+		}
+		results.Rounds = append(results.Rounds, round)
+	}
+}
+
+func tallySchulze(results *PollResults, options int, ballots []Ballot) {
+	d := make([][]int, options)
+	p := make([][]int, options)
+	for i := range d {
+		d[i] = make([]int, options)
+		p[i] = make([]int, options)
+	}
+
+	// Options a ballot leaves out rank below every option it includes, and
+	// equal to each other.
+	for _, ballot := range ballots {
+		for n, i := range ballot.Choices {
+			for j := range options {
+				if !slices.Contains(ballot.Choices[:n+1], j) {
+					d[i][j]++
+				}
+			}
+		}
+	}
+
+	// Strongest paths, by the Floyd–Warshall algorithm.
+	for i := range p {
+		for j := range p {
+			if i != j && d[i][j] > d[j][i] {
+				p[i][j] = d[i][j]
+			}
+		}
+	}
+	for i := range p {
+		for j := range p {
+			if i == j {
+				continue
+			}
+			for k := range p {
+				if i != k && j != k {
+					p[j][k] = max(p[j][k], min(p[j][i], p[i][k]))
+				}
+			}
+		}
+	}
+
+	for i := range p {
+		wins := true
+		for j := range p {
+			wins = wins && (i == j || p[i][j] >= p[j][i])
+		}
+		if wins {
+			results.Winners = append(results.Winners, i)
+		}
+	}
+	results.Preferences = d
+	results.Strengths = p
+}
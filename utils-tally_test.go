@@ -0,0 +1,138 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// Returns n copies of a ballot with the provided choices.
+func testBallots(n int, choices ...int) []Ballot {
+	ballots := make([]Ballot, n)
+	for i := range ballots {
+		ballots[i] = Ballot{Choices: choices}
+	}
+	return ballots
+}
+
+func TestTallyBallotsWinners(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		options int
+		ballots [][]Ballot
+		want    []int
+	}{
+		{"approval", pollMethodApproval, 3, [][]Ballot{testBallots(1, 0, 1), testBallots(1, 1), testBallots(1, 1, 2)}, []int{1}},
+		{"approval tie", pollMethodApproval, 3, [][]Ballot{testBallots(1, 0), testBallots(1, 2)}, []int{0, 2}},
+		{"approval without ballots", pollMethodApproval, 2, nil, []int{}},
+		{"instant-runoff majority", pollMethodInstantRunoff, 3, [][]Ballot{testBallots(3, 0), testBallots(2, 1)}, []int{0}},
+		{"instant-runoff transfer", pollMethodInstantRunoff, 3, [][]Ballot{testBallots(3, 0), testBallots(2, 1, 0), testBallots(4, 2, 1)}, []int{0}},
+		{"instant-runoff tie", pollMethodInstantRunoff, 2, [][]Ballot{testBallots(1, 0), testBallots(1, 1)}, []int{0, 1}},
+		{"instant-runoff without ballots", pollMethodInstantRunoff, 2, nil, []int{}},
+		{"schulze condorcet winner", pollMethodSchulze, 3, [][]Ballot{testBallots(2, 0, 1, 2), testBallots(1, 1, 0, 2), testBallots(1, 2, 0, 1)}, []int{0}},
+		{"schulze symmetric cycle", pollMethodSchulze, 3, [][]Ballot{testBallots(1, 0, 1, 2), testBallots(1, 1, 2, 0), testBallots(1, 2, 0, 1)}, []int{0, 1, 2}},
+		{
+			// The example from Schulze's paper, with a cycle A > C > B > E > A
+			// among others, which E wins.
+			"schulze cycle", pollMethodSchulze, 5, [][]Ballot{
+				testBallots(5, 0, 2, 1, 4, 3),
+				testBallots(5, 0, 3, 4, 2, 1),
+				testBallots(8, 1, 4, 3, 0, 2),
+				testBallots(3, 2, 0, 1, 4, 3),
+				testBallots(7, 2, 0, 4, 1, 3),
+				testBallots(2, 2, 1, 0, 3, 4),
+				testBallots(7, 3, 2, 4, 1, 0),
+				testBallots(8, 4, 1, 0, 3, 2),
+			}, []int{4},
+		},
+		{"schulze unranked options rank last", pollMethodSchulze, 3, [][]Ballot{testBallots(2, 1), testBallots(1, 0, 2)}, []int{1}},
+		{"schulze without ballots", pollMethodSchulze, 2, nil, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ballots := slices.Concat(tt.ballots...)
+			got := tallyBallots(tt.method, tt.options, ballots)
+			if !slices.Equal(got.Winners, tt.want) {
+				t.Errorf("tallyBallots() winners = %v, want %v", got.Winners, tt.want)
+			}
+			if got.BallotCount != len(ballots) {
+				t.Errorf("tallyBallots() ballot count = %d, want %d", got.BallotCount, len(ballots))
+			}
+		})
+	}
+}
+
+func TestTallyInstantRunoffRounds(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    int
+		ballots    [][]Ballot
+		eliminated [][]int
+		exhausted  []int
+		want       []int
+	}{
+		{
+			name:       "first round majority",
+			options:    3,
+			ballots:    [][]Ballot{testBallots(3, 0), testBallots(2, 1)},
+			eliminated: [][]int{nil},
+			exhausted:  []int{0},
+			want:       []int{0},
+		},
+		{
+			name:       "options tied for fewest are eliminated together",
+			options:    3,
+			ballots:    [][]Ballot{testBallots(2, 0), testBallots(1, 1), testBallots(1, 2, 0)},
+			eliminated: [][]int{{1, 2}, nil},
+			exhausted:  []int{0, 1},
+			want:       []int{0},
+		},
+		{
+			// Once one ballot is exhausted, 3 of the 5 which still count are
+			// a majority.
+			name:       "exhausted ballots do not count towards a majority",
+			options:    3,
+			ballots:    [][]Ballot{testBallots(3, 0), testBallots(1, 1), testBallots(2, 2)},
+			eliminated: [][]int{{1}, nil},
+			exhausted:  []int{0, 1},
+			want:       []int{0},
+		},
+		{
+			name:       "tied after elimination",
+			options:    3,
+			ballots:    [][]Ballot{testBallots(2, 0), testBallots(1, 1), testBallots(2, 2)},
+			eliminated: [][]int{{1}, nil},
+			exhausted:  []int{0, 1},
+			want:       []int{0, 2},
+		},
+		{
+			name:       "all tied",
+			options:    2,
+			ballots:    [][]Ballot{testBallots(1, 0), testBallots(1, 1)},
+			eliminated: [][]int{nil},
+			exhausted:  []int{0},
+			want:       []int{0, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tallyBallots(pollMethodInstantRunoff, tt.options, slices.Concat(tt.ballots...))
+			if !slices.Equal(got.Winners, tt.want) {
+				t.Errorf("tallyBallots() winners = %v, want %v", got.Winners, tt.want)
+			}
+			if len(got.Rounds) != len(tt.eliminated) {
+				t.Fatalf("tallyBallots() has %d rounds, want %d", len(got.Rounds), len(tt.eliminated))
+			}
+			for i, round := range got.Rounds {
+				if !slices.Equal(round.Eliminated, tt.eliminated[i]) {
+					t.Errorf("round %d eliminated %v, want %v", i, round.Eliminated, tt.eliminated[i])
+				}
+				if round.Exhausted != tt.exhausted[i] {
+					t.Errorf("round %d exhausted %d, want %d", i, round.Exhausted, tt.exhausted[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// Holds the dynamic data passed to the html templates. Fields are populated
+// as required by each page.
+type templateData struct {
+	IsAuthenticated bool
+	UserId          ulid.ULID
+	CSRFToken       string
+	Exim            *Exim
+	EximPage        *EximPage
+	Comments        Comments
+	Profile         *Profile
+	Tag             *Tag
+	Tags            Tags
+	Chapter         *Chapter
+	Chapters        Chapters
+	SearchQuery     string
+	SearchResults   EximSearchResults
+	EximChanges     []eximChange
+	Form            any
+}
+
+// Describes one edit of an exim: who made it, when, and the word-level diff of
+// every field that changed.
+type eximChange struct {
+	EditorId   string
+	EditorName string
+	EditedTs   time.Time
+	Fields     []fieldDiff
+}
+
+type fieldDiff struct {
+	Name     string
+	Segments []diffSegment
+}
+
+// Holds the values and validation errors of the signup and login forms, so
+// that they may be re-rendered when a submission is invalid.
+type userForm struct {
+	Email       string
+	UserId      string
+	Message     string
+	FieldErrors map[string]string
+}
+
+// Holds the values and validation errors of the exim create form, and whether
+// the values are being previewed rather than edited.
+type eximForm struct {
+	Exim *Exim
+	// AvailableTags and AvailableChapters are those which may be chosen.
+	AvailableTags     Tags
+	AvailableChapters Chapters
+	MaxSections       int
+	IsPreview         bool
+	FieldErrors       map[string]string
+}
+
+// Fields of the comment form on the exim details page. ParentId is set when
+// replying, and Parent is the comment being replied to.
+type commentForm struct {
+	Body        string
+	ParentId    string
+	Parent      *Comment
+	FieldErrors map[string]string
+}
+
+// Holds the values and validation errors of the profile form.
+type profileForm struct {
+	Profile     *Profile
+	FieldErrors map[string]string
+}
+
+// Holds the values and validation errors of the address form, and the chapter
+// the stored address assigns the member to.
+type addressForm struct {
+	Address     *Address
+	Chapter     *Chapter
+	FieldErrors map[string]string
+}
+
+// The data of the printable list of a petition's signatures, see
+// handleExportPetition.
+type petitionDocument struct {
+	Title        string
+	Petition     *Petition
+	Signatures   PetitionSignatures
+	CSVHash      string
+	CSVSignature string
+	ExportedTs   time.Time
+}
+
+// Creates templateData with the session info provided by sessionMiddleware.
+func newTemplateData(req *http.Request) *templateData {
+	data := &templateData{}
+	if id, ok := req.Context().Value(userIdContextKey).(ulid.ULID); ok {
+		data.IsAuthenticated = true
+		data.UserId = id
+	}
+	if token, ok := req.Context().Value(csrfTokenContextKey).(string); ok {
+		data.CSRFToken = token
+	}
+	return data
+}
+
+// Functions available to every template.
+var templateFuncs = template.FuncMap{
+	// Reports whether a string slice contains a value, e.g. a chosen tag.
+	"hasString": slices.Contains[[]string],
+	// Renders sanitized Markdown, e.g. an exim section.
+	"markdown": renderMarkdown,
+	// Reports whether a string is a userId, i.e. whether a member's profile
+	// may be linked to. Anonymous and deleted authors have none.
+	"isUserId": func(s string) bool {
+		_, err := ulid.ParseStrict(s)
+		return err == nil
+	},
+	// Adds one, e.g. to number items from 1.
+	"inc": func(i int) int { return i + 1 },
+}
+
+// Parses the "base" and all "partial" templates along with the provided page
+// template, and writes the content of the "base" template as the response body.
+func renderPage(w http.ResponseWriter, status int, page string, data *templateData) {
+	// Keep "base" template as first file in the slice, followed by all partials.
+	partials, err := filepath.Glob("./ui/partial-*.tmpl.html")
+	if err != nil {
+		fmt.Printf("[err][api] finding partial template files: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	files := append([]string{"./ui/base.tmpl.html"}, partials...)
+	files = append(files, "./ui/"+page)
+
+	// Read the template files into a template set.
+	ts, err := template.New(filepath.Base(files[0])).Funcs(templateFuncs).ParseFiles(files...)
+	if err != nil {
+		fmt.Printf("[err][api] parsing template file: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+
+	// Use the ExecuteTemplate() method to write the content of the "base"
+	// template as the response body.
+	err = ts.ExecuteTemplate(w, "base", data)
+	if err != nil {
+		fmt.Printf("[err][api] executing template: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// Parses a standalone page template, which does not use the "base" template
+// (e.g. a document meant for printing), and writes the content of the template
+// named after the file as the response body.
+func renderDocument(w http.ResponseWriter, status int, page string, data any) {
+	ts, err := template.New(page).Funcs(templateFuncs).ParseFiles("./ui/" + page)
+	if err != nil {
+		fmt.Printf("[err][api] parsing template file: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+
+	err = ts.ExecuteTemplate(w, page, data)
+	if err != nil {
+		fmt.Printf("[err][api] executing template: %v [%s]\n", err, cts())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	cryptoRand "crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults assumed by virtually
+// every authenticator app, so they are not configurable.
+const totpPeriod = 30
+const totpDigits = 6
+const totpSkewSteps = 1
+const totpIssuer = "Cooperative Party"
+const numRecoveryCodes = 10
+
+// Base32 without padding, as expected in otpauth URIs.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generates a random 160-bit TOTP secret, base32 encoded.
+func generateTotpSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := io.ReadFull(cryptoRand.Reader, secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// Builds the otpauth URI that authenticator apps consume (usually as a QR code).
+func totpUri(secret string, label string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", strconv.Itoa(totpDigits))
+	v.Set("period", strconv.Itoa(totpPeriod))
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(totpIssuer), url.PathEscape(label), v.Encode())
+}
+
+// Returns the time step (RFC 6238 "T") for the provided time.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// Computes the HOTP value (RFC 4226) of the secret for the provided step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// Checks code against the secret, allowing for a small amount of clock skew.
+// Returns the matching step so that callers can reject replays.
+func verifyTotpCode(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := totpStep(now)
+	for i := -totpSkewSteps; i <= totpSkewSteps; i++ {
+		step := current + int64(i)
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Generates single-use recovery codes. Returns the plain codes (to be shown to
+// the user exactly once) and their hashes (to be stored).
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, numRecoveryCodes)
+	hashes := make([]string, numRecoveryCodes)
+	for i := range codes {
+		buf := make([]byte, 6)
+		if _, err := io.ReadFull(cryptoRand.Reader, buf); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(buf))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// Normalizes and hashes a recovery code for storage or comparison.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Encrypts a secret at rest with AES-GCM using a key derived from the server's
+// private key. Returns base64 of nonce+ciphertext.
+func encryptSecret(plaintext string) (string, error) {
+	block, err := aes.NewCipher(deriveKey("totp-secret"))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(cryptoRand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Reverses encryptSecret.
+func decryptSecret(ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(deriveKey("totp-secret"))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted secret is too short")
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Creates an elevation token, which is made up of an expiry (unix seconds) and
+// a key-signed signature of the subject's ULID, their session (see
+// elevationSession) and that same expiry, separated by a period.
+func createElevationToken(id string, session string, expiry time.Time) string {
+	exp := strconv.FormatInt(expiry.Unix(), 10)
+	return fmt.Sprintf("%s.%s", exp, signMessage(fmt.Sprintf("elevate.%s.%s.%s", id, session, exp)))
+}
+
+// Verifies an elevation token for the subject's ULID and session, and that it
+// hasn't expired.
+func verifyElevationToken(id string, session string, token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return false
+	}
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return verifySignature(fmt.Sprintf("elevate.%s.%s.%s", id, session, parts[0]), parts[1])
+}
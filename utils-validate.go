@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Schemes that user-provided links may use. Anything else (javascript:, data:,
+// etc.) is rejected, rather than relying on the template to neutralize it.
+var allowedLinkSchemes = []string{"http", "https"}
+
+// Normalizes user-provided text to NFC, trims surrounding whitespace, and
+// strips control characters. Newlines and tabs are kept only if multiline.
+func normalizeText(s string, multiline bool) string {
+	s = norm.NFC.String(s)
+	s = strings.Map(func(r rune) rune {
+		if r == '\r' {
+			return -1
+		}
+		if multiline && (r == '\n' || r == '\t') {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+// Returns true if the string is empty once whitespace is removed.
+func isBlank(s string) bool {
+	return strings.TrimSpace(s) == ""
+}
+
+// Returns true if the string has no more than n characters (runes, not bytes).
+func maxChars(s string, n int) bool {
+	return utf8.RuneCountInString(s) <= n
+}
+
+// Returns true if the string is an absolute URL with an allowed scheme and a host.
+func isAllowedLink(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, scheme := range allowedLinkSchemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}